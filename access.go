@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"syscall"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -34,24 +35,26 @@ const (
 // checks and this method may not be called. Otherwise, this method is
 // responsible for enforcing permission checks.
 func (n *fuseNode) Access(ctx context.Context, mask uint32) syscall.Errno {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
-	}
+	return n.fusefs.intercept(ctx, newOp(ctx, OpAccess, n.path()), func(ctx context.Context) syscall.Errno {
+		return n.access(ctx, mask)
+	})
+}
 
+// access implements Access
+func (n *fuseNode) access(ctx context.Context, mask uint32) syscall.Errno {
 	// If DefaultPermissions is set, kernel handles permissions
 	// We still implement Access for filesystems that don't use it
-	if n.fusefs.opts.DefaultPermissions {
+	if n.fusefs.options().DefaultPermissions {
 		// Kernel is handling permissions, always allow
 		return 0
 	}
 
 	// Get file info to check permissions
-	info, err := n.fusefs.absFS.Stat(n.path)
+	start := time.Now()
+	info, err := n.backend.fs().Stat(n.path())
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return mapError(err)
+		return n.fusefs.backendError(ctx, err)
 	}
 
 	// Get caller credentials from context
@@ -0,0 +1,163 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// Fallocator is an optional interface that absfs files can implement to
+// support fallocate(2) with all of its modes.
+//
+// Without it, fusefs emulates the modes it can: the default mode extends
+// the file, FALLOC_FL_KEEP_SIZE alone does nothing, and FALLOC_FL_ZERO_RANGE
+// and FALLOC_FL_PUNCH_HOLE write zeros over the range. As in the kernel,
+// FALLOC_FL_PUNCH_HOLE without FALLOC_FL_KEEP_SIZE fails with EINVAL. Other modes fail
+// with EOPNOTSUPP. Files of the host filesystem (*os.File) are allocated
+// with fallocate(2) on Linux.
+type Fallocator interface {
+	// Fallocate allocates, deallocates, zeroes, removes or inserts the
+	// length bytes at offset, as selected by mode, a combination of the
+	// FALLOC_FL_* flags of fallocate(2). Returning an error that matches
+	// errors.ErrUnsupported makes fusefs emulate the mode if it can.
+	Fallocate(mode uint32, offset, length int64) error
+}
+
+// fallocate(2) modes (from <linux/falloc.h>), as passed by the kernel on
+// every platform
+const (
+	fallocKeepSize      = 0x01 // Do not change the file size
+	fallocPunchHole     = 0x02 // Deallocate the range, with KEEP_SIZE
+	fallocCollapseRange = 0x08 // Remove the range, shifting the rest down
+	fallocZeroRange     = 0x10 // Zero the range
+	fallocInsertRange   = 0x20 // Insert a hole, shifting the rest up
+)
+
+// fallocModes are the modes fusefs passes on to backends
+const fallocModes = fallocKeepSize | fallocPunchHole | fallocCollapseRange |
+	fallocZeroRange | fallocInsertRange
+
+// Allocate pre-allocates space for the file (fallocate)
+func (fh *fuseFileHandle) Allocate(ctx context.Context, off uint64, size uint64, mode uint32) syscall.Errno {
+	op := fh.newOp(ctx, OpAllocate)
+	op.Offset = int64(off)
+	op.Size = int64(size)
+	return fh.node.fusefs.intercept(ctx, op, func(ctx context.Context) syscall.Errno {
+		return fh.allocate(ctx, off, size, mode)
+	})
+}
+
+// allocate implements Allocate
+func (fh *fuseFileHandle) allocate(ctx context.Context, off uint64, size uint64, mode uint32) syscall.Errno {
+	file := fh.node.fusefs.handleTracker.Get(fh.handle)
+	if file == nil {
+		fh.node.fusefs.stats.recordError()
+		return syscall.EBADF
+	}
+
+	if mode&^fallocModes != 0 {
+		return syscall.EOPNOTSUPP
+	}
+	if mode&fallocPunchHole != 0 && mode&fallocKeepSize == 0 {
+		return syscall.EINVAL
+	}
+
+	unlock := fh.node.backend.writeLocks.lock(fh.node.StableAttr().Ino, false)
+	defer unlock()
+
+	start := time.Now()
+	err := fallocate(file, mode, int64(off), int64(size))
+	timeBackend(ctx, start)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return syscall.EOPNOTSUPP
+	}
+	if err != nil {
+		return fh.node.fusefs.backendError(ctx, err)
+	}
+
+	fh.node.fusefs.handleTracker.MarkDirty(fh.handle)
+	fh.node.backend.inodes.InvalidateAttr(fh.node.path())
+	return 0
+}
+
+// fallocate applies mode to the range of file, with the backend's support
+// or by emulating it
+func fallocate(file absfs.File, mode uint32, offset, length int64) error {
+	if fallocator, ok := file.(Fallocator); ok {
+		err := fallocator.Fallocate(mode, offset, length)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+
+	if osFile, ok := file.(*os.File); ok {
+		err := fallocateHostFile(osFile, mode, offset, length)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+
+	keepSize := mode&fallocKeepSize != 0
+	switch mode &^ fallocKeepSize {
+	case 0:
+		// Space cannot be reserved through absfs, so only the size changes
+		if keepSize {
+			return nil
+		}
+		if allocator, ok := file.(interface {
+			Allocate(offset int64, length int64) error
+		}); ok {
+			return allocator.Allocate(offset, length)
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		// Only extend the file, don't shrink it
+		if offset+length > info.Size() {
+			return file.Truncate(offset + length)
+		}
+		return nil
+
+	case fallocPunchHole:
+		// Punching a hole never changes the size
+		return writeZeros(file, offset, length, true)
+
+	case fallocZeroRange:
+		return writeZeros(file, offset, length, keepSize)
+	}
+
+	return errors.ErrUnsupported
+}
+
+// writeZeros writes zeros over length bytes at offset of file. With
+// keepSize, the part of the range beyond the end of the file is skipped.
+func writeZeros(file absfs.File, offset, length int64, keepSize bool) error {
+	end := offset + length
+	if keepSize {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		end = min(end, info.Size())
+	}
+
+	buf := GetBuffer(copyChunkSize)
+	defer PutBuffer(buf)
+	buf = buf[:copyChunkSize]
+	clear(buf)
+
+	for offset < end {
+		n, err := file.WriteAt(buf[:min(int64(len(buf)), end-offset)], offset)
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+	return nil
+}
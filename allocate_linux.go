@@ -0,0 +1,40 @@
+package fusefs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fallocateHostFile allocates the range of a file of the host filesystem
+// with fallocate(2)
+func fallocateHostFile(file *os.File, mode uint32, offset, length int64) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var allocErr error
+	err = conn.Control(func(fd uintptr) {
+		for {
+			allocErr = unix.Fallocate(int(fd), mode, offset, length)
+			if allocErr != syscall.EINTR {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Modes the host filesystem lacks are emulated by fusefs if possible
+	if errors.Is(allocErr, syscall.EOPNOTSUPP) {
+		return errors.ErrUnsupported
+	}
+	if allocErr != nil {
+		return &os.PathError{Op: "fallocate", Path: file.Name(), Err: allocErr}
+	}
+	return nil
+}
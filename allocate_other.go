@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fusefs
+
+import (
+	"errors"
+	"os"
+)
+
+// fallocateHostFile is only supported on Linux; fusefs emulates the modes
+// it can elsewhere
+func fallocateHostFile(file *os.File, mode uint32, offset, length int64) error {
+	return errors.ErrUnsupported
+}
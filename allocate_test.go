@@ -0,0 +1,126 @@
+package fusefs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+func TestAllocate_Emulated(t *testing.T) {
+	tests := []struct {
+		name      string
+		off, size uint64
+		mode      uint32
+		want      []byte
+		errno     syscall.Errno
+	}{
+		{"extend", 8, 16, 0, []byte("0123456789abcdef\x00\x00\x00\x00\x00\x00\x00\x00"), 0},
+		{"within", 0, 8, 0, []byte("0123456789abcdef"), 0},
+		{"keep size", 0, 100, fallocKeepSize, []byte("0123456789abcdef"), 0},
+		{"punch hole", 4, 100, fallocPunchHole | fallocKeepSize, []byte("0123\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"), 0},
+		{"zero range", 14, 4, fallocZeroRange, []byte("0123456789abcd\x00\x00\x00\x00"), 0},
+		{"zero range keep size", 14, 4, fallocZeroRange | fallocKeepSize, []byte("0123456789abcd\x00\x00"), 0},
+		{"punch hole without keep size", 4, 4, fallocPunchHole, []byte("0123456789abcdef"), syscall.EINVAL},
+		{"collapse range", 0, 4, fallocCollapseRange, []byte("0123456789abcdef"), syscall.EOPNOTSUPP},
+		{"insert range", 0, 4, fallocInsertRange, []byte("0123456789abcdef"), syscall.EOPNOTSUPP},
+		{"unknown mode", 0, 4, 0x40, []byte("0123456789abcdef"), syscall.EOPNOTSUPP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS(t)
+			f, _ := newTestFileFS(t, fsys, 16)
+			fh := openTestHandle(t, f, "/src", os.O_RDWR)
+
+			if errno := fh.Allocate(context.Background(), tt.off, tt.size, tt.mode); errno != tt.errno {
+				t.Errorf("Allocate = %v, want %v", errno, tt.errno)
+			}
+			if got, _ := fsys.ReadFile("/src"); !bytes.Equal(got, tt.want) {
+				t.Errorf("file = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fallocFS is a memfs whose files implement Fallocator
+type fallocFS struct {
+	*memfs.FileSystem
+	modes []uint32
+}
+
+func (a *fallocFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := a.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &fallocFile{File: file, fs: a}, nil
+}
+
+type fallocFile struct {
+	absfs.File
+	fs *fallocFS
+}
+
+func (a *fallocFile) Fallocate(mode uint32, offset, length int64) error {
+	a.fs.modes = append(a.fs.modes, mode)
+	if mode&fallocZeroRange != 0 {
+		return errors.ErrUnsupported
+	}
+	return nil
+}
+
+func TestAllocate_Fallocator(t *testing.T) {
+	fsys := &fallocFS{FileSystem: newMemFS(t)}
+	f, _ := newTestFileFS(t, fsys, 16)
+	fh := openTestHandle(t, f, "/src", os.O_RDWR)
+
+	// Modes are passed on, including those fusefs cannot emulate
+	if errno := fh.Allocate(context.Background(), 0, 4, fallocCollapseRange); errno != 0 {
+		t.Errorf("Allocate(COLLAPSE_RANGE) = %v, want 0", errno)
+	}
+
+	// Unsupported modes are emulated
+	if errno := fh.Allocate(context.Background(), 0, 4, fallocZeroRange); errno != 0 {
+		t.Errorf("Allocate(ZERO_RANGE) = %v, want 0", errno)
+	}
+	if got, _ := fsys.ReadFile("/src"); string(got) != "\x00\x00\x00\x00456789abcdef" {
+		t.Errorf("file = %q after emulated ZERO_RANGE", got)
+	}
+
+	if len(fsys.modes) != 2 || fsys.modes[0] != fallocCollapseRange || fsys.modes[1] != fallocZeroRange {
+		t.Errorf("Fallocate modes = %v", fsys.modes)
+	}
+}
+
+func TestAllocate_HostFile(t *testing.T) {
+	file, err := os.Create(t.TempDir() + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString("0123456789abcdef")
+
+	f := newFuseFS(newMemFS(t), DefaultMountOptions("/mnt"))
+	fh := &fuseFileHandle{
+		node:   newFuseNode(f, f.root.backend, "/file"),
+		handle: f.handleTracker.Add(file, os.O_RDWR, "/file"),
+	}
+
+	if errno := fh.Allocate(context.Background(), 0, 1<<20, fallocKeepSize); errno != 0 {
+		t.Fatalf("Allocate(KEEP_SIZE) = %v", errno)
+	}
+	if errno := fh.Allocate(context.Background(), 2, 4, fallocZeroRange|fallocKeepSize); errno != 0 {
+		t.Fatalf("Allocate(ZERO_RANGE) = %v", errno)
+	}
+	info, _ := file.Stat()
+	data := make([]byte, 16)
+	file.ReadAt(data, 0)
+	if info.Size() != 16 || string(data) != "01\x00\x00\x00\x006789abcdef" {
+		t.Errorf("file = %q of size %d", data, info.Size())
+	}
+}
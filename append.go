@@ -0,0 +1,38 @@
+package fusefs
+
+import (
+	"sync"
+)
+
+// writeLocks orders the writes to each file of a backend. Writes to
+// handles opened with O_APPEND lock the file exclusively, so that finding
+// the end of the file and writing there is atomic with respect to all
+// other writes of the mount; other writes share the lock. Files share a
+// fixed number of locks by their inode number, which follows the file
+// across renames.
+type writeLocks [64]sync.RWMutex
+
+// lock locks the file with inode number ino for a write, exclusively for
+// appends, and returns the function unlocking it
+func (l *writeLocks) lock(ino uint64, appending bool) func() {
+	mu := &l[ino%uint64(len(l))]
+	if appending {
+		mu.Lock()
+		return mu.Unlock
+	}
+	mu.RLock()
+	return mu.RUnlock
+}
+
+// notifyAppended drops the size and data the kernel has cached for the
+// file of fh after an append landed elsewhere than the offset the kernel
+// passed, where it assumes the data now is. The kernel holds the pages
+// being written until the write returns, so the notification is sent
+// without waiting for it.
+func (fh *fuseFileHandle) notifyAppended() {
+	fh.node.backend.inodes.InvalidateAttr(fh.node.path())
+	if fh.node.fusefs.server == nil {
+		return
+	}
+	go fh.node.NotifyContent(0, 0)
+}
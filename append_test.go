@@ -0,0 +1,121 @@
+package fusefs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// openAppendHandle opens name for appending through a backend file that
+// does not support O_APPEND itself, writing where it is positioned
+func openAppendHandle(t *testing.T, f *FuseFS, name string) *fuseFileHandle {
+	t.Helper()
+	file, err := f.root.backend.fs().OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", name, err)
+	}
+	node := newFuseNode(f, f.root.backend, name)
+	return &fuseFileHandle{node: node, handle: f.handleTracker.Add(file, os.O_WRONLY|os.O_APPEND, name)}
+}
+
+func TestWrite_AppendIgnoresOffset(t *testing.T) {
+	f, data := newTestFileFS(t, newMemFS(t), 64)
+	fh := openAppendHandle(t, f, "/src")
+	f.root.backend.inodes.Cache("/src", &fuse.Attr{Size: 0})
+
+	// The kernel passes the size it last knew of, which may be stale
+	if n, errno := fh.Write(context.Background(), []byte("tail"), 0); errno != 0 || n != 4 {
+		t.Fatalf("Write = %d, %v", n, errno)
+	}
+
+	got, _ := f.root.backend.fs().ReadFile("/src")
+	if want := append(data, "tail"...); !bytes.Equal(got, want) {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+
+	// The stale size is dropped
+	if attr := f.root.backend.inodes.GetCached("/src"); attr != nil {
+		t.Errorf("cached attributes after append = %+v, want none", attr)
+	}
+}
+
+func TestWriteLocks_ByInode(t *testing.T) {
+	var locks writeLocks
+	unlock := locks.lock(1, true)
+
+	// Other inodes are not locked by an append
+	locks.lock(2, false)()
+
+	locked := make(chan struct{})
+	go func() {
+		locks.lock(1, false)()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("write locked the inode during an append")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	unlock()
+	<-locked
+}
+
+func TestWrite_ConcurrentAppends(t *testing.T) {
+	const (
+		writers = 8
+		records = 200
+		size    = 32
+	)
+
+	f, _ := newTestFileFS(t, newMemFS(t), 0)
+	handles := make([]*fuseFileHandle, writers)
+	for i := range handles {
+		handles[i] = openAppendHandle(t, f, "/src")
+	}
+
+	// Each writer appends records of its own byte, all passing offset 0
+	// as a kernel with a stale size would
+	var wg sync.WaitGroup
+	for i, fh := range handles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record := bytes.Repeat([]byte{byte('a' + i)}, size)
+			for range records {
+				if _, errno := fh.Write(context.Background(), record, 0); errno != 0 {
+					t.Errorf("Write = %v", errno)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	got, _ := f.root.backend.fs().ReadFile("/src")
+	if len(got) != writers*records*size {
+		t.Fatalf("file size = %d, want %d", len(got), writers*records*size)
+	}
+
+	// Records are intact, and every record of every writer is there
+	counts := make(map[byte]int)
+	for off := 0; off < len(got); off += size {
+		record := got[off : off+size]
+		if !bytes.Equal(record, bytes.Repeat(record[:1], size)) {
+			t.Fatalf("record at %d is interleaved: %q", off, record)
+		}
+		counts[record[0]]++
+	}
+	for i := range writers {
+		c := byte('a' + i)
+		if counts[c] != records {
+			t.Errorf("writer %c has %d records, want %d", c, counts[c], records)
+		}
+	}
+}
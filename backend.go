@@ -0,0 +1,131 @@
+package fusefs
+
+import (
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/absfs/absfs"
+)
+
+// inodeNamespaceShift is the bit position of the backend index in inode
+// numbers. Each backend of a mount allocates inodes below 1<<48 within its
+// own namespace; go-fuse allocates automatic inode numbers from 1<<63.
+const inodeNamespaceShift = 48
+
+// maxBackends is the number of backends a mount may have, limited by the
+// inode namespace bits that stay clear of go-fuse's automatic inodes
+const maxBackends = 1 << (63 - inodeNamespaceShift)
+
+// backend is an absfs filesystem attached at a path of the mount tree, with
+// its own inode namespace and caches
+type backend struct {
+	// fsys is the filesystem serving the subtree; it is replaced by SwapFS
+	fsys atomic.Pointer[absfs.FileSystem]
+
+	// inodes manages the inode numbers and caches of the subtree
+	inodes *InodeManager
+
+	// rootPath is the directory of fs attached at mountPath
+	rootPath string
+
+	// mountPath is where the subtree is attached, relative to the mount
+	mountPath string
+
+	// hostPath is the absolute host path of the subtree
+	hostPath string
+
+	// dirLocks serializes renames into the directories of the subtree
+	dirLocks dirLocks
+
+	// writeLocks makes appends to the files of the subtree atomic
+	writeLocks writeLocks
+
+	// dirRenameMu serializes emulated directory renames, which share the
+	// journal of the backend
+	dirRenameMu sync.Mutex
+}
+
+// newBackend creates backend number index of a mount
+func newBackend(fsys absfs.FileSystem, opts *MountOptions, index int, mountPath, root string) *backend {
+	inodes := NewInodeManager(
+		opts.MaxCachedInodes,
+		opts.MaxCachedDirs,
+		opts.AttrCacheTTL,
+		opts.DirCacheTTL,
+	)
+	inodes.inodeBase = uint64(index) << inodeNamespaceShift
+
+	b := &backend{
+		inodes:    inodes,
+		rootPath:  cleanRoot(root),
+		mountPath: mountPath,
+		hostPath:  path.Join(path.Clean(opts.Mountpoint), mountPath),
+	}
+	b.fsys.Store(&fsys)
+	return b
+}
+
+// fs returns the filesystem currently serving the subtree
+func (b *backend) fs() absfs.FileSystem {
+	return *b.fsys.Load()
+}
+
+// rootNode creates the node for the root directory of the backend
+func (b *backend) rootNode(f *FuseFS) *fuseNode {
+	return newFuseNode(f, b, b.rootPath)
+}
+
+// backendPath maps p, a path relative to the mount, to the backend serving
+// it and the path within that backend. It returns nil if p is not within
+// any backend.
+func (f *FuseFS) backendPath(p string) (*backend, string) {
+	p = path.Clean("/" + p)
+
+	var found *backend
+	var rel string
+	for _, b := range f.backends {
+		r, ok := withinPath(p, b.mountPath)
+		if ok && (found == nil || len(b.mountPath) > len(found.mountPath)) {
+			found, rel = b, r
+		}
+	}
+	if found == nil {
+		return nil, ""
+	}
+	return found, found.confine(path.Join(found.rootPath, rel))
+}
+
+// withinPath reports whether p is dir or beneath it, returning p relative
+// to dir
+func withinPath(p, dir string) (string, bool) {
+	switch {
+	case dir == "/":
+		return p, true
+	case p == dir:
+		return "/", true
+	case len(p) > len(dir) && p[:len(dir)] == dir && p[len(dir)] == '/':
+		return p[len(dir):], true
+	}
+	return "", false
+}
+
+// inodeStats returns the inode statistics summed over all backends
+func (f *FuseFS) inodeStats() InodeManagerStats {
+	var total InodeManagerStats
+	for _, b := range f.backends {
+		s := b.inodes.Stats()
+		total.TotalInodes += s.TotalInodes
+		total.AttrCache = total.AttrCache.add(s.AttrCache)
+		total.DirCache = total.DirCache.add(s.DirCache)
+	}
+	return total
+}
+
+// configureCaches applies the user-space cache options to all backends
+func (f *FuseFS) configureCaches(opts *MountOptions) {
+	for _, b := range f.backends {
+		b.inodes.SetTTL(opts.AttrCacheTTL, opts.DirCacheTTL)
+		b.inodes.SetMaxSize(opts.MaxCachedInodes, opts.MaxCachedDirs)
+	}
+}
@@ -110,6 +110,52 @@ func (c *lruCache) Delete(key string) {
 	}
 }
 
+// DeleteFunc removes all entries whose key satisfies match.
+// Returns the number of entries removed.
+func (c *lruCache) DeleteFunc(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, elem := range c.items {
+		if match(key) {
+			c.remove(key, elem)
+			removed++
+		}
+	}
+	return removed
+}
+
+// SetTTL changes the TTL of the cache. The new TTL applies to existing
+// entries as well, measured from when they were stored.
+func (c *lruCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+}
+
+// SetMaxSize changes the maximum number of entries. If the cache holds
+// more entries, the least recently used ones are evicted immediately.
+// A maxSize of 0 makes the cache unlimited.
+func (c *lruCache) SetMaxSize(maxSize int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSize = maxSize
+	for c.maxSize > 0 && c.lruList.Len() > c.maxSize {
+		c.evictOldest()
+	}
+}
+
+// TTL returns the current TTL of the cache.
+func (c *lruCache) TTL() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.ttl
+}
+
 // Clear removes all entries from the cache.
 func (c *lruCache) Clear() {
 	c.mu.Lock()
@@ -174,3 +220,32 @@ type CacheStats struct {
 	Evictions uint64  // Number of evictions
 	HitRate   float64 // Hit rate (hits / (hits + misses))
 }
+
+// sub returns the counters accumulated between prev and s; Size and
+// MaxSize are taken from s
+func (s CacheStats) sub(prev CacheStats) CacheStats {
+	out := s
+	out.Hits -= prev.Hits
+	out.Misses -= prev.Misses
+	out.Evictions -= prev.Evictions
+	out.HitRate = 0
+	if total := out.Hits + out.Misses; total > 0 {
+		out.HitRate = float64(out.Hits) / float64(total)
+	}
+	return out
+}
+
+// add returns the combined statistics of the caches s and other
+func (s CacheStats) add(other CacheStats) CacheStats {
+	out := CacheStats{
+		Size:      s.Size + other.Size,
+		MaxSize:   s.MaxSize + other.MaxSize,
+		Hits:      s.Hits + other.Hits,
+		Misses:    s.Misses + other.Misses,
+		Evictions: s.Evictions + other.Evictions,
+	}
+	if total := out.Hits + out.Misses; total > 0 {
+		out.HitRate = float64(out.Hits) / float64(total)
+	}
+	return out
+}
@@ -368,3 +368,34 @@ func TestLRUCache_EmptyEvict(t *testing.T) {
 		t.Errorf("Expected size 1, got %d", cache.Len())
 	}
 }
+
+func TestLRUCache_SetMaxSize(t *testing.T) {
+	cache := newLRUCache(4, 0)
+	for _, key := range []string{"key1", "key2", "key3", "key4"} {
+		cache.Put(key, key)
+	}
+	cache.Get("key1") // key2 is now least recently used
+
+	cache.SetMaxSize(2)
+	if cache.Len() != 2 {
+		t.Fatalf("expected 2 entries after shrinking, got %d", cache.Len())
+	}
+	if _, ok := cache.Get("key2"); ok {
+		t.Error("least recently used key2 should have been evicted")
+	}
+	if _, ok := cache.Get("key1"); !ok {
+		t.Error("recently used key1 should have been kept")
+	}
+	if stats := cache.Stats(); stats.Evictions != 2 || stats.MaxSize != 2 {
+		t.Errorf("expected 2 evictions and MaxSize 2, got %+v", stats)
+	}
+
+	// Growing and unlimited sizes evict nothing
+	cache.SetMaxSize(0)
+	for _, key := range []string{"key5", "key6", "key7"} {
+		cache.Put(key, key)
+	}
+	if cache.Len() != 5 {
+		t.Errorf("expected 5 entries in unlimited cache, got %d", cache.Len())
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/absfs/fusefs"
+)
+
+// runUnmount unmounts a mount served by another process
+func runUnmount(args []string) error {
+	flags := flag.NewFlagSet("unmount", flag.ContinueOnError)
+	lazy := flags.Bool("lazy", false, "detach the mount now and release it once no longer in use")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fusefs unmount [-lazy] MOUNTPOINT")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+
+	mountpoint, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return fusefs.UnmountPath(mountpoint, *lazy)
+}
+
+// runStats prints the statistics of a running mount, read from its
+// control directory or control socket
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	control := flags.String("control", ".fusefs", "name of the mount's control directory")
+	socket := flags.String("socket", "", "path of the mount's control socket, used instead of the control directory")
+	asJSON := flags.Bool("json", false, "print the statistics as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fusefs stats [-control NAME] [-json] MOUNTPOINT\n"+
+			"       fusefs stats -socket PATH [-json]")
+		flags.PrintDefaults()
+	}
+	nargs := 1
+	if slices.ContainsFunc(args, isSocketFlag) {
+		nargs = 0
+	}
+	if err := parseFlags(flags, args, nargs); err != nil {
+		return err
+	}
+
+	var stats fusefs.Stats
+	if *socket != "" {
+		client, err := fusefs.DialControl(*socket)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		if stats, err = client.Stats(); err != nil {
+			return err
+		}
+	} else {
+		data, err := os.ReadFile(filepath.Join(flags.Arg(0), *control, "stats.json"))
+		if err != nil {
+			return fmt.Errorf("reading stats, is the control directory enabled? %w", err)
+		}
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	return printStats(os.Stdout, stats)
+}
+
+// isSocketFlag reports whether arg sets the -socket flag
+func isSocketFlag(arg string) bool {
+	name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+	return strings.HasPrefix(arg, "-") && name == "socket"
+}
+
+// printStats writes a summary of stats to w
+func printStats(w io.Writer, stats fusefs.Stats) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Mountpoint:\t%s\n", stats.Mountpoint)
+	fmt.Fprintf(tw, "Operations:\t%d\n", stats.Operations)
+	fmt.Fprintf(tw, "Errors:\t%d\n", stats.Errors)
+	fmt.Fprintf(tw, "Bytes read:\t%d\n", stats.BytesRead)
+	fmt.Fprintf(tw, "Bytes written:\t%d\n", stats.BytesWritten)
+	fmt.Fprintf(tw, "Open files:\t%d\n", stats.OpenFiles)
+	fmt.Fprintf(tw, "Inodes:\t%d\n", stats.InodeStats.TotalInodes)
+	fmt.Fprintf(tw, "Attr cache hit rate:\t%.1f%%\n", stats.InodeStats.AttrCache.HitRate*100)
+	fmt.Fprintf(tw, "Dir cache hit rate:\t%.1f%%\n", stats.InodeStats.DirCache.HitRate*100)
+
+	if stats.PassthroughOpens+stats.PassthroughFallbacks > 0 {
+		fmt.Fprintf(tw, "Passthrough opens:\t%d (%d fallbacks)\n", stats.PassthroughOpens, stats.PassthroughFallbacks)
+	}
+
+	renames := stats.DirRenames
+	if renames.Completed+renames.Failed+renames.Resumed+renames.RolledBack > 0 || len(renames.Active) > 0 {
+		fmt.Fprintf(tw, "Emulated renames:\t%d completed, %d failed, %d resumed, %d rolled back\n",
+			renames.Completed, renames.Failed, renames.Resumed, renames.RolledBack)
+		for _, p := range renames.Active {
+			fmt.Fprintf(tw, "  %s -> %s:\t%d entries, %d bytes\n", p.Old, p.New, p.Entries, p.Bytes)
+		}
+	}
+
+	if len(stats.Ops) > 0 {
+		names := make([]string, 0, len(stats.Ops))
+		for name := range stats.Ops {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		fmt.Fprintf(tw, "\nOPERATION\tCOUNT\tERRORS\tBYTES\tMEAN\tP99\n")
+		for _, name := range names {
+			op := stats.Ops[name]
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%v\t%v\n", name, op.Count, op.Errors, op.Bytes,
+				op.Latency.Mean(), op.Latency.Quantile(0.99))
+		}
+	}
+	return tw.Flush()
+}
+
+// runList lists the FUSE mounts of the system
+func runList(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	all := flags.Bool("all", false, "include FUSE mounts not created by fusefs")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fusefs list [-all]")
+		flags.PrintDefaults()
+	}
+	if err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	mounts, err := fusefs.ListMounts()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "MOUNTPOINT\tSOURCE\tTYPE\tSTATE\n")
+	for _, m := range mounts {
+		if !m.Fusefs && !*all {
+			continue
+		}
+		state := "mounted"
+		if m.Stale {
+			state = "stale"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.Mountpoint, m.Source, m.FSType, state)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// readyOK is reported on the ready file descriptor once the filesystem
+// is mounted; anything else is an error message
+const readyOK = "ok"
+
+// helperArgs are the arguments mount(8) passes to a mount helper:
+//
+//	mount.fusefs SOURCE MOUNTPOINT [-sfnv] [-o OPTIONS] [-t TYPE]
+type helperArgs struct {
+	source, mountpoint string
+	options            []string
+	fake, verbose      bool
+}
+
+// parseHelperArgs parses the arguments of a mount helper
+func parseHelperArgs(args []string) (*helperArgs, error) {
+	h := &helperArgs{}
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o" || arg == "-t":
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			if arg == "-o" {
+				h.options = append(h.options, args[i])
+			}
+		case strings.HasPrefix(arg, "-o"):
+			h.options = append(h.options, arg[2:])
+		case strings.HasPrefix(arg, "-t"):
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for _, c := range arg[1:] {
+				switch c {
+				case 'f':
+					h.fake = true
+				case 'v':
+					h.verbose = true
+				case 's', 'n':
+					// Sloppy option parsing and not writing /etc/mtab
+					// do not apply
+				default:
+					return nil, fmt.Errorf("unknown flag -%c", c)
+				}
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 2 {
+		return nil, errors.New("usage: mount.fusefs SOURCE MOUNTPOINT [-sfnv] [-o OPTIONS]")
+	}
+	h.source, h.mountpoint = positional[0], positional[1]
+	return h, nil
+}
+
+// mountArgs returns the arguments of the mount command that mounts h
+func (h *helperArgs) mountArgs() []string {
+	args := []string{"mount", "-ready-fd=3"}
+	if h.verbose {
+		args = append(args, "-log=info")
+	}
+	for _, opts := range h.options {
+		args = append(args, "-o", opts)
+	}
+	return append(args, "--", h.source, h.mountpoint)
+}
+
+// runHelper acts as a mount(8) helper. mount(8) waits for the helper to
+// exit, so the filesystem is served by a detached "fusefs mount" process;
+// the helper returns once that process has mounted the filesystem or
+// failed to.
+func runHelper(args []string) error {
+	h, err := parseHelperArgs(args)
+	if err != nil {
+		return err
+	}
+	if h.fake {
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(self, h.mountArgs()...)
+	// Run as "fusefs", not as the helper
+	cmd.Args[0] = "fusefs"
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if h.verbose {
+		cmd.Stderr = os.Stderr
+	}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	result, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if string(result) == readyOK {
+		return cmd.Process.Release()
+	}
+
+	waitErr := cmd.Wait()
+	if len(result) > 0 {
+		return errors.New(string(result))
+	}
+	return fmt.Errorf("fusefs mount exited before mounting: %v", waitErr)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHelperArgs(t *testing.T) {
+	h, err := parseHelperArgs([]string{"/srv/data", "/mnt/data", "-n", "-o", "rw,attr_timeout=5", "-t", "fuse.fusefs"})
+	if err != nil {
+		t.Fatalf("parseHelperArgs: %v", err)
+	}
+	want := []string{"mount", "-ready-fd=3", "-o", "rw,attr_timeout=5", "--", "/srv/data", "/mnt/data"}
+	if args := h.mountArgs(); !reflect.DeepEqual(args, want) {
+		t.Errorf("mountArgs = %q, want %q", args, want)
+	}
+
+	h, err = parseHelperArgs([]string{"-fv", "memfs", "/mnt/tmp", "-onoauto"})
+	if err != nil {
+		t.Fatalf("parseHelperArgs: %v", err)
+	}
+	if !h.fake || !h.verbose || !reflect.DeepEqual(h.options, []string{"noauto"}) {
+		t.Errorf("parseHelperArgs = %+v, want fake, verbose and noauto", h)
+	}
+
+	for _, args := range [][]string{
+		{"/mnt/data"},
+		{"/srv/data", "/mnt/data", "-o"},
+		{"/srv/data", "/mnt/data", "-x"},
+	} {
+		if _, err := parseHelperArgs(args); err == nil {
+			t.Errorf("parseHelperArgs(%q) succeeded", args)
+		}
+	}
+}
+
+func TestMountFlags(t *testing.T) {
+	c := newMountCommand()
+	err := c.flags.Parse([]string{"-ro", "-async_read=false", "-attr_timeout=2.5", "-uid=1000",
+		"-o", "entry_timeout=500ms,noatime", "memfs", "/mnt"})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	opts := c.opts
+	if !opts.ReadOnly || opts.AsyncRead || opts.UID != 1000 {
+		t.Errorf("flags = %+v, want ro, no async_read and uid 1000", opts)
+	}
+	if opts.AttrTimeout != 2500*time.Millisecond || opts.EntryTimeout != 500*time.Millisecond {
+		t.Errorf("timeouts = %v, %v, want 2.5s, 500ms", opts.AttrTimeout, opts.EntryTimeout)
+	}
+	if !reflect.DeepEqual(opts.Options, []string{"noatime"}) {
+		t.Errorf("Options = %q, want [noatime]", opts.Options)
+	}
+	if opts.ControlDir != ".fusefs" {
+		t.Errorf("ControlDir = %q, want .fusefs", opts.ControlDir)
+	}
+
+	c = newMountCommand()
+	c.flags.SetOutput(io.Discard)
+	if err := c.flags.Parse([]string{"-max_write=x"}); err == nil {
+		t.Error("invalid -max_write was accepted")
+	}
+}
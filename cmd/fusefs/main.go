@@ -0,0 +1,105 @@
+// Command fusefs mounts directories through fusefs and inspects running
+// mounts.
+//
+// Usage:
+//
+//	fusefs mount [flags] SOURCE MOUNTPOINT
+//	fusefs unmount [-lazy] MOUNTPOINT
+//	fusefs stats [-control NAME] [-json] MOUNTPOINT
+//	fusefs stats -socket PATH [-json]
+//	fusefs list [-all]
+//
+// SOURCE is a local directory, or "memfs" for an empty in-memory
+// filesystem. Every MountOptions field is available as a flag named after
+// its mount option, e.g. -ro or -attr_timeout=5s, and as -o with a mount
+// option string, e.g. -o ro,attr_timeout=5.
+//
+// Installed or linked as /sbin/mount.fusefs or /sbin/mount.fuse.fusefs,
+// the command acts as a mount(8) helper, so that fusefs mounts can be
+// listed in /etc/fstab:
+//
+//	/srv/data  /mnt/data  fusefs  allow_other,attr_timeout=5  0 0
+//	memfs      /mnt/tmp   fusefs  noauto,user                 0 0
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// commands are the subcommands by name
+var commands = map[string]func(args []string) error{
+	"mount":   runMount,
+	"unmount": runUnmount,
+	"stats":   runStats,
+	"list":    runList,
+}
+
+func main() {
+	name := filepath.Base(os.Args[0])
+	if strings.HasPrefix(name, "mount.fusefs") || strings.HasPrefix(name, "mount.fuse.fusefs") {
+		if err := runHelper(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(32) // mount failure, see mount(8)
+		}
+		return
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "-help" {
+			fmt.Fprintf(os.Stderr, "fusefs: unknown command %q\n", os.Args[1])
+		}
+		usage()
+		os.Exit(2)
+	}
+
+	switch err := cmd(os.Args[2:]); {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "fusefs %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// errUsage is returned by commands given invalid flags or arguments, after
+// printing their usage
+var errUsage = errors.New("invalid usage")
+
+// parseFlags parses the flags of a command and checks that nargs
+// arguments remain
+func parseFlags(flags *flag.FlagSet, args []string, nargs int) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if flags.NArg() != nargs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage:
+	fusefs mount [flags] SOURCE MOUNTPOINT
+	fusefs unmount [-lazy] MOUNTPOINT
+	fusefs stats [-control NAME] [-json] MOUNTPOINT
+	fusefs stats -socket PATH [-json]
+	fusefs list [-all]
+
+Run "fusefs COMMAND -h" for the flags of a command.
+`)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/fusefs"
+	"github.com/absfs/memfs"
+)
+
+// memfsSource is the SOURCE that selects an empty in-memory filesystem
+const memfsSource = "memfs"
+
+// mountFlags are the MountOptions fields available as flags, by mount
+// option name
+var mountFlags = []struct {
+	name, usage string
+	isBool      bool
+}{
+	{"ro", "mount read-only", true},
+	{"allow_other", "allow other users to access the mount", true},
+	{"allow_root", "allow root to access the mount", true},
+	{"default_permissions", "let the kernel check permissions", true},
+	{"direct_io", "bypass the page cache", true},
+	{"async_read", "allow concurrent reads", true},
+	{"debug", "dump the FUSE protocol to stderr", true},
+	{"recover_stale", "unmount a stale mount at the mountpoint first", true},
+	{"control_dir_visible", "list the control directory in the root", true},
+	{"emulate_dir_rename", "rename directories by copying them, for backends that cannot", true},
+	{"passthrough", "let the kernel access host files directly (Linux 6.9+)", true},
+	{"fsname", "mount source shown in the mount table (default SOURCE)", false},
+	{"root", "directory of SOURCE to mount as the root", false},
+	{"control_dir", "name of the control directory, empty to disable", false},
+	{"control_socket", "path of a unix socket serving statistics and controls", false},
+	{"uid", "owner of all files", false},
+	{"gid", "group of all files", false},
+	{"max_write", "maximum write size in bytes", false},
+	{"max_readahead", "maximum readahead in bytes", false},
+	{"attr_timeout", "kernel attribute cache timeout, in seconds or e.g. 500ms", false},
+	{"entry_timeout", "kernel entry cache timeout", false},
+	{"attr_cache_ttl", "attribute cache TTL", false},
+	{"dir_cache_ttl", "directory cache TTL", false},
+	{"max_cached_inodes", "size of the inode cache", false},
+	{"max_cached_dirs", "size of the directory cache", false},
+	{"slow_op_threshold", "duration above which operations are logged as slow", false},
+	{"fuse_fd", "serve a /dev/fuse descriptor mounted by a privileged process", false},
+	{"fuse_fd_socket", "receive the /dev/fuse descriptor from a helper at this socket", false},
+}
+
+// optionFlag sets a single mount option through MountOptions.Set, so that
+// flags accept the same values as -o
+type optionFlag struct {
+	opts   *fusefs.MountOptions
+	name   string
+	isBool bool
+}
+
+func (f *optionFlag) String() string {
+	return ""
+}
+
+func (f *optionFlag) Set(value string) error {
+	if !f.isBool {
+		return f.opts.Set(f.name + "=" + value)
+	}
+	set, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if !set {
+		return f.opts.Set("no" + f.name)
+	}
+	return f.opts.Set(f.name)
+}
+
+func (f *optionFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// mountCommand holds the flags of the mount command
+type mountCommand struct {
+	flags    *flag.FlagSet
+	opts     *fusefs.MountOptions
+	logLevel string
+	readyFD  int
+}
+
+// newMountCommand defines the flags of the mount command
+func newMountCommand() *mountCommand {
+	c := &mountCommand{
+		flags: flag.NewFlagSet("mount", flag.ContinueOnError),
+		opts:  fusefs.DefaultMountOptions(""),
+	}
+	c.opts.ControlDir = ".fusefs"
+
+	for _, f := range mountFlags {
+		c.flags.Var(&optionFlag{opts: c.opts, name: f.name, isBool: f.isBool}, f.name, f.usage)
+	}
+	c.flags.Var(c.opts, "o", "mount options, e.g. ro,allow_other,attr_timeout=5")
+	c.flags.StringVar(&c.logLevel, "log", "warn", "log level: debug, info, warn, error or off")
+	c.flags.IntVar(&c.readyFD, "ready-fd", 0, "file descriptor to report the mount result to, used by the mount helper")
+	c.flags.Usage = func() {
+		fmt.Fprintf(c.flags.Output(), "Usage: fusefs mount [flags] SOURCE MOUNTPOINT\n\n"+
+			"SOURCE is a local directory, or %q for an empty in-memory filesystem.\n\n", memfsSource)
+		c.flags.PrintDefaults()
+	}
+	return c
+}
+
+// runMount mounts SOURCE at MOUNTPOINT and serves it until the process is
+// interrupted or the filesystem is unmounted
+func runMount(args []string) error {
+	c := newMountCommand()
+	if err := parseFlags(c.flags, args, 2); err != nil {
+		return err
+	}
+
+	ready := c.readyReporter()
+	fuseFS, err := c.mount(c.flags.Arg(0), c.flags.Arg(1))
+	if err != nil {
+		ready(err)
+		return err
+	}
+	ready(nil)
+
+	return serve(fuseFS)
+}
+
+// mount mounts source at mountpoint with the options given as flags
+func (c *mountCommand) mount(source, mountpoint string) (*fusefs.FuseFS, error) {
+	var fsys absfs.FileSystem
+	if source == memfsSource {
+		memFS, err := memfs.NewFS()
+		if err != nil {
+			return nil, err
+		}
+		fsys = memFS
+	} else {
+		osFS, err := newOSFS(source)
+		if err != nil {
+			return nil, err
+		}
+		fsys = osFS
+		source = osFS.root
+	}
+
+	mountpoint, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+	c.opts.Mountpoint = mountpoint
+	if c.opts.FSName == fusefs.DefaultMountOptions("").FSName {
+		c.opts.FSName = source
+	}
+
+	if c.logLevel != "off" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.logLevel)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", c.logLevel)
+		}
+		c.opts.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	}
+
+	return fusefs.Mount(fsys, c.opts)
+}
+
+// readyReporter returns a function that reports the result of mounting to
+// the ready file descriptor, if any, and closes it
+func (c *mountCommand) readyReporter() func(err error) {
+	if c.readyFD <= 0 {
+		return func(error) {}
+	}
+	ready := os.NewFile(uintptr(c.readyFD), "ready")
+	return func(err error) {
+		if err != nil {
+			fmt.Fprint(ready, err)
+		} else {
+			fmt.Fprint(ready, readyOK)
+		}
+		ready.Close()
+	}
+}
+
+// serve blocks until the process receives SIGINT or SIGTERM, then
+// unmounts fuseFS, or until fuseFS is unmounted externally
+func serve(fuseFS *fusefs.FuseFS) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	exited := make(chan struct{})
+	go func() {
+		fuseFS.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	report, err := fuseFS.UnmountContext(ctx)
+	if err != nil {
+		return err
+	}
+	if len(report.ForceClosed) > 0 {
+		fmt.Fprintf(os.Stderr, "fusefs: closed %d files still open at unmount\n", len(report.ForceClosed))
+	}
+	return nil
+}
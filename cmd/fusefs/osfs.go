@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// osFS serves a directory of the host filesystem. Paths are resolved
+// below the directory and cannot reach outside it with "..". Symbolic
+// link targets are stored and returned unchanged.
+type osFS struct {
+	root string
+
+	mu  sync.RWMutex
+	cwd string
+}
+
+var _ absfs.SymlinkFileSystem = (*osFS)(nil)
+
+// newOSFS creates a filesystem serving the directory root
+func newOSFS(root string) (*osFS, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: root, Err: os.ErrInvalid}
+	}
+	return &osFS{root: root, cwd: "/"}, nil
+}
+
+// hostPath returns the host path of name
+func (o *osFS) hostPath(name string) string {
+	if !path.IsAbs(name) {
+		o.mu.RLock()
+		name = path.Join(o.cwd, name)
+		o.mu.RUnlock()
+	}
+	return filepath.Join(o.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// file converts the result of an os call to an absfs.File, avoiding a
+// non-nil interface holding a nil *os.File
+func file(f *os.File, err error) (absfs.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (o *osFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	return file(os.OpenFile(o.hostPath(name), flag, perm))
+}
+
+func (o *osFS) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(o.hostPath(name), perm)
+}
+
+func (o *osFS) Remove(name string) error {
+	return os.Remove(o.hostPath(name))
+}
+
+func (o *osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(o.hostPath(oldpath), o.hostPath(newpath))
+}
+
+func (o *osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(o.hostPath(name))
+}
+
+func (o *osFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(o.hostPath(name), mode)
+}
+
+func (o *osFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(o.hostPath(name), atime, mtime)
+}
+
+func (o *osFS) Chown(name string, uid, gid int) error {
+	return os.Chown(o.hostPath(name), uid, gid)
+}
+
+func (o *osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(o.hostPath(name))
+}
+
+func (o *osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(o.hostPath(name))
+}
+
+func (o *osFS) Sub(dir string) (fs.FS, error) {
+	return os.DirFS(o.hostPath(dir)), nil
+}
+
+func (o *osFS) Chdir(dir string) error {
+	info, err := o.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: os.ErrInvalid}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !path.IsAbs(dir) {
+		dir = path.Join(o.cwd, dir)
+	}
+	o.cwd = path.Clean("/" + dir)
+	return nil
+}
+
+func (o *osFS) Getwd() (string, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.cwd, nil
+}
+
+func (o *osFS) TempDir() string {
+	return "/tmp"
+}
+
+func (o *osFS) Open(name string) (absfs.File, error) {
+	return file(os.Open(o.hostPath(name)))
+}
+
+func (o *osFS) Create(name string) (absfs.File, error) {
+	return file(os.Create(o.hostPath(name)))
+}
+
+func (o *osFS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(o.hostPath(name), perm)
+}
+
+func (o *osFS) RemoveAll(name string) error {
+	return os.RemoveAll(o.hostPath(name))
+}
+
+func (o *osFS) Truncate(name string, size int64) error {
+	return os.Truncate(o.hostPath(name), size)
+}
+
+func (o *osFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(o.hostPath(name))
+}
+
+func (o *osFS) Lchown(name string, uid, gid int) error {
+	return os.Lchown(o.hostPath(name), uid, gid)
+}
+
+func (o *osFS) Readlink(name string) (string, error) {
+	return os.Readlink(o.hostPath(name))
+}
+
+func (o *osFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, o.hostPath(newname))
+}
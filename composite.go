@@ -0,0 +1,174 @@
+package fusefs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/absfs/absfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// MountMulti mounts several absfs.FileSystems as one tree. Each key of
+// backends is the path at which its filesystem appears, e.g.:
+//
+//	fuseFS, err := fusefs.MountMulti(map[string]absfs.FileSystem{
+//	    "/data":    s3FS,
+//	    "/scratch": memFS,
+//	    "/config":  osFS,
+//	}, fusefs.DefaultMountOptions("/mnt/all"))
+//
+// The root directory and any intermediate directories are synthesized from
+// the mount table and are read-only. Each filesystem has its own inode
+// namespace and caches. Renames and hard links between filesystems fail
+// with EXDEV, so tools like mv fall back to copying.
+//
+// Mount paths may not be "/" or nest inside one another. MountOptions.Root
+// is ignored.
+func MountMulti(backends map[string]absfs.FileSystem, opts *MountOptions) (*FuseFS, error) {
+	if err := prepareMountpoint(opts); err != nil {
+		return nil, err
+	}
+
+	fuseFS, err := newCompositeFuseFS(backends, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range fuseFS.backends {
+		if info, err := b.fs().Stat(b.rootPath); err != nil {
+			return nil, fmt.Errorf("failed to stat root of %s: %w", b.mountPath, err)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("root of %s is not a directory", b.mountPath)
+		}
+	}
+
+	if err := fuseFS.mount(); err != nil {
+		return nil, err
+	}
+	return fuseFS, nil
+}
+
+// newCompositeFuseFS creates a FUSE filesystem adapter serving each backend
+// at its mount path beneath a synthesized root
+func newCompositeFuseFS(backends map[string]absfs.FileSystem, opts *MountOptions) (*FuseFS, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no filesystems to mount")
+	}
+	if len(backends) > maxBackends {
+		return nil, fmt.Errorf("too many filesystems to mount: %d, maximum %d", len(backends), maxBackends)
+	}
+
+	mountPaths := make(map[string]absfs.FileSystem, len(backends))
+	for p, fsys := range backends {
+		clean := path.Clean("/" + p)
+		if clean == "/" {
+			return nil, fmt.Errorf("cannot mount a filesystem at the root of a composite mount; use Mount")
+		}
+		if _, ok := mountPaths[clean]; ok {
+			return nil, fmt.Errorf("duplicate mount path %s", clean)
+		}
+		if opts.ControlDir != "" {
+			if _, ok := withinPath(clean, "/"+opts.ControlDir); ok {
+				return nil, fmt.Errorf("mount path %s conflicts with the control directory", clean)
+			}
+		}
+		mountPaths[clean] = fsys
+	}
+
+	sorted := make([]string, 0, len(mountPaths))
+	for p := range mountPaths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	fuseFS := newFuseFSBase(opts)
+	for i, p := range sorted {
+		for _, other := range sorted[:i] {
+			if _, ok := withinPath(p, other); ok {
+				return nil, fmt.Errorf("mount path %s is nested inside %s", p, other)
+			}
+		}
+		fuseFS.backends = append(fuseFS.backends, newBackend(mountPaths[p], opts, i, p, ""))
+	}
+
+	fuseFS.composite = &compositeDir{fusefs: fuseFS, path: "/"}
+	return fuseFS, nil
+}
+
+// compositeDir is a directory of a composite mount that is synthesized from
+// the mount table rather than served by a backend
+type compositeDir struct {
+	fs.Inode
+	fusefs *FuseFS
+	path   string
+}
+
+var _ fs.NodeOnAdder = (*compositeDir)(nil)
+var _ fs.NodeGetattrer = (*compositeDir)(nil)
+var _ fs.NodeReaddirer = (*compositeDir)(nil)
+
+// OnAdd attaches the backends and synthesized directories beneath d
+func (d *compositeDir) OnAdd(ctx context.Context) {
+	f := d.fusefs
+
+	for _, b := range f.backends {
+		rel, ok := withinPath(b.mountPath, d.path)
+		if !ok || rel == "/" {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(rel, "/"), "/")
+		if d.GetChild(name) != nil {
+			continue
+		}
+
+		childPath := path.Join(d.path, name)
+		var child *fs.Inode
+		if childPath == b.mountPath {
+			child = d.NewPersistentInode(ctx, b.rootNode(f), fs.StableAttr{Mode: syscall.S_IFDIR})
+		} else {
+			child = d.NewPersistentInode(ctx, &compositeDir{fusefs: f, path: childPath}, fs.StableAttr{Mode: syscall.S_IFDIR})
+		}
+		d.AddChild(name, child, false)
+	}
+
+	if d.path == "/" && f.options().ControlDir != "" {
+		d.AddChild(f.options().ControlDir, f.controlInode(ctx), false)
+	}
+}
+
+// Getattr reports synthesized directories as read-only
+func (d *compositeDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = syscall.S_IFDIR | 0555
+	d.fusefs.fillSyntheticAttr(&out.Attr)
+	return 0
+}
+
+// Readdir lists the attached backends and synthesized directories
+func (d *compositeDir) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	opts := d.fusefs.options()
+
+	children := d.Children()
+	names := make([]string, 0, len(children))
+	for name := range children {
+		if d.path == "/" && name == opts.ControlDir && !opts.ControlDirVisible {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]fuse.DirEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fuse.DirEntry{
+			Name: name,
+			Mode: syscall.S_IFDIR,
+			Ino:  children[name].StableAttr().Ino,
+		})
+	}
+	return fs.NewListDirStream(entries), 0
+}
@@ -0,0 +1,158 @@
+package fusefs
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// newTestComposite creates a composite filesystem over placeholder backends
+func newTestComposite(t *testing.T, paths ...string) *FuseFS {
+	t.Helper()
+
+	backends := make(map[string]absfs.FileSystem)
+	for _, p := range paths {
+		backends[p] = &linkFS{}
+	}
+	opts := DefaultMountOptions("/mnt/all")
+	opts.ControlDir = ".fusefs"
+	f, err := newCompositeFuseFS(backends, opts)
+	if err != nil {
+		t.Fatalf("newCompositeFuseFS: %v", err)
+	}
+	return f
+}
+
+func TestCompositeFuseFS_Validation(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+	}{
+		{"empty", nil},
+		{"root", []string{"/"}},
+		{"duplicate", []string{"/data", "data/"}},
+		{"nested", []string{"/data", "/data/inner"}},
+		{"control", []string{"/.fusefs/x"}},
+	}
+
+	for _, tt := range tests {
+		backends := make(map[string]absfs.FileSystem)
+		for _, p := range tt.paths {
+			backends[p] = &linkFS{}
+		}
+		opts := DefaultMountOptions("/mnt/all")
+		opts.ControlDir = ".fusefs"
+		if _, err := newCompositeFuseFS(backends, opts); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestCompositeFuseFS_Tree(t *testing.T) {
+	f := newTestComposite(t, "/data", "/scratch", "/nested/config")
+
+	// Building the node tree attaches the backends beneath the root
+	fs.NewNodeFS(f.rootEmbedder(), &fs.Options{})
+
+	root := f.composite.EmbeddedInode()
+	nested, ok := root.GetChild("nested").Operations().(*compositeDir)
+	if !ok {
+		t.Fatal("/nested is not a synthesized directory")
+	}
+	config, ok := nested.GetChild("config").Operations().(*fuseNode)
+	if !ok || config.backend.mountPath != "/nested/config" || config.path() != "/" {
+		t.Fatalf("/nested/config = %+v", config)
+	}
+
+	stream, errno := f.composite.Readdir(context.Background())
+	if errno != 0 {
+		t.Fatalf("Readdir: %v", errno)
+	}
+	var names []string
+	for stream.HasNext() {
+		entry, _ := stream.Next()
+		names = append(names, entry.Name)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "data,nested,scratch" {
+		t.Errorf("root listing = %s, want data,nested,scratch", got)
+	}
+	if root.GetChild(".fusefs") == nil {
+		t.Error("control directory not attached to composite root")
+	}
+}
+
+func TestCompositeFuseFS_InodeNamespaces(t *testing.T) {
+	f := newTestComposite(t, "/a", "/b")
+	info := &mockFileInfo{name: "file", modTime: time.Now()}
+
+	inoA := f.backends[0].inodes.GetInode("/file", info)
+	inoB := f.backends[1].inodes.GetInode("/file", info)
+	if inoA == inoB {
+		t.Fatalf("backends allocated the same inode %d", inoA)
+	}
+	if inoA>>inodeNamespaceShift != 0 || inoB>>inodeNamespaceShift != 1 {
+		t.Errorf("inodes %#x, %#x not in backend namespaces 0 and 1", inoA, inoB)
+	}
+}
+
+func TestCompositeFuseFS_BackendPath(t *testing.T) {
+	f := newTestComposite(t, "/data", "/scratch")
+
+	tests := []struct {
+		path, mount, want string
+	}{
+		{"/data", "/data", "/"},
+		{"/data/x/y", "/data", "/x/y"},
+		{"/scratch/../data/z", "/data", "/z"},
+		{"/datax", "", ""},
+		{"/", "", ""},
+	}
+	for _, tt := range tests {
+		b, got := f.backendPath(tt.path)
+		mount := ""
+		if b != nil {
+			mount = b.mountPath
+		}
+		if mount != tt.mount || got != tt.want {
+			t.Errorf("backendPath(%q) = %q, %q, want %q, %q", tt.path, mount, got, tt.mount, tt.want)
+		}
+	}
+}
+
+func TestCompositeFuseFS_CrossBackend(t *testing.T) {
+	f := newTestComposite(t, "/a", "/b")
+	a := f.backends[0].rootNode(f)
+	b := f.backends[1].rootNode(f)
+
+	if errno := a.rename(context.Background(), "file", b, "file", 0); errno != syscall.EXDEV {
+		t.Errorf("cross-backend rename = %v, want EXDEV", errno)
+	}
+	if errno := a.rename(context.Background(), "file", f.composite, "file", 0); errno != syscall.EXDEV {
+		t.Errorf("rename into synthesized directory = %v, want EXDEV", errno)
+	}
+	if _, errno := a.link(context.Background(), b, "file", nil); errno != syscall.EXDEV {
+		t.Errorf("cross-backend link = %v, want EXDEV", errno)
+	}
+}
+
+func TestCompositeFuseFS_LinkTargets(t *testing.T) {
+	f := newTestComposite(t, "/data")
+	b := f.backends[0]
+
+	if got := b.linkTargetOut("/x/y"); got != "/mnt/all/data/x/y" {
+		t.Errorf("linkTargetOut = %q", got)
+	}
+	if got := b.linkTargetIn("/mnt/all/data/x"); got != "/x" {
+		t.Errorf("linkTargetIn = %q", got)
+	}
+	if got := b.linkTargetIn("/etc/hosts"); got != "/etc/hosts" {
+		t.Errorf("linkTargetIn(outside) = %q", got)
+	}
+}
@@ -0,0 +1,337 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// The control directory is a virtual directory in the root of the mount,
+// served by fusefs itself rather than the backend, that exposes runtime
+// statistics and controls as plain files:
+//
+//	stats.json       Stats snapshot (read-only)
+//	cache.json       inode manager cache statistics (read-only)
+//	handles.json     open file handles (read-only)
+//	locks.json       held flock and POSIX locks (read-only)
+//	flush            write a path in the mount to drop cached data for its subtree
+//	attr_cache_ttl   read or write the attribute cache TTL, e.g. "10s"
+//	dir_cache_ttl    read or write the directory cache TTL
+//	log_level        read or write the log level: debug, info, warn, error or off
+//
+// For example, with MountOptions.ControlDir set to ".fusefs":
+//
+//	cat /mnt/data/.fusefs/stats.json
+//	echo /projects/foo > /mnt/data/.fusefs/flush
+//	echo 30s > /mnt/data/.fusefs/attr_cache_ttl
+//
+// Writable files may only be written by the user that mounted the
+// filesystem or by root. The same statistics and controls are served to
+// programs by the control socket, see ControlRequest.
+
+// controlDir is the root of the control directory
+type controlDir struct {
+	fs.Inode
+	fusefs *FuseFS
+}
+
+// controlFile is a single file in the control directory. Its content is
+// generated when the file is opened; writes are applied immediately.
+type controlFile struct {
+	fs.Inode
+	fusefs *FuseFS
+	read   func() ([]byte, error)
+	write  func(data string) error
+}
+
+// controlHandle is an open control file
+type controlHandle struct {
+	file *controlFile
+	data []byte
+}
+
+var _ fs.NodeOnAdder = (*controlDir)(nil)
+var _ fs.NodeGetattrer = (*controlDir)(nil)
+var _ fs.NodeOpener = (*controlFile)(nil)
+var _ fs.NodeGetattrer = (*controlFile)(nil)
+var _ fs.NodeSetattrer = (*controlFile)(nil)
+var _ fs.FileReader = (*controlHandle)(nil)
+var _ fs.FileWriter = (*controlHandle)(nil)
+
+// controlInode returns the inode of the control directory, creating it on
+// first use
+func (f *FuseFS) controlInode(ctx context.Context) *fs.Inode {
+	f.controlOnce.Do(func() {
+		f.control = f.rootEmbedder().EmbeddedInode().NewPersistentInode(ctx, &controlDir{fusefs: f}, fs.StableAttr{
+			Mode: syscall.S_IFDIR,
+		})
+	})
+	return f.control
+}
+
+// lookupControl answers a root lookup of the control directory
+func (n *fuseNode) lookupControl(ctx context.Context, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	child := n.fusefs.controlInode(ctx)
+	out.Mode = syscall.S_IFDIR | 0555
+	n.fusefs.fillSyntheticAttr(&out.Attr)
+	out.SetEntryTimeout(n.fusefs.options().EntryTimeout)
+	out.SetAttrTimeout(n.fusefs.options().AttrTimeout)
+	return child, 0
+}
+
+// isControlName reports whether name in directory n is the control
+// directory. The name is reserved: operations creating, removing or
+// renaming it fail without reaching the backend.
+func (n *fuseNode) isControlName(name string) bool {
+	return n.fusefs.options().ControlDir != "" && n == n.fusefs.root && name == n.fusefs.options().ControlDir
+}
+
+// OnAdd populates the control directory
+func (d *controlDir) OnAdd(ctx context.Context) {
+	for name, file := range d.fusefs.controlFiles() {
+		child := d.NewPersistentInode(ctx, file, fs.StableAttr{Mode: syscall.S_IFREG})
+		d.AddChild(name, child, false)
+	}
+}
+
+// controlFiles returns the files of the control directory by name
+func (f *FuseFS) controlFiles() map[string]*controlFile {
+	files := map[string]*controlFile{
+		"stats.json": {read: func() ([]byte, error) {
+			return marshalControl(f.Stats())
+		}},
+		"cache.json": {read: func() ([]byte, error) {
+			return marshalControl(f.inodeStats())
+		}},
+		"handles.json": {read: func() ([]byte, error) {
+			return marshalControl(f.handleTracker.List())
+		}},
+		"locks.json": {read: func() ([]byte, error) {
+			return marshalControl(f.lockManager.List())
+		}},
+		"flush": {write: func(data string) error {
+			if !strings.HasPrefix(data, "/") {
+				return fmt.Errorf("flush: path must be absolute: %w", os.ErrInvalid)
+			}
+			f.invalidateTree(data)
+			return nil
+		}},
+		"attr_cache_ttl": {
+			read: func() ([]byte, error) {
+				return []byte(f.options().AttrCacheTTL.String() + "\n"), nil
+			},
+			write: func(data string) error {
+				ttl, err := parseControlTTL(data)
+				if err != nil {
+					return err
+				}
+				return f.Reconfigure(func(opts *MountOptions) {
+					opts.AttrCacheTTL = ttl
+				})
+			},
+		},
+		"dir_cache_ttl": {
+			read: func() ([]byte, error) {
+				return []byte(f.options().DirCacheTTL.String() + "\n"), nil
+			},
+			write: func(data string) error {
+				ttl, err := parseControlTTL(data)
+				if err != nil {
+					return err
+				}
+				return f.Reconfigure(func(opts *MountOptions) {
+					opts.DirCacheTTL = ttl
+				})
+			},
+		},
+		"log_level": {
+			read: func() ([]byte, error) {
+				return []byte(formatLogLevel(f.LogLevel()) + "\n"), nil
+			},
+			write: func(data string) error {
+				level, err := parseLogLevel(data)
+				if err != nil {
+					return fmt.Errorf("log_level: %v: %w", err, os.ErrInvalid)
+				}
+				f.SetLogLevel(level)
+				return nil
+			},
+		},
+	}
+
+	for _, file := range files {
+		file.fusefs = f
+	}
+	return files
+}
+
+// Getattr reports the control directory as read-only
+func (d *controlDir) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = syscall.S_IFDIR | 0555
+	d.fusefs.fillSyntheticAttr(&out.Attr)
+	return 0
+}
+
+// Getattr reports the file mode from the operations the file supports.
+// The size is reported as zero; content is served with direct I/O.
+func (c *controlFile) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	var perm uint32
+	if c.read != nil {
+		perm |= 0444
+	}
+	if c.write != nil {
+		perm |= 0200
+	}
+	out.Mode = syscall.S_IFREG | perm
+	c.fusefs.fillSyntheticAttr(&out.Attr)
+	return 0
+}
+
+// Setattr accepts truncation so that shell redirection works
+func (c *controlFile) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if c.write == nil {
+		return syscall.EACCES
+	}
+	return c.Getattr(ctx, fh, out)
+}
+
+// Open snapshots the file content for reading and checks write access
+func (c *controlFile) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	writing := flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	if writing {
+		if c.write == nil || !c.fusefs.callerMayControl(ctx) {
+			return nil, 0, syscall.EACCES
+		}
+	} else if c.read == nil {
+		return nil, 0, syscall.EACCES
+	}
+
+	handle := &controlHandle{file: c}
+	if c.read != nil && flags&syscall.O_WRONLY == 0 {
+		data, err := c.read()
+		if err != nil {
+			return nil, 0, mapError(err)
+		}
+		handle.data = data
+	}
+
+	return handle, fuse.FOPEN_DIRECT_IO, 0
+}
+
+// Read serves the content snapshot taken at open
+func (h *controlHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= int64(len(h.data)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(h.data)))
+	return fuse.ReadResultData(h.data[off:end]), 0
+}
+
+// Write applies a control command
+func (h *controlHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	if h.file.write == nil {
+		return 0, syscall.EBADF
+	}
+	if err := h.file.write(strings.TrimSpace(string(data))); err != nil {
+		return 0, mapError(err)
+	}
+	return uint32(len(data)), 0
+}
+
+// fillSyntheticAttr sets the owner and times of files and directories
+// served by fusefs itself: they belong to the mounting user and were
+// last modified when the filesystem was created
+func (f *FuseFS) fillSyntheticAttr(attr *fuse.Attr) {
+	attr.Uid = uint32(os.Getuid())
+	attr.Gid = uint32(os.Getgid())
+	attr.Mtime = uint64(f.started.Unix())
+	attr.Ctime = attr.Mtime
+	attr.Atime = attr.Mtime
+}
+
+// callerMayControl reports whether the calling process may write control
+// files: only the mounting user and root may
+func (f *FuseFS) callerMayControl(ctx context.Context) bool {
+	caller, ok := fuse.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	return caller.Uid == 0 || caller.Uid == uint32(os.Getuid())
+}
+
+// invalidateTree drops cached data for path, relative to the mount root,
+// and everything beneath it, in user space and, where the inodes are
+// known, in the kernel
+func (f *FuseFS) invalidateTree(p string) {
+	p = path.Clean("/" + p)
+	if b, backendPath := f.backendPath(p); b != nil {
+		b.inodes.InvalidateTree(backendPath)
+	}
+	// Backends attached beneath p are flushed entirely
+	for _, b := range f.backends {
+		if _, ok := withinPath(b.mountPath, p); ok && b.mountPath != p {
+			b.inodes.InvalidateTree(b.rootPath)
+		}
+	}
+	if f.server == nil {
+		return
+	}
+
+	// Find the inode for p in the kernel-visible tree
+	node := f.rootEmbedder().EmbeddedInode()
+	parent := (*fs.Inode)(nil)
+	name := ""
+	for _, component := range strings.Split(strings.Trim(p, "/"), "/") {
+		if component == "" {
+			continue
+		}
+		child := node.GetChild(component)
+		if child == nil {
+			// Not known to the kernel; only the entry may be cached
+			node.NotifyEntry(component)
+			return
+		}
+		parent, name, node = node, component, child
+	}
+
+	if parent != nil {
+		parent.NotifyEntry(name)
+	}
+	notifyTree(node)
+}
+
+// notifyTree asks the kernel to drop cached content for node and its
+// known descendants
+func notifyTree(node *fs.Inode) {
+	node.NotifyContent(0, 0)
+	for name, child := range node.Children() {
+		node.NotifyEntry(name)
+		notifyTree(child)
+	}
+}
+
+// parseControlTTL parses a TTL written to a control file
+func parseControlTTL(data string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(data)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid TTL %q: %w", data, os.ErrInvalid)
+	}
+	return ttl, nil
+}
+
+// marshalControl renders v as indented JSON for a control file
+func marshalControl(v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,234 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// readControl reads the content of a control file through a handle
+func readControl(t *testing.T, file *controlFile) string {
+	t.Helper()
+
+	fh, _, errno := file.Open(context.Background(), syscall.O_RDONLY)
+	if errno != 0 {
+		t.Fatalf("Open: %v", errno)
+	}
+	result, errno := fh.(*controlHandle).Read(context.Background(), make([]byte, 1<<16), 0)
+	if errno != 0 {
+		t.Fatalf("Read: %v", errno)
+	}
+	data, _ := result.Bytes(nil)
+	return string(data)
+}
+
+// writeControl writes data to a control file as the given uid
+func writeControl(file *controlFile, uid uint32, data string) syscall.Errno {
+	ctx := &fuse.Context{Caller: fuse.Caller{Owner: fuse.Owner{Uid: uid}}}
+	fh, _, errno := file.Open(ctx, syscall.O_WRONLY|syscall.O_TRUNC)
+	if errno != 0 {
+		return errno
+	}
+	_, errno = fh.(*controlHandle).Write(ctx, []byte(data), 0)
+	return errno
+}
+
+func TestControlFiles_Stats(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/mnt/data"))
+	f.stats.recordOperation()
+	f.handleTracker.Add(newMockFile("/open.txt"), syscall.O_RDONLY, "/open.txt")
+	files := f.controlFiles()
+
+	var stats Stats
+	if err := json.Unmarshal([]byte(readControl(t, files["stats.json"])), &stats); err != nil {
+		t.Fatalf("stats.json: %v", err)
+	}
+	if stats.Operations != 1 || stats.Mountpoint != "/mnt/data" || stats.OpenFiles != 1 {
+		t.Errorf("stats.json = %+v", stats)
+	}
+
+	var handles []HandleInfo
+	if err := json.Unmarshal([]byte(readControl(t, files["handles.json"])), &handles); err != nil {
+		t.Fatalf("handles.json: %v", err)
+	}
+	if len(handles) != 1 || handles[0].Path != "/open.txt" {
+		t.Errorf("handles.json = %+v", handles)
+	}
+
+	// Read-only files cannot be opened for writing
+	if errno := writeControl(files["stats.json"], uint32(0), "x"); errno != syscall.EACCES {
+		t.Errorf("write stats.json = %v, want EACCES", errno)
+	}
+}
+
+func TestControlFiles_TTL(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/mnt/data"))
+	files := f.controlFiles()
+
+	if got := readControl(t, files["attr_cache_ttl"]); got != "5s\n" {
+		t.Errorf("attr_cache_ttl = %q, want 5s", got)
+	}
+
+	if errno := writeControl(files["attr_cache_ttl"], 0, "30s\n"); errno != 0 {
+		t.Fatalf("write attr_cache_ttl: %v", errno)
+	}
+	if errno := writeControl(files["dir_cache_ttl"], 0, "1m"); errno != 0 {
+		t.Fatalf("write dir_cache_ttl: %v", errno)
+	}
+	if f.backends[0].inodes.AttrTTL() != 30*time.Second || f.backends[0].inodes.DirTTL() != time.Minute {
+		t.Errorf("TTLs = %v/%v, want 30s/1m", f.backends[0].inodes.AttrTTL(), f.backends[0].inodes.DirTTL())
+	}
+
+	if errno := writeControl(files["attr_cache_ttl"], 0, "soon"); errno != syscall.EINVAL {
+		t.Errorf("invalid TTL = %v, want EINVAL", errno)
+	}
+}
+
+func TestControlFiles_LogLevel(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/mnt/data"))
+	files := f.controlFiles()
+
+	if got := readControl(t, files["log_level"]); got != "debug\n" {
+		t.Errorf("log_level = %q, want debug", got)
+	}
+	if errno := writeControl(files["log_level"], 0, "off"); errno != 0 {
+		t.Fatalf("write log_level: %v", errno)
+	}
+	if f.LogLevel() != LogLevelOff {
+		t.Errorf("LogLevel = %v, want off", f.LogLevel())
+	}
+	if errno := writeControl(files["log_level"], 0, "WARN"); errno != 0 || f.LogLevel() != slog.LevelWarn {
+		t.Errorf("write WARN = %v, level %v", errno, f.LogLevel())
+	}
+	if errno := writeControl(files["log_level"], 0, "loud"); errno != syscall.EINVAL {
+		t.Errorf("invalid level = %v, want EINVAL", errno)
+	}
+}
+
+func TestControlFiles_Flush(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/mnt/data"))
+	files := f.controlFiles()
+
+	f.backends[0].inodes.Cache("/projects/foo/a.txt", &fuse.Attr{Ino: 2})
+	f.backends[0].inodes.Cache("/projects/bar", &fuse.Attr{Ino: 3})
+	f.backends[0].inodes.CacheDir("/projects", []fuse.DirEntry{{Name: "foo"}})
+
+	if errno := writeControl(files["flush"], 0, "/projects/foo\n"); errno != 0 {
+		t.Fatalf("write flush: %v", errno)
+	}
+	if f.backends[0].inodes.GetCached("/projects/foo/a.txt") != nil {
+		t.Error("flushed subtree still cached")
+	}
+	if f.backends[0].inodes.GetDirCache("/projects") != nil {
+		t.Error("parent listing still cached")
+	}
+	if f.backends[0].inodes.GetCached("/projects/bar") == nil {
+		t.Error("sibling was flushed")
+	}
+
+	if errno := writeControl(files["flush"], 0, "relative"); errno != syscall.EINVAL {
+		t.Errorf("relative flush = %v, want EINVAL", errno)
+	}
+}
+
+func TestControlFiles_WritePermission(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/mnt/data"))
+	files := f.controlFiles()
+
+	// Some uid other than the mounting user and root
+	other := uint32(0x7ffffffe)
+	if errno := writeControl(files["log_level"], other, "error"); errno != syscall.EACCES {
+		t.Errorf("write by other user = %v, want EACCES", errno)
+	}
+	if f.LogLevel() != slog.LevelDebug {
+		t.Errorf("LogLevel changed to %v", f.LogLevel())
+	}
+}
+
+func TestConvertDirEntries_ControlDir(t *testing.T) {
+	entries := []fuse.DirEntry{{Name: "a"}, {Name: ".fusefs"}, {Name: "b"}}
+
+	names := func(entries []fuse.DirEntry) string {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	opts := DefaultMountOptions("/mnt/data")
+	opts.ControlDir = ".fusefs"
+	f := newFuseFS(nil, opts)
+
+	if got := names(f.root.convertDirEntries(entries)); got != "a,b" {
+		t.Errorf("hidden root listing = %s, want a,b", got)
+	}
+
+	sub := newFuseNode(f, f.root.backend, "/sub")
+	if got := names(sub.convertDirEntries(entries)); got != "a,.fusefs,b" {
+		t.Errorf("subdirectory listing = %s, want unchanged", got)
+	}
+	if sub.isControlName(".fusefs") || !f.root.isControlName(".fusefs") {
+		t.Error("control name only applies in the root directory")
+	}
+
+	opts.ControlDirVisible = true
+	if got := names(f.root.convertDirEntries(entries)); got != "a,b,.fusefs" {
+		t.Errorf("visible root listing = %s, want a,b,.fusefs", got)
+	}
+}
+
+func TestControlDir_ReservedName(t *testing.T) {
+	fsys := newMemFS(t)
+	f, dir, _ := newRenameTestFS(t, fsys)
+	opts := *f.options()
+	opts.ControlDir = ".fusefs"
+	f.opts.Store(&opts)
+
+	ctx := context.Background()
+	_, _, _, createErrno := f.root.Create(ctx, ".fusefs", syscall.O_WRONLY, 0644, &fuse.EntryOut{})
+	_, mkdirErrno := f.root.Mkdir(ctx, ".fusefs", 0755, &fuse.EntryOut{})
+	_, symlinkErrno := f.root.Symlink(ctx, "dir", ".fusefs", &fuse.EntryOut{})
+
+	tests := []struct {
+		name  string
+		errno syscall.Errno
+		want  syscall.Errno
+	}{
+		{"Create", createErrno, syscall.EEXIST},
+		{"Mkdir", mkdirErrno, syscall.EEXIST},
+		{"Symlink", symlinkErrno, syscall.EEXIST},
+		{"Unlink", f.root.Unlink(ctx, ".fusefs"), syscall.EPERM},
+		{"Rmdir", f.root.Rmdir(ctx, ".fusefs"), syscall.EPERM},
+		{"RenameFrom", f.root.Rename(ctx, ".fusefs", dir, "moved", 0), syscall.EPERM},
+		{"RenameTo", f.root.Rename(ctx, "dir", f.root, ".fusefs", 0), syscall.EPERM},
+	}
+	for _, tt := range tests {
+		if tt.errno != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.errno, tt.want)
+		}
+	}
+	if _, err := fsys.Stat("/.fusefs"); err == nil {
+		t.Error("control name created in the backend")
+	}
+
+	// The name is only reserved in the root
+	if _, errno := dir.Mkdir(ctx, ".fusefs", 0755, &fuse.EntryOut{}); errno != 0 {
+		t.Errorf("Mkdir in a subdirectory = %v", errno)
+	}
+
+	// Lookups of the control directory are recorded like other operations
+	before := f.Stats().Ops[OpLookup].Count
+	if _, errno := f.root.Lookup(ctx, ".fusefs", &fuse.EntryOut{}); errno != 0 {
+		t.Fatalf("Lookup = %v", errno)
+	}
+	if got := f.Stats().Ops[OpLookup].Count; got != before+1 {
+		t.Errorf("Lookup count = %d, want %d", got, before+1)
+	}
+}
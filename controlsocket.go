@@ -0,0 +1,390 @@
+package fusefs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The control socket is a unix socket, enabled with
+// MountOptions.ControlSocket, that serves the statistics and controls of
+// the control directory to programs. Each request is a ControlRequest in
+// JSON on its own line, answered by a ControlResponse line:
+//
+//	{"method": "stats"}                          Stats snapshot
+//	{"method": "handles"}                        open file handles
+//	{"method": "locks"}                          held flock and POSIX locks
+//	{"method": "invalidate", "path": "/foo"}     drop cached data for a subtree
+//	{"method": "log_level"}                      current log level
+//	{"method": "log_level", "level": "debug"}    change the log level
+//	{"method": "unmount", "timeout": "30s"}      graceful unmount, see UnmountContext
+//
+// Only the user that mounted the filesystem and root may connect; callers
+// are identified by the peer credentials of the connection (SO_PEERCRED
+// on Linux). ControlClient implements the client side.
+
+// ControlRequest is a request on the control socket
+type ControlRequest struct {
+	Method string `json:"method"`
+
+	// Path is the path to invalidate, relative to the mount root
+	Path string `json:"path,omitempty"`
+
+	// Level is the log level to set, e.g. "debug" or "off"
+	Level string `json:"level,omitempty"`
+
+	// Timeout bounds a graceful unmount, e.g. "30s"; the default is 10
+	// seconds
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ControlResponse is the answer to a ControlRequest. Exactly one of
+// Result and Error is set.
+type ControlResponse struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// controlServer serves the control socket of a mount
+type controlServer struct {
+	fusefs   *FuseFS
+	listener *net.UnixListener
+
+	mu     sync.Mutex
+	conns  map[*net.UnixConn]struct{}
+	closed bool
+
+	// handlers counts the connections being served
+	handlers sync.WaitGroup
+}
+
+// startControlSocket starts serving the control socket, if enabled
+func (f *FuseFS) startControlSocket() error {
+	socket := f.options().ControlSocket
+	if socket == "" {
+		return nil
+	}
+
+	listener, err := listenControl(socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+
+	f.controlSocket = &controlServer{
+		fusefs:   f,
+		listener: listener,
+		conns:    make(map[*net.UnixConn]struct{}),
+	}
+	go f.controlSocket.serve()
+	return nil
+}
+
+// listenControl listens on the unix socket at path. A socket file left by
+// a process that exited without closing it is replaced; a socket still
+// being served is an error.
+func listenControl(path string) (*net.UnixListener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// Access is decided by peer credentials, so that the socket may live
+	// in a directory shared with other users
+	if err := os.Chmod(path, 0666); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serve accepts connections until the server is closed
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.AcceptUnix()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.handlers.Add(1)
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// handle serves the requests of a connection
+func (s *controlServer) handle(conn *net.UnixConn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.handlers.Done()
+	}()
+
+	f := s.fusefs
+	enc := json.NewEncoder(conn)
+
+	uid, err := peerUID(conn)
+	if err == nil && !controlAllowed(uid) {
+		err = fmt.Errorf("uid %d is not allowed", uid)
+	}
+	if err != nil {
+		if f.logEnabled(context.Background(), slog.LevelWarn) {
+			f.options().Logger.Warn("control socket connection refused",
+				slog.String("error", err.Error()))
+		}
+		enc.Encode(ControlResponse{Error: "permission denied"})
+		return
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	for scanner.Scan() {
+		var req ControlRequest
+		var resp ControlResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else if result, err := f.controlCall(&req); err != nil {
+			resp.Error = err.Error()
+		} else if resp.Result, err = json.Marshal(result); err != nil {
+			resp.Error = err.Error()
+		}
+
+		if f.logEnabled(context.Background(), slog.LevelInfo) {
+			attrs := []slog.Attr{slog.String("method", req.Method), slog.Int64("uid", int64(uid))}
+			if resp.Error != "" {
+				attrs = append(attrs, slog.String("error", resp.Error))
+			}
+			f.options().Logger.LogAttrs(context.Background(), slog.LevelInfo, "control request", attrs...)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// close stops accepting connections and requests and removes the socket
+// file. A request already running completes and is answered.
+func (s *controlServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.CloseRead()
+	}
+}
+
+// shutdown closes the server and waits for running requests to be
+// answered
+func (s *controlServer) shutdown() {
+	s.close()
+	s.handlers.Wait()
+}
+
+// controlAllowed reports whether the user uid may use the control socket:
+// only the mounting user and root may
+func controlAllowed(uid uint32) bool {
+	return uid == 0 || uid == uint32(os.Getuid())
+}
+
+// controlCall runs a control request and returns its result
+func (f *FuseFS) controlCall(req *ControlRequest) (any, error) {
+	switch req.Method {
+	case "stats":
+		return f.Stats(), nil
+
+	case "handles":
+		return f.handleTracker.List(), nil
+
+	case "locks":
+		return f.lockManager.List(), nil
+
+	case "invalidate":
+		if !strings.HasPrefix(req.Path, "/") {
+			return nil, fmt.Errorf("invalidate: path must be absolute: %w", os.ErrInvalid)
+		}
+		f.invalidateTree(req.Path)
+		return true, nil
+
+	case "log_level":
+		if req.Level != "" {
+			level, err := parseLogLevel(req.Level)
+			if err != nil {
+				return nil, fmt.Errorf("log_level: %v: %w", err, os.ErrInvalid)
+			}
+			f.SetLogLevel(level)
+		}
+		return formatLogLevel(f.LogLevel()), nil
+
+	case "unmount":
+		timeout := defaultUnmountTimeout
+		if req.Timeout != "" {
+			d, err := time.ParseDuration(req.Timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("unmount: invalid timeout %q: %w", req.Timeout, os.ErrInvalid)
+			}
+			timeout = d
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return f.UnmountContext(ctx)
+
+	default:
+		return nil, fmt.Errorf("unknown method %q: %w", req.Method, os.ErrInvalid)
+	}
+}
+
+// ControlClient is a connection to the control socket of a mount, which
+// may be served by another process. Its methods may be called
+// concurrently.
+//
+// Example:
+//
+//	client, err := fusefs.DialControl("/run/fusefs/data.sock")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer client.Close()
+//	stats, err := client.Stats()
+type ControlClient struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	enc     *json.Encoder
+	scanner *bufio.Scanner
+}
+
+// DialControl connects to the control socket at path
+func DialControl(path string) (*ControlClient, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), 64<<20)
+	return &ControlClient{
+		conn:    conn,
+		enc:     json.NewEncoder(conn),
+		scanner: scanner,
+	}, nil
+}
+
+// Close closes the connection
+func (c *ControlClient) Close() error {
+	return c.conn.Close()
+}
+
+// Call sends req and decodes the result into result, which may be nil to
+// discard it
+func (c *ControlClient) Call(req ControlRequest, result any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.enc.Encode(req); err != nil {
+		return err
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("control %s: connection closed", req.Method)
+	}
+
+	var resp ControlResponse
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return fmt.Errorf("control %s: invalid response: %w", req.Method, err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("control %s: %s", req.Method, resp.Error)
+	}
+	if result == nil || resp.Result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// Stats returns a statistics snapshot of the mount
+func (c *ControlClient) Stats() (Stats, error) {
+	var stats Stats
+	err := c.Call(ControlRequest{Method: "stats"}, &stats)
+	return stats, err
+}
+
+// Handles lists the open file handles of the mount
+func (c *ControlClient) Handles() ([]HandleInfo, error) {
+	var handles []HandleInfo
+	err := c.Call(ControlRequest{Method: "handles"}, &handles)
+	return handles, err
+}
+
+// Locks lists the locks held on files of the mount
+func (c *ControlClient) Locks() ([]LockInfo, error) {
+	var locks []LockInfo
+	err := c.Call(ControlRequest{Method: "locks"}, &locks)
+	return locks, err
+}
+
+// Invalidate drops cached data for path, relative to the mount root, and
+// everything beneath it
+func (c *ControlClient) Invalidate(path string) error {
+	return c.Call(ControlRequest{Method: "invalidate", Path: path}, nil)
+}
+
+// LogLevel returns the log level of the mount
+func (c *ControlClient) LogLevel() (slog.Level, error) {
+	var name string
+	if err := c.Call(ControlRequest{Method: "log_level"}, &name); err != nil {
+		return 0, err
+	}
+	return parseLogLevel(name)
+}
+
+// SetLogLevel changes the log level of the mount; LogLevelOff disables
+// logging
+func (c *ControlClient) SetLogLevel(level slog.Level) error {
+	return c.Call(ControlRequest{Method: "log_level", Level: formatLogLevel(level)}, nil)
+}
+
+// Unmount gracefully unmounts the filesystem, waiting up to timeout for
+// in-flight operations and flushes, see FuseFS.UnmountContext. Zero uses
+// the default of 10 seconds.
+func (c *ControlClient) Unmount(timeout time.Duration) (*UnmountReport, error) {
+	req := ControlRequest{Method: "unmount"}
+	if timeout > 0 {
+		req.Timeout = timeout.String()
+	}
+	var report UnmountReport
+	if err := c.Call(req, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,12 @@
+package fusefs
+
+import "net"
+
+// peerUID returns the user of the process at the other end of conn
+func peerUID(conn *net.UnixConn) (uint32, error) {
+	cred, err := peerCredentials(conn)
+	if err != nil {
+		return 0, err
+	}
+	return cred.Uid, nil
+}
@@ -0,0 +1,142 @@
+package fusefs
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startTestControl serves the control socket of an unmounted FuseFS
+func startTestControl(t *testing.T) (*FuseFS, *ControlClient) {
+	t.Helper()
+
+	opts := DefaultMountOptions("/mnt/data")
+	opts.ControlSocket = filepath.Join(t.TempDir(), "control.sock")
+	f := newFuseFS(nil, opts)
+	if err := f.startControlSocket(); err != nil {
+		t.Fatalf("startControlSocket: %v", err)
+	}
+	t.Cleanup(f.controlSocket.close)
+
+	client, err := DialControl(opts.ControlSocket)
+	if err != nil {
+		t.Fatalf("DialControl: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return f, client
+}
+
+func TestControlSocket_Stats(t *testing.T) {
+	f, client := startTestControl(t)
+	f.stats.recordOperation()
+	f.handleTracker.Add(newMockFile("/open.txt"), syscall.O_RDONLY, "/open.txt")
+
+	stats, err := client.Stats()
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	if stats.Operations != 1 || stats.Mountpoint != "/mnt/data" || stats.OpenFiles != 1 {
+		t.Errorf("Stats = %+v", stats)
+	}
+
+	handles, err := client.Handles()
+	if err != nil {
+		t.Fatalf("Handles: %v", err)
+	}
+	if len(handles) != 1 || handles[0].Path != "/open.txt" {
+		t.Errorf("Handles = %+v", handles)
+	}
+
+	if _, err := client.Locks(); err != nil {
+		t.Errorf("Locks: %v", err)
+	}
+}
+
+func TestControlSocket_Controls(t *testing.T) {
+	f, client := startTestControl(t)
+
+	if err := client.SetLogLevel(slog.LevelWarn); err != nil {
+		t.Fatalf("SetLogLevel: %v", err)
+	}
+	if f.LogLevel() != slog.LevelWarn {
+		t.Errorf("log level = %v, want warn", f.LogLevel())
+	}
+	if level, err := client.LogLevel(); err != nil || level != slog.LevelWarn {
+		t.Errorf("LogLevel = %v, %v, want warn", level, err)
+	}
+
+	if err := client.Invalidate("/projects"); err != nil {
+		t.Errorf("Invalidate: %v", err)
+	}
+	if err := client.Invalidate("relative"); err == nil {
+		t.Error("Invalidate of a relative path succeeded")
+	}
+	if err := client.Call(ControlRequest{Method: "reboot"}, nil); err == nil {
+		t.Error("unknown method succeeded")
+	}
+
+	// The connection survives failed requests
+	if _, err := client.Stats(); err != nil {
+		t.Errorf("Stats after errors: %v", err)
+	}
+}
+
+func TestControlSocket_Unmount(t *testing.T) {
+	f, client := startTestControl(t)
+
+	if _, err := client.Unmount(time.Second); err != nil {
+		t.Fatalf("Unmount: %v", err)
+	}
+	if !f.checkUnmounting() {
+		t.Error("filesystem is not unmounting")
+	}
+
+	// The socket is closed with the mount
+	if _, err := client.Stats(); err == nil {
+		t.Error("request after unmount succeeded")
+	}
+	if _, err := os.Stat(f.options().ControlSocket); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file after unmount: %v, want removed", err)
+	}
+}
+
+func TestListenControl(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "control.sock")
+
+	listener, err := listenControl(socket)
+	if err != nil {
+		t.Fatalf("listenControl: %v", err)
+	}
+	if _, err := listenControl(socket); err == nil {
+		t.Error("listened on a socket in use")
+	}
+
+	// A socket file left behind is replaced
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = listenControl(socket)
+	if err != nil {
+		t.Fatalf("listenControl on stale socket: %v", err)
+	}
+	listener.Close()
+
+	regular := filepath.Join(dir, "file")
+	os.WriteFile(regular, nil, 0644)
+	if _, err := listenControl(regular); err == nil {
+		t.Error("listened over a regular file")
+	}
+}
+
+func TestControlAllowed(t *testing.T) {
+	if !controlAllowed(0) || !controlAllowed(uint32(os.Getuid())) {
+		t.Error("root or the mounting user was refused")
+	}
+	if os.Getuid() != 12345 && controlAllowed(12345) {
+		t.Error("another user was allowed")
+	}
+}
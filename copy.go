@@ -0,0 +1,204 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// CopyRanger is an optional interface that absfs files can implement to
+// copy data to another file without it passing through fusefs, e.g. with
+// a server-side copy.
+//
+// Files of the host filesystem (*os.File) are copied with
+// copy_file_range(2) on Linux. If neither the file nor the filesystem (see
+// Copier) offloads a copy, fusefs copies the data itself through ReadAt
+// and WriteAt, which still avoids the round trips through the kernel and
+// the calling process.
+type CopyRanger interface {
+	// CopyRange copies up to length bytes at srcOff in the file to dstOff
+	// in dst, which may belong to another backend, and returns the number
+	// of bytes copied. Fewer bytes than length are copied only at the end
+	// of the file.
+	//
+	// Returning an error that matches errors.ErrUnsupported, e.g. because
+	// dst is of another kind, makes fusefs fall back to another copy.
+	CopyRange(dst absfs.File, dstOff, srcOff, length int64) (int64, error)
+}
+
+// Copier is an optional interface that absfs filesystems can implement to
+// copy data between two of their files by path, e.g. with a server-side
+// copy in an object store.
+//
+// It is used only for copies within a backend, after the writes of open
+// handles of both files have been synced. A file that implements
+// CopyRanger is preferred.
+type Copier interface {
+	// CopyRange copies up to length bytes at srcOff in the file at src to
+	// dstOff in the file at dst and returns the number of bytes copied.
+	// Fewer bytes than length are copied only at the end of the file.
+	//
+	// Returning an error that matches errors.ErrUnsupported makes fusefs
+	// copy the data itself.
+	CopyRange(src string, srcOff int64, dst string, dstOff int64, length int64) (int64, error)
+}
+
+// maxCopyRange caps the bytes copied by one CopyFileRange call, whose
+// result must fit the 32 bits of the FUSE reply; callers loop for more
+const maxCopyRange = 1 << 30
+
+// copyChunkSize is the size of the buffer used when fusefs copies the data
+// itself
+const copyChunkSize = 1024 * 1024
+
+// CopyFileRange copies data between two open files (copy_file_range)
+func (n *fuseNode) CopyFileRange(ctx context.Context, fhIn fs.FileHandle, offIn uint64, out *fs.Inode, fhOut fs.FileHandle, offOut uint64, length uint64, flags uint64) (uint32, syscall.Errno) {
+	in, ok := fileHandle(fhIn)
+	if !ok {
+		return 0, syscall.EBADF
+	}
+	dst, ok := fileHandle(fhOut)
+	if !ok {
+		return 0, syscall.EBADF
+	}
+
+	var copied uint32
+	op := in.newOp(ctx, OpCopyFileRange)
+	op.Target = dst.node.path()
+	op.Offset = int64(offIn)
+	op.Size = int64(length)
+	errno := n.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		copied, errno = in.copyFileRange(ctx, offIn, dst, offOut, length, flags)
+		op.bytes = int64(copied)
+		return errno
+	})
+	return copied, errno
+}
+
+// copyFileRange implements CopyFileRange
+func (fh *fuseFileHandle) copyFileRange(ctx context.Context, offIn uint64, dst *fuseFileHandle, offOut uint64, length uint64, flags uint64) (uint32, syscall.Errno) {
+	// No copy_file_range flags are defined
+	if flags != 0 {
+		return 0, syscall.EINVAL
+	}
+
+	f := fh.node.fusefs
+	src := f.handleTracker.Get(fh.handle)
+	out := f.handleTracker.Get(dst.handle)
+	if src == nil || out == nil {
+		f.stats.recordError()
+		return 0, syscall.EBADF
+	}
+
+	length = min(length, maxCopyRange)
+	if length == 0 {
+		return 0, 0
+	}
+
+	unlock := dst.node.backend.writeLocks.lock(dst.node.StableAttr().Ino, false)
+	defer unlock()
+
+	n, err := fh.copyRange(ctx, src, int64(offIn), dst, out, int64(offOut), int64(length))
+	if n > 0 {
+		f.handleTracker.MarkDirty(dst.handle)
+		dst.node.backend.inodes.InvalidateAttr(dst.node.path())
+		f.stats.recordWrite(int(n))
+	}
+	if err != nil && n == 0 {
+		return 0, f.backendError(ctx, err)
+	}
+	return uint32(n), 0
+}
+
+// copyRange copies length bytes from src to out, offloading the copy to
+// the source file or the filesystem when they support it
+func (fh *fuseFileHandle) copyRange(ctx context.Context, src absfs.File, srcOff int64, dst *fuseFileHandle, out absfs.File, dstOff, length int64) (int64, error) {
+	if ranger, ok := src.(CopyRanger); ok {
+		start := time.Now()
+		n, err := ranger.CopyRange(out, dstOff, srcOff, length)
+		timeBackend(ctx, start)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return n, err
+		}
+	}
+
+	if srcFile, ok := src.(*os.File); ok {
+		if dstFile, ok := out.(*os.File); ok {
+			start := time.Now()
+			n, err := copyHostFiles(srcFile, srcOff, dstFile, dstOff, length)
+			timeBackend(ctx, start)
+			if !errors.Is(err, errors.ErrUnsupported) {
+				return n, err
+			}
+		}
+	}
+
+	if copier, ok := fh.node.backend.fs().(Copier); ok && fh.node.backend == dst.node.backend {
+		n, err := fh.copyPaths(ctx, copier, srcOff, dst, dstOff, length)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return n, err
+		}
+	}
+
+	return copyBuffered(ctx, src, srcOff, out, dstOff, length)
+}
+
+// copyPaths copies with the filesystem's Copier, which sees only what the
+// open handles have synced
+func (fh *fuseFileHandle) copyPaths(ctx context.Context, copier Copier, srcOff int64, dst *fuseFileHandle, dstOff, length int64) (int64, error) {
+	f := fh.node.fusefs
+	start := time.Now()
+	defer timeBackend(ctx, start)
+
+	if err := f.handleTracker.Sync(fh.handle); err != nil {
+		return 0, err
+	}
+	if err := f.handleTracker.Sync(dst.handle); err != nil {
+		return 0, err
+	}
+	return copier.CopyRange(fh.node.path(), srcOff, dst.node.path(), dstOff, length)
+}
+
+// copyBuffered copies length bytes from src to dst through a pooled buffer.
+// It returns the bytes copied so far along with any error.
+func copyBuffered(ctx context.Context, src absfs.File, srcOff int64, dst absfs.File, dstOff, length int64) (int64, error) {
+	buf := GetBuffer(copyChunkSize)
+	defer PutBuffer(buf)
+	buf = buf[:copyChunkSize]
+
+	var copied int64
+	for copied < length {
+		chunk := buf[:min(int64(len(buf)), length-copied)]
+
+		start := time.Now()
+		nr, err := src.ReadAt(chunk, srcOff+copied)
+		if nr > 0 {
+			nw, werr := dst.WriteAt(chunk[:nr], dstOff+copied)
+			copied += int64(nw)
+			if werr == nil && nw < nr {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				timeBackend(ctx, start)
+				return copied, werr
+			}
+		}
+		timeBackend(ctx, start)
+
+		if err == io.EOF || (err == nil && nr == 0) {
+			break
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+var _ fs.NodeCopyFileRanger = (*fuseNode)(nil)
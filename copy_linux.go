@@ -0,0 +1,58 @@
+package fusefs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyHostFiles copies between two files of the host filesystem with
+// copy_file_range(2), which lets the host filesystem share or copy the
+// data without reading it
+func copyHostFiles(src *os.File, srcOff int64, dst *os.File, dstOff, length int64) (int64, error) {
+	srcConn, err := src.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	dstConn, err := dst.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var copied int64
+	var copyErr error
+	err = srcConn.Control(func(srcFD uintptr) {
+		err := dstConn.Control(func(dstFD uintptr) {
+			for copied < length {
+				n, err := unix.CopyFileRange(int(srcFD), &srcOff, int(dstFD), &dstOff, int(length-copied), 0)
+				if err == syscall.EINTR {
+					continue
+				}
+				if err != nil {
+					copyErr = err
+					return
+				}
+				if n == 0 {
+					return
+				}
+				copied += int64(n)
+			}
+		})
+		if err != nil {
+			copyErr = err
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Files on different filesystems, or filesystems without support,
+	// are copied by fusefs
+	if copied == 0 && (errors.Is(copyErr, syscall.EXDEV) || errors.Is(copyErr, syscall.ENOSYS) ||
+		errors.Is(copyErr, syscall.EOPNOTSUPP) || errors.Is(copyErr, syscall.EINVAL)) {
+		return 0, errors.ErrUnsupported
+	}
+	return copied, copyErr
+}
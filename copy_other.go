@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fusefs
+
+import (
+	"errors"
+	"os"
+)
+
+// copyHostFiles is only supported on Linux; fusefs copies the data itself
+// elsewhere
+func copyHostFiles(src *os.File, srcOff int64, dst *os.File, dstOff, length int64) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
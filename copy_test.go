@@ -0,0 +1,229 @@
+package fusefs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+// openTestHandle opens name in the backend of f as a file handle
+func openTestHandle(t *testing.T, f *FuseFS, name string, flags int) *fuseFileHandle {
+	t.Helper()
+	file, err := f.root.backend.fs().OpenFile(name, flags, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %v", name, err)
+	}
+	node := newFuseNode(f, f.root.backend, name)
+	return &fuseFileHandle{node: node, handle: f.handleTracker.Add(file, flags, name)}
+}
+
+// newTestFileFS returns a FuseFS over fsys holding /src with size bytes
+func newTestFileFS(t *testing.T, fsys absfs.FileSystem, size int) (*FuseFS, []byte) {
+	t.Helper()
+	data := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	file, err := fsys.Create("/src")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	file.Write(data)
+	file.Close()
+	return newFuseFS(fsys, DefaultMountOptions("/mnt")), data
+}
+
+// copyFileRange copies between two handles as the kernel would
+func copyFileRange(in *fuseFileHandle, offIn uint64, out *fuseFileHandle, offOut, length uint64) (uint32, syscall.Errno) {
+	return in.node.CopyFileRange(context.Background(), in, offIn, nil, out, offOut, length, 0)
+}
+
+func TestCopyFileRange_Buffered(t *testing.T) {
+	fsys := newMemFS(t)
+	f, data := newTestFileFS(t, fsys, 3*copyChunkSize)
+	src := openTestHandle(t, f, "/src", os.O_RDONLY)
+	dst := openTestHandle(t, f, "/dst", os.O_RDWR|os.O_CREATE)
+
+	n, errno := copyFileRange(src, 16, dst, 0, uint64(len(data)))
+	if errno != 0 || n != uint32(len(data)-16) {
+		t.Fatalf("CopyFileRange = %d, %v, want %d bytes up to the end of the file", n, errno, len(data)-16)
+	}
+	got, _ := fsys.ReadFile("/dst")
+	if !bytes.Equal(got, data[16:]) {
+		t.Errorf("copied %d bytes, differing from the source", len(got))
+	}
+	if !f.handleTracker.GetEntry(dst.handle).dirty.Load() {
+		t.Error("destination handle not marked dirty")
+	}
+
+	stats := f.Stats()
+	if op := stats.Ops[OpCopyFileRange]; op.Count != 1 || op.Bytes != uint64(n) {
+		t.Errorf("CopyFileRange stats = %+v, want 1 call of %d bytes", op, n)
+	}
+
+	// Past the end of the source nothing is copied
+	if n, errno := copyFileRange(src, uint64(len(data)), dst, 0, 100); errno != 0 || n != 0 {
+		t.Errorf("CopyFileRange at EOF = %d, %v, want 0, 0", n, errno)
+	}
+}
+
+func TestCopyFileRange_Errors(t *testing.T) {
+	f, _ := newTestFileFS(t, newMemFS(t), 64)
+	src := openTestHandle(t, f, "/src", os.O_RDONLY)
+	dst := openTestHandle(t, f, "/dst", os.O_RDWR|os.O_CREATE)
+
+	if _, errno := src.node.CopyFileRange(context.Background(), src, 0, nil, dst, 0, 10, 1); errno != syscall.EINVAL {
+		t.Errorf("CopyFileRange with flags = %v, want EINVAL", errno)
+	}
+
+	// Writing through a read-only handle fails
+	ro := openTestHandle(t, f, "/dst", os.O_RDONLY)
+	if _, errno := copyFileRange(src, 0, ro, 0, 10); errno == 0 {
+		t.Error("CopyFileRange to a read-only handle succeeded")
+	}
+
+	f.handleTracker.Release(dst.handle)
+	if _, errno := copyFileRange(src, 0, dst, 0, 10); errno != syscall.EBADF {
+		t.Errorf("CopyFileRange to a released handle = %v, want EBADF", errno)
+	}
+}
+
+// rangerFS is a memfs whose files offload copies with CopyRanger
+type rangerFS struct {
+	*memfs.FileSystem
+	calls       int
+	unsupported bool
+}
+
+func (r *rangerFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := r.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &rangerFile{File: file, fs: r}, nil
+}
+
+func (r *rangerFS) Create(name string) (absfs.File, error) {
+	return r.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+type rangerFile struct {
+	absfs.File
+	fs *rangerFS
+}
+
+func (r *rangerFile) CopyRange(dst absfs.File, dstOff, srcOff, length int64) (int64, error) {
+	r.fs.calls++
+	if r.fs.unsupported {
+		return 0, errors.ErrUnsupported
+	}
+	buf := make([]byte, length)
+	n, _ := r.ReadAt(buf, srcOff)
+	_, err := dst.WriteAt(bytes.ToUpper(buf[:n]), dstOff)
+	return int64(n), err
+}
+
+func TestCopyFileRange_CopyRanger(t *testing.T) {
+	fsys := &rangerFS{FileSystem: newMemFS(t)}
+	f, data := newTestFileFS(t, fsys, 32)
+	src := openTestHandle(t, f, "/src", os.O_RDONLY)
+	dst := openTestHandle(t, f, "/dst", os.O_RDWR|os.O_CREATE)
+
+	if n, errno := copyFileRange(src, 0, dst, 4, 32); errno != 0 || n != 32 {
+		t.Fatalf("CopyFileRange = %d, %v, want 32, 0", n, errno)
+	}
+	got, _ := fsys.ReadFile("/dst")
+	if want := "\x00\x00\x00\x00" + string(bytes.ToUpper(data)); string(got) != want || fsys.calls != 1 {
+		t.Errorf("dst = %q after %d offloaded copies, want %q after 1", got, fsys.calls, want)
+	}
+
+	// Unsupported offloads fall back to a buffered copy
+	fsys.unsupported = true
+	if n, errno := copyFileRange(src, 0, dst, 0, 32); errno != 0 || n != 32 {
+		t.Fatalf("CopyFileRange = %d, %v, want 32, 0", n, errno)
+	}
+	got, _ = fsys.ReadFile("/dst")
+	if string(got[:32]) != string(data) || fsys.calls != 2 {
+		t.Errorf("dst = %q after %d offload attempts, want %q after 2", got[:32], fsys.calls, data)
+	}
+}
+
+// copierFS is a memfs that copies files by path
+type copierFS struct {
+	*memfs.FileSystem
+	copies []string
+}
+
+func (c *copierFS) CopyRange(src string, srcOff int64, dst string, dstOff int64, length int64) (int64, error) {
+	c.copies = append(c.copies, src+"->"+dst)
+	data, err := c.ReadFile(src)
+	if err != nil {
+		return 0, err
+	}
+	data = data[min(srcOff, int64(len(data))):]
+	data = data[:min(length, int64(len(data)))]
+	file, err := c.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	n, err := file.WriteAt(data, dstOff)
+	return int64(n), err
+}
+
+func TestCopyFileRange_Copier(t *testing.T) {
+	fsys := &copierFS{FileSystem: newMemFS(t)}
+	f, data := newTestFileFS(t, fsys, 64)
+	src := openTestHandle(t, f, "/src", os.O_RDONLY)
+	dst := openTestHandle(t, f, "/dst", os.O_RDWR|os.O_CREATE)
+
+	if n, errno := copyFileRange(src, 16, dst, 0, 1000); errno != 0 || n != 48 {
+		t.Fatalf("CopyFileRange = %d, %v, want 48, 0", n, errno)
+	}
+	got, _ := fsys.ReadFile("/dst")
+	if !bytes.Equal(got, data[16:]) || len(fsys.copies) != 1 || fsys.copies[0] != "/src->/dst" {
+		t.Errorf("dst = %q after copies %q, want %q after /src->/dst", got, fsys.copies, data[16:])
+	}
+}
+
+func TestCopyHostFiles(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("host"), 1024)
+	if err := os.WriteFile(dir+"/src", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.Open(dir + "/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := os.Create(dir + "/dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	n, err := copyHostFiles(src, 4, dst, 0, int64(len(data)))
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("copy_file_range is not supported")
+	}
+	if err != nil || n != int64(len(data)-4) {
+		t.Fatalf("copyHostFiles = %d, %v, want %d, nil", n, err, len(data)-4)
+	}
+	if got, _ := os.ReadFile(dir + "/dst"); !bytes.Equal(got, data[4:]) {
+		t.Errorf("copied %d bytes, differing from the source", len(got))
+	}
+}
+
+// newMemFS creates an empty in-memory filesystem
+func newMemFS(t *testing.T) *memfs.FileSystem {
+	t.Helper()
+	fsys, err := memfs.NewFS()
+	if err != nil {
+		t.Fatalf("memfs.NewFS: %v", err)
+	}
+	return fsys
+}
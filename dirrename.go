@@ -0,0 +1,479 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// dirRenameJournal is the name of the journal of an emulated directory
+// rename, kept in the root directory of the backend while the rename runs
+const dirRenameJournal = ".fusefs-rename.json"
+
+// Emulated rename states recorded in the journal
+const (
+	// dirRenameCopying is recorded while the tree is copied; an
+	// interrupted copy is rolled back by removing the new tree
+	dirRenameCopying = "copying"
+
+	// dirRenameDeleting is recorded once the copy is complete; an
+	// interrupted delete is resumed by removing the old tree
+	dirRenameDeleting = "deleting"
+)
+
+// dirRenameRecord is the content of the journal
+type dirRenameRecord struct {
+	Old   string `json:"old"`
+	New   string `json:"new"`
+	State string `json:"state"`
+
+	// Replaced records that New is an empty directory replaced by the
+	// rename, which is kept when the rename is rolled back
+	Replaced bool `json:"replaced,omitempty"`
+}
+
+// DirRenameStats reports the directory renames emulated by copying, see
+// MountOptions.EmulateDirRename
+type DirRenameStats struct {
+	Completed  uint64 // Renames completed while mounted
+	Failed     uint64 // Renames that failed and were rolled back
+	Resumed    uint64 // Interrupted renames completed when mounting
+	RolledBack uint64 // Interrupted renames undone when mounting
+
+	// Active lists the renames in progress
+	Active []DirRenameProgress
+}
+
+// DirRenameProgress describes an emulated directory rename in progress
+type DirRenameProgress struct {
+	Old     string    // Directory being renamed
+	New     string    // Path it is renamed to
+	Entries int64     // Files, directories and links copied so far
+	Bytes   int64     // File data copied so far
+	Started time.Time // When the rename started
+}
+
+// dirRenameStats tracks emulated directory renames
+type dirRenameStats struct {
+	completed  atomic.Uint64
+	failed     atomic.Uint64
+	resumed    atomic.Uint64
+	rolledBack atomic.Uint64
+
+	mu     sync.Mutex
+	active map[*dirRenameProgress]struct{}
+}
+
+// dirRenameProgress counts the progress of a running rename
+type dirRenameProgress struct {
+	oldPath, newPath string
+	entries          atomic.Int64
+	bytes            atomic.Int64
+	started          time.Time
+}
+
+// start registers a rename of oldPath to newPath as running
+func (s *dirRenameStats) start(oldPath, newPath string) *dirRenameProgress {
+	p := &dirRenameProgress{oldPath: oldPath, newPath: newPath, started: time.Now()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		s.active = make(map[*dirRenameProgress]struct{})
+	}
+	s.active[p] = struct{}{}
+	return p
+}
+
+// finish records the outcome of a running rename
+func (s *dirRenameStats) finish(p *dirRenameProgress, err error) {
+	s.mu.Lock()
+	delete(s.active, p)
+	s.mu.Unlock()
+
+	if err != nil {
+		s.failed.Add(1)
+	} else {
+		s.completed.Add(1)
+	}
+}
+
+// snapshot returns the current statistics
+func (s *dirRenameStats) snapshot() DirRenameStats {
+	stats := DirRenameStats{
+		Completed:  s.completed.Load(),
+		Failed:     s.failed.Load(),
+		Resumed:    s.resumed.Load(),
+		RolledBack: s.rolledBack.Load(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.active {
+		stats.Active = append(stats.Active, DirRenameProgress{
+			Old:     p.oldPath,
+			New:     p.newPath,
+			Entries: p.entries.Load(),
+			Bytes:   p.bytes.Load(),
+			Started: p.started,
+		})
+	}
+	return stats
+}
+
+// add returns the combined statistics of s and other
+func (s DirRenameStats) add(other DirRenameStats) DirRenameStats {
+	return DirRenameStats{
+		Completed:  s.Completed + other.Completed,
+		Failed:     s.Failed + other.Failed,
+		Resumed:    s.Resumed + other.Resumed,
+		RolledBack: s.RolledBack + other.RolledBack,
+		Active:     append(append([]DirRenameProgress(nil), s.Active...), other.Active...),
+	}
+}
+
+// isRenameUnsupported reports whether err from Rename means the backend
+// cannot rename the file, rather than that the rename is invalid
+func isRenameUnsupported(err error) bool {
+	return errors.Is(err, errors.ErrUnsupported) || errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EXDEV)
+}
+
+// emulateDirRename renames the directory oldPath to newPath by copying
+// its tree and then removing the original, recording the rename in the
+// journal so that an interrupted rename is recovered at the next mount
+func (b *backend) emulateDirRename(f *FuseFS, oldPath, newPath string, noReplace bool) error {
+	fsys := b.fs()
+
+	if newPath == oldPath {
+		return nil
+	}
+	if _, inside := withinPath(newPath, oldPath); inside {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrInvalid}
+	}
+
+	// A single journal records the rename in progress
+	b.dirRenameMu.Lock()
+	defer b.dirRenameMu.Unlock()
+
+	info, err := lstat(fsys, oldPath)
+	if err != nil {
+		return err
+	}
+
+	// As with rename(2), the target may only be an empty directory. It is
+	// filled by the copy rather than removed, so that it survives a
+	// rollback; otherwise the rename creates it.
+	record := dirRenameRecord{Old: oldPath, New: newPath, State: dirRenameCopying}
+	if target, err := lstat(fsys, newPath); err == nil {
+		if noReplace {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrExist}
+		}
+		if !target.IsDir() {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.ENOTDIR}
+		}
+		entries, err := fsys.ReadDir(newPath)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.ENOTEMPTY}
+		}
+		record.Replaced = true
+	} else if !os.IsNotExist(err) {
+		return err
+	} else if err := fsys.Mkdir(newPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if err := b.writeJournal(record); err != nil {
+		err = fmt.Errorf("failed to write rename journal: %w", err)
+		if rollbackErr := b.rollBackDirRename(record); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back rename: %w", rollbackErr))
+		}
+		return err
+	}
+
+	progress := f.stats.dirRenames.start(oldPath, newPath)
+	err = copyEntries(fsys, oldPath, newPath, progress)
+	if err == nil {
+		copyAttrs(fsys, newPath, info)
+		progress.entries.Add(1)
+		record.State = dirRenameDeleting
+		err = b.writeJournal(record)
+	}
+	if err != nil {
+		// Roll back, leaving the original in place. A copy that cannot
+		// be removed keeps the journal, so that the next mount rolls it
+		// back.
+		if rollbackErr := b.rollBackDirRename(record); rollbackErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to roll back rename: %w", rollbackErr))
+		} else {
+			fsys.Remove(b.journalPath())
+		}
+		f.stats.dirRenames.finish(progress, err)
+		return err
+	}
+
+	// The copy is complete: from here on the rename is completed, at the
+	// next mount if need be
+	err = fsys.RemoveAll(oldPath)
+	if err == nil {
+		err = fsys.Remove(b.journalPath())
+	}
+	f.stats.dirRenames.finish(progress, nil)
+	if err != nil && f.logEnabled(context.Background(), slog.LevelWarn) {
+		f.options().Logger.Warn("emulated rename left the old directory",
+			slog.String("old", oldPath), slog.String("new", newPath), slog.String("error", err.Error()))
+	}
+	return nil
+}
+
+// rollBackDirRename removes the copy made by a rename, keeping the empty
+// directory it was to replace
+func (b *backend) rollBackDirRename(record dirRenameRecord) error {
+	fsys := b.fs()
+	if !record.Replaced {
+		return fsys.RemoveAll(record.New)
+	}
+
+	entries, err := fsys.ReadDir(record.New)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := fsys.RemoveAll(path.Join(record.New, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isJournalName reports whether name in directory n is the rename journal
+// of its backend. The name is reserved while EmulateDirRename is set: it
+// is neither listed nor found, and cannot be created, removed or renamed.
+func (n *fuseNode) isJournalName(name string) bool {
+	return name == dirRenameJournal && n.fusefs.options().EmulateDirRename && n.path() == n.backend.rootPath
+}
+
+// journalPath returns the path of the rename journal
+func (b *backend) journalPath() string {
+	return path.Join(b.rootPath, dirRenameJournal)
+}
+
+// writeJournal records the state of a rename in the journal
+func (b *backend) writeJournal(record dirRenameRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := b.fs().OpenFile(b.journalPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if syncer, ok := file.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// recoverDirRename completes or rolls back a rename interrupted while the
+// backend was last mounted. A rename interrupted while copying is rolled
+// back; one interrupted while removing the original is completed.
+func (b *backend) recoverDirRename(f *FuseFS) error {
+	fsys := b.fs()
+	data, err := fsys.ReadFile(b.journalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var record dirRenameRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("invalid rename journal %s: %w", b.journalPath(), err)
+	}
+
+	switch record.State {
+	case dirRenameCopying:
+		if err := b.rollBackDirRename(record); err != nil {
+			return err
+		}
+		f.stats.dirRenames.rolledBack.Add(1)
+	case dirRenameDeleting:
+		if err := fsys.RemoveAll(record.Old); err != nil {
+			return err
+		}
+		f.stats.dirRenames.resumed.Add(1)
+	default:
+		return fmt.Errorf("invalid rename journal %s: unknown state %q", b.journalPath(), record.State)
+	}
+
+	if f.logEnabled(context.Background(), slog.LevelInfo) {
+		f.options().Logger.Info("recovered interrupted directory rename",
+			slog.String("old", record.Old),
+			slog.String("new", record.New),
+			slog.Bool("completed", record.State == dirRenameDeleting))
+	}
+	return fsys.Remove(b.journalPath())
+}
+
+// recoverDirRenames recovers the interrupted renames of all backends. A
+// rename that cannot be recovered fails the mount, since serving a half
+// copied tree would hide the interruption.
+func (f *FuseFS) recoverDirRenames() error {
+	opts := f.options()
+	if !opts.EmulateDirRename || opts.ReadOnly {
+		return nil
+	}
+
+	var errs []error
+	for _, b := range f.backends {
+		if err := b.recoverDirRename(f); err != nil {
+			errs = append(errs, fmt.Errorf("failed to recover interrupted directory rename in %s: %w", b.journalPath(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// copyTree copies the regular file, directory or symbolic link at src, with
+// everything beneath it, to dst, which must not exist. Other files fail
+// with errors.ErrUnsupported.
+func copyTree(fsys absfs.FileSystem, src, dst string, progress *dirRenameProgress) error {
+	info, err := lstat(fsys, src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		symlinkFS, ok := fsys.(interface {
+			Readlink(name string) (string, error)
+			Symlink(oldname, newname string) error
+		})
+		if !ok {
+			return &os.PathError{Op: "copy", Path: src, Err: errors.ErrUnsupported}
+		}
+		target, err := symlinkFS.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := symlinkFS.Symlink(target, dst); err != nil {
+			return err
+		}
+		progress.entries.Add(1)
+		return nil
+
+	case info.IsDir():
+		if err := fsys.Mkdir(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := copyEntries(fsys, src, dst, progress); err != nil {
+			return err
+		}
+
+	case info.Mode().IsRegular():
+		if err := copyFile(fsys, src, dst, info.Mode().Perm(), progress); err != nil {
+			return err
+		}
+
+	default:
+		// Reading a named pipe, socket or device could block or never
+		// end, and absfs cannot create them
+		return &os.PathError{Op: "copy", Path: src, Err: errors.ErrUnsupported}
+	}
+
+	copyAttrs(fsys, dst, info)
+	progress.entries.Add(1)
+	return nil
+}
+
+// copyEntries copies the entries of the directory src into the directory
+// dst
+func copyEntries(fsys absfs.FileSystem, src, dst string, progress *dirRenameProgress) error {
+	entries, err := fsys.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := copyTree(fsys, path.Join(src, entry.Name()), path.Join(dst, entry.Name()), progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyAttrs gives dst the mode and times of info, including what Mkdir and
+// OpenFile may have masked
+func copyAttrs(fsys absfs.FileSystem, dst string, info os.FileInfo) {
+	fsys.Chmod(dst, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+	fsys.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// copyFile copies the content of the regular file src to the new file dst
+func copyFile(fsys absfs.FileSystem, src, dst string, perm os.FileMode, progress *dirRenameProgress) error {
+	in, err := fsys.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := fsys.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	buf := GetBuffer(copyChunkSize)
+	defer PutBuffer(buf)
+	for {
+		n, err := in.Read(buf[:copyChunkSize])
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				out.Close()
+				return werr
+			}
+			progress.bytes.Add(int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// lstat returns information about name without following a final
+// symbolic link, if the filesystem supports it
+func lstat(fsys absfs.FileSystem, name string) (os.FileInfo, error) {
+	if lstatFS, ok := fsys.(interface {
+		Lstat(name string) (os.FileInfo, error)
+	}); ok {
+		return lstatFS.Lstat(name)
+	}
+	return fsys.Stat(name)
+}
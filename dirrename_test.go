@@ -0,0 +1,361 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/memfs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// noDirRenameFS is a filesystem that cannot rename directories, like an
+// object store
+type noDirRenameFS struct {
+	*memfs.FileSystem
+}
+
+func (fsys *noDirRenameFS) Rename(oldpath, newpath string) error {
+	if info, err := fsys.Stat(oldpath); err == nil && info.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.ErrUnsupported}
+	}
+	return fsys.FileSystem.Rename(oldpath, newpath)
+}
+
+// emulateDirRename enables MountOptions.EmulateDirRename on f
+func emulateDirRename(f *FuseFS) {
+	opts := *f.options()
+	opts.EmulateDirRename = true
+	f.opts.Store(&opts)
+}
+
+func TestEmulateDirRename(t *testing.T) {
+	fsys := &noDirRenameFS{newMemFS(t)}
+	fsys.MkdirAll("/dir/sub", 0700)
+	f, dir, file := newRenameTestFS(t, fsys)
+	fsys.Chmod("/dir/sub", 0700)
+	emulateDirRename(f)
+
+	if errno := f.root.Rename(context.Background(), "dir", f.root, "moved", 0); errno != 0 {
+		t.Fatalf("Rename = %v", errno)
+	}
+
+	if _, err := fsys.Stat("/dir"); !os.IsNotExist(err) {
+		t.Errorf("Stat(/dir) after rename = %v, want not exist", err)
+	}
+	if data, _ := fsys.ReadFile("/moved/file"); string(data) != "/dir/file" {
+		t.Errorf("/moved/file = %q, want /dir/file", data)
+	}
+	if info, err := fsys.Stat("/moved/sub"); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Stat(/moved/sub) = %v, %v, want mode 0700", info, err)
+	}
+	if _, err := fsys.Stat("/" + dirRenameJournal); !os.IsNotExist(err) {
+		t.Errorf("journal left after rename: %v", err)
+	}
+	if dir.path() != "/moved" || file.path() != "/moved/file" {
+		t.Errorf("paths after rename = %q, %q, want /moved, /moved/file", dir.path(), file.path())
+	}
+
+	stats := f.Stats().DirRenames
+	if stats.Completed != 1 || stats.Failed != 0 || len(stats.Active) != 0 {
+		t.Errorf("DirRenames = %+v, want 1 completed", stats)
+	}
+
+	// Files are still renamed by the backend
+	if errno := f.root.Rename(context.Background(), "other", dir, "other", 0); errno != 0 {
+		t.Errorf("Rename of a file = %v", errno)
+	}
+	if f.Stats().DirRenames.Completed != 1 {
+		t.Errorf("renaming a file was emulated")
+	}
+}
+
+func TestEmulateDirRename_NativeFirst(t *testing.T) {
+	fsys := newMemFS(t)
+	f, dir, _ := newRenameTestFS(t, fsys)
+	emulateDirRename(f)
+
+	// Directories the backend can rename are not copied
+	if errno := f.root.Rename(context.Background(), "dir", f.root, "moved", 0); errno != 0 {
+		t.Fatalf("Rename = %v", errno)
+	}
+	if dir.path() != "/moved" {
+		t.Errorf("path after rename = %q, want /moved", dir.path())
+	}
+	if stats := f.Stats().DirRenames; stats.Completed != 0 {
+		t.Errorf("DirRenames = %+v, want no emulated rename", stats)
+	}
+}
+
+func TestEmulateDirRename_Disabled(t *testing.T) {
+	fsys := &noDirRenameFS{newMemFS(t)}
+	f, _, _ := newRenameTestFS(t, fsys)
+
+	if errno := f.root.Rename(context.Background(), "dir", f.root, "moved", 0); errno != syscall.EXDEV {
+		t.Errorf("Rename = %v, want EXDEV", errno)
+	}
+	if _, err := fsys.Stat("/dir/file"); err != nil {
+		t.Errorf("Stat(/dir/file) after failed rename: %v", err)
+	}
+}
+
+func TestEmulateDirRename_Target(t *testing.T) {
+	fsys := &noDirRenameFS{newMemFS(t)}
+	fsys.Mkdir("/empty", 0755)
+	fsys.MkdirAll("/full/sub", 0755)
+	f, dir, _ := newRenameTestFS(t, fsys)
+	emulateDirRename(f)
+
+	tests := []struct {
+		name   string
+		target string
+		flags  uint32
+		want   syscall.Errno
+	}{
+		{"NoReplace", "empty", renameNoReplace, syscall.EEXIST},
+		{"File", "other", 0, syscall.ENOTDIR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errno := f.root.Rename(context.Background(), "dir", f.root, tt.target, tt.flags); errno != tt.want {
+				t.Errorf("Rename = %v, want %v", errno, tt.want)
+			}
+			if dir.path() != "/dir" {
+				t.Errorf("path after failed rename = %q", dir.path())
+			}
+		})
+	}
+
+	// An empty directory is replaced
+	if errno := f.root.Rename(context.Background(), "dir", f.root, "empty", 0); errno != 0 {
+		t.Fatalf("Rename over an empty directory = %v", errno)
+	}
+	if _, err := fsys.Stat("/empty/file"); err != nil {
+		t.Errorf("Stat(/empty/file): %v", err)
+	}
+
+	// A directory that is not empty is not replaced
+	if errno := f.root.Rename(context.Background(), "empty", f.root, "full", 0); errno != syscall.ENOTEMPTY && errno != syscall.EEXIST {
+		t.Errorf("Rename over a directory that is not empty = %v, want ENOTEMPTY or EEXIST", errno)
+	}
+	if _, err := fsys.Stat("/full/sub"); err != nil {
+		t.Errorf("Stat(/full/sub): %v", err)
+	}
+}
+
+// fifoFS reports a file as a named pipe, which cannot be copied
+type fifoFS struct {
+	*noDirRenameFS
+	fifo string
+}
+
+func (fsys *fifoFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := fsys.noDirRenameFS.Lstat(name)
+	if err == nil && name == fsys.fifo {
+		info = fifoInfo{info}
+	}
+	return info, err
+}
+
+// fifoInfo describes a named pipe
+type fifoInfo struct {
+	os.FileInfo
+}
+
+func (fifoInfo) Mode() os.FileMode { return os.ModeNamedPipe | 0644 }
+
+func TestEmulateDirRename_SpecialFile(t *testing.T) {
+	fsys := &fifoFS{noDirRenameFS: &noDirRenameFS{newMemFS(t)}, fifo: "/dir/fifo"}
+	f, dir, _ := newRenameTestFS(t, fsys)
+	fifo, _ := fsys.Create("/dir/fifo")
+	fifo.Close()
+	emulateDirRename(f)
+
+	// mv(1) copies what it can itself
+	if errno := f.root.Rename(context.Background(), "dir", f.root, "moved", 0); errno != syscall.EXDEV {
+		t.Errorf("Rename = %v, want EXDEV", errno)
+	}
+	if _, err := fsys.Stat("/dir/fifo"); err != nil {
+		t.Errorf("Stat(/dir/fifo) after failed rename: %v", err)
+	}
+	if _, err := fsys.Stat("/moved"); !os.IsNotExist(err) {
+		t.Errorf("Stat(/moved) after failed rename = %v, want not exist", err)
+	}
+	if dir.path() != "/dir" {
+		t.Errorf("path after failed rename = %q", dir.path())
+	}
+	if stats := f.Stats().DirRenames; stats.Failed != 1 {
+		t.Errorf("DirRenames = %+v, want 1 failed", stats)
+	}
+
+	// An empty directory that was to be replaced is kept
+	fsys.Mkdir("/empty", 0700)
+	if errno := f.root.Rename(context.Background(), "dir", f.root, "empty", 0); errno != syscall.EXDEV {
+		t.Errorf("Rename over an empty directory = %v, want EXDEV", errno)
+	}
+	entries, err := fsys.ReadDir("/empty")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir(/empty) after failed rename = %v, %v, want empty", entries, err)
+	}
+	if info, err := fsys.Stat("/empty"); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Stat(/empty) after failed rename = %v, %v, want mode 0700", info, err)
+	}
+}
+
+// stuckFS is a fifoFS that cannot remove the directories in stuck
+type stuckFS struct {
+	*fifoFS
+	stuck map[string]bool
+}
+
+func (fsys *stuckFS) RemoveAll(name string) error {
+	if fsys.stuck[name] {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	return fsys.fifoFS.RemoveAll(name)
+}
+
+func TestEmulateDirRename_RollbackFails(t *testing.T) {
+	fsys := &stuckFS{
+		fifoFS: &fifoFS{noDirRenameFS: &noDirRenameFS{newMemFS(t)}, fifo: "/dir/fifo"},
+		stuck:  map[string]bool{"/moved": true},
+	}
+	f, _, _ := newRenameTestFS(t, fsys)
+	fifo, _ := fsys.Create("/dir/fifo")
+	fifo.Close()
+	emulateDirRename(f)
+
+	if errno := f.root.Rename(context.Background(), "dir", f.root, "moved", 0); errno == 0 {
+		t.Fatal("Rename of a directory that cannot be copied succeeded")
+	}
+
+	// The half copied tree is left for the next mount to roll back
+	data, err := fsys.ReadFile("/" + dirRenameJournal)
+	var record dirRenameRecord
+	if err != nil || json.Unmarshal(data, &record) != nil || record.State != dirRenameCopying {
+		t.Fatalf("journal after a failed rollback = %q, %v, want state %q", data, err, dirRenameCopying)
+	}
+
+	delete(fsys.stuck, "/moved")
+	if err := f.recoverDirRenames(); err != nil {
+		t.Fatalf("recoverDirRenames: %v", err)
+	}
+	if _, err := fsys.Stat("/moved"); !os.IsNotExist(err) {
+		t.Errorf("Stat(/moved) after recovery = %v, want not exist", err)
+	}
+	if _, err := fsys.Stat("/dir/file"); err != nil {
+		t.Errorf("Stat(/dir/file) after recovery: %v", err)
+	}
+}
+
+func TestEmulateDirRename_JournalHidden(t *testing.T) {
+	fsys := &noDirRenameFS{newMemFS(t)}
+	f, _, _ := newRenameTestFS(t, fsys)
+	journal, _ := fsys.Create("/" + dirRenameJournal)
+	journal.Close()
+	ctx := context.Background()
+
+	if _, errno := f.root.Lookup(ctx, dirRenameJournal, &fuse.EntryOut{}); errno != 0 {
+		t.Errorf("Lookup of the journal name without EmulateDirRename = %v", errno)
+	}
+
+	emulateDirRename(f)
+	if _, errno := f.root.Lookup(ctx, dirRenameJournal, &fuse.EntryOut{}); errno != syscall.ENOENT {
+		t.Errorf("Lookup of the journal = %v, want ENOENT", errno)
+	}
+	stream, errno := f.root.Readdir(ctx)
+	if errno != 0 {
+		t.Fatalf("Readdir = %v", errno)
+	}
+	for stream.HasNext() {
+		if entry, _ := stream.Next(); entry.Name == dirRenameJournal {
+			t.Error("Readdir lists the journal")
+		}
+	}
+
+	if _, _, _, errno := f.root.Create(ctx, dirRenameJournal, uint32(os.O_RDWR), 0644, &fuse.EntryOut{}); errno != syscall.EPERM {
+		t.Errorf("Create of the journal = %v, want EPERM", errno)
+	}
+	if errno := f.root.Unlink(ctx, dirRenameJournal); errno != syscall.EPERM {
+		t.Errorf("Unlink of the journal = %v, want EPERM", errno)
+	}
+	if errno := f.root.Rename(ctx, "other", f.root, dirRenameJournal, 0); errno != syscall.EPERM {
+		t.Errorf("Rename over the journal = %v, want EPERM", errno)
+	}
+	if _, err := fsys.Stat("/" + dirRenameJournal); err != nil {
+		t.Errorf("journal after the attempts: %v", err)
+	}
+}
+
+func TestEmulateDirRename_Recover(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		replaced bool
+		existing string // path remaining after recovery
+		removed  string // path removed by recovery
+	}{
+		{"copying", dirRenameCopying, false, "/old/file", "/new"},
+		{"copying replaced", dirRenameCopying, true, "/new", "/new/file"},
+		{"deleting", dirRenameDeleting, false, "/new/file", "/old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newMemFS(t)
+			for _, dir := range []string{"/old", "/new"} {
+				fsys.Mkdir(dir, 0755)
+				file, _ := fsys.Create(dir + "/file")
+				file.Close()
+			}
+			data, _ := json.Marshal(dirRenameRecord{Old: "/old", New: "/new", State: tt.state, Replaced: tt.replaced})
+			journal, _ := fsys.Create("/" + dirRenameJournal)
+			journal.Write(data)
+			journal.Close()
+
+			opts := DefaultMountOptions("/mnt")
+			opts.EmulateDirRename = true
+			f := newFuseFS(fsys, opts)
+			if err := f.recoverDirRenames(); err != nil {
+				t.Fatalf("recoverDirRenames: %v", err)
+			}
+
+			if _, err := fsys.Stat(tt.existing); err != nil {
+				t.Errorf("Stat(%s) after recovery: %v", tt.existing, err)
+			}
+			if _, err := fsys.Stat(tt.removed); !os.IsNotExist(err) {
+				t.Errorf("Stat(%s) after recovery = %v, want not exist", tt.removed, err)
+			}
+			if _, err := fsys.Stat("/" + dirRenameJournal); !os.IsNotExist(err) {
+				t.Errorf("journal left after recovery: %v", err)
+			}
+
+			stats := f.Stats().DirRenames
+			if tt.state == dirRenameCopying && stats.RolledBack != 1 {
+				t.Errorf("RolledBack = %d, want 1", stats.RolledBack)
+			}
+			if tt.state == dirRenameDeleting && stats.Resumed != 1 {
+				t.Errorf("Resumed = %d, want 1", stats.Resumed)
+			}
+		})
+	}
+}
+
+func TestEmulateDirRename_RecoverInvalid(t *testing.T) {
+	fsys := newMemFS(t)
+	journal, _ := fsys.Create("/" + dirRenameJournal)
+	journal.Write([]byte("{not json"))
+	journal.Close()
+
+	opts := DefaultMountOptions("/mnt")
+	opts.EmulateDirRename = true
+	f := newFuseFS(fsys, opts)
+	if err := f.recoverDirRenames(); err == nil {
+		t.Error("recoverDirRenames of an invalid journal succeeded")
+	}
+	if _, err := fsys.Stat("/" + dirRenameJournal); err != nil {
+		t.Errorf("journal removed after failed recovery: %v", err)
+	}
+}
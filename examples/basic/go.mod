@@ -5,7 +5,7 @@ go 1.24.0
 replace github.com/absfs/fusefs => ../..
 
 require (
-	github.com/absfs/absfs v0.0.0-20251109181304-77e2f9ac4448
+	github.com/absfs/absfs v0.9.1
 	github.com/absfs/fusefs v0.0.0-00010101000000-000000000000
 )
 
@@ -1,12 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
 	"path/filepath"
-	"syscall"
 
 	"github.com/absfs/fusefs"
 )
@@ -66,11 +65,12 @@ Try it out!
 
 	fmt.Printf("Mounting filesystem from %s at %s...\n", tempDir, mountpoint)
 
-	fuseFS, err := fusefs.Mount(osfs, opts)
+	// The manager unmounts on Ctrl+C and remounts if the mount is lost
+	manager := fusefs.NewMountManager(nil)
+	fuseFS, err := manager.Mount("basic", osfs, opts)
 	if err != nil {
 		log.Fatalf("Failed to mount: %v", err)
 	}
-	defer fuseFS.Unmount()
 
 	fmt.Printf("✓ Mounted successfully at %s\n", mountpoint)
 	fmt.Printf("Try:\n")
@@ -79,14 +79,14 @@ Try it out!
 	fmt.Printf("  cat %s/documents/hello.txt\n", mountpoint)
 	fmt.Printf("\nPress Ctrl+C to unmount...\n")
 
-	// Wait for interrupt signal
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	<-sigCh
+	// Wait for interrupt signal, then unmount
+	if err := manager.Run(context.Background()); err != nil {
+		log.Printf("Unmount: %v", err)
+	}
 
-	fmt.Println("\nUnmounting...")
+	fmt.Println("\nUnmounted.")
 
-	// Print statistics before unmounting
+	// Print statistics
 	stats := fuseFS.Stats()
 	fmt.Printf("\nStatistics:\n")
 	fmt.Printf("  Operations:    %d\n", stats.Operations)
@@ -15,6 +15,7 @@
 //   - Attribute and directory entry caching for performance
 //   - Statistics tracking (operations, bytes read/written, errors)
 //   - Graceful unmounting with resource cleanup
+//   - Optional control directory for inspecting a mount with standard tools
 //
 // # Usage
 //
@@ -61,7 +62,11 @@
 package fusefs
 
 import (
+	"log/slog"
+	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/absfs/absfs"
 	"github.com/hanwen/go-fuse/v2/fs"
@@ -70,17 +75,20 @@ import (
 
 // FuseFS represents a mounted FUSE filesystem
 type FuseFS struct {
-	// absFS is the underlying abstract filesystem
-	absFS absfs.FileSystem
-
-	// opts contains mount options
-	opts *MountOptions
+	// opts contains mount options, replaced by Reconfigure
+	opts          atomic.Pointer[MountOptions]
+	reconfigureMu sync.Mutex
 
 	// server is the FUSE server instance
 	server *fuse.Server
 
-	// inodeManager manages inode allocation and caching
-	inodeManager *InodeManager
+	// fdHelper is the connection to the ServeFuseFD helper that mounted
+	// the filesystem, held open until unmount
+	fdHelper *net.UnixConn
+
+	// backends are the filesystems served by the mount, ordered by mount
+	// path. A mount created by Mount has a single backend at "/".
+	backends []*backend
 
 	// handleTracker manages open file handles
 	handleTracker *HandleTracker
@@ -91,18 +99,71 @@ type FuseFS struct {
 	// stats collects filesystem statistics
 	stats *statsCollector
 
+	// chain runs operations through the built-in and user interceptors
+	chain OpFunc
+
+	// logLevel is the minimum level of records passed to opts.Logger
+	logLevel slog.LevelVar
+
+	// control is the inode of the control directory, created on first
+	// lookup
+	controlOnce sync.Once
+	control     *fs.Inode
+
+	// controlSocket serves MountOptions.ControlSocket, if set
+	controlSocket *controlServer
+
+	// passthroughOK records whether the kernel negotiated passthrough for
+	// MountOptions.Passthrough at mount
+	passthroughOK bool
+
+	// started is reported as the modification time of synthesized files
+	// and directories
+	started time.Time
+
+	// ops tracks in-flight operations so that unmount can drain them
+	ops opTracker
+
 	// unmounting indicates if the filesystem is being unmounted
 	unmounting atomic.Bool
 
-	// Root node for go-fuse
-	root *fuseNode
+	// kernelUnmount unmounts the FUSE filesystem, nil if not mounted.
+	// unmounted records that it succeeded; unmountMu serializes unmounts.
+	kernelUnmount func() error
+	unmountMu     sync.Mutex
+	unmounted     bool
+
+	// Root node for go-fuse; nil for composite mounts, whose root is
+	// the synthesized directory composite
+	root      *fuseNode
+	composite *compositeDir
 }
 
 // fuseNode implements the fs.InodeEmbedder interface for go-fuse v2
 type fuseNode struct {
 	fs.Inode
-	fusefs *FuseFS
-	path   string
+	fusefs  *FuseFS
+	backend *backend
+
+	// absPath is the absfs path of the node, which changes when the node
+	// or one of its parent directories is renamed
+	absPath atomic.Pointer[string]
+
+	// passthroughHandles counts the open handles of the node that the
+	// kernel reads and writes through passthrough
+	passthroughHandles atomic.Int32
+}
+
+// newFuseNode creates a node for the absfs path p of a backend
+func newFuseNode(f *FuseFS, b *backend, p string) *fuseNode {
+	n := &fuseNode{fusefs: f, backend: b}
+	n.absPath.Store(&p)
+	return n
+}
+
+// path returns the absfs path of the node
+func (n *fuseNode) path() string {
+	return *n.absPath.Load()
 }
 
 // Ensure fuseNode implements required interfaces
@@ -123,28 +184,43 @@ var _ fs.NodeReadlinker = (*fuseNode)(nil)
 
 // newFuseFS creates a new FUSE filesystem adapter
 func newFuseFS(absFS absfs.FileSystem, opts *MountOptions) *FuseFS {
+	fuseFS := newFuseFSBase(opts)
+	fuseFS.backends = []*backend{newBackend(absFS, opts, 0, "/", opts.Root)}
+	fuseFS.root = fuseFS.backends[0].rootNode(fuseFS)
+	return fuseFS
+}
+
+// newFuseFSBase creates a FUSE filesystem adapter without backends
+func newFuseFSBase(opts *MountOptions) *FuseFS {
 	fuseFS := &FuseFS{
-		absFS: absFS,
-		opts:  opts,
-		inodeManager: NewInodeManager(
-			opts.MaxCachedInodes,
-			opts.MaxCachedDirs,
-			opts.AttrCacheTTL,
-			opts.DirCacheTTL,
-		),
 		handleTracker: NewHandleTracker(),
 		lockManager:   NewLockManager(),
 		stats:         newStatsCollector(),
+		started:       time.Now(),
 	}
 
-	fuseFS.root = &fuseNode{
-		fusefs: fuseFS,
-		path:   "/",
-	}
+	fuseFS.opts.Store(opts)
+	fuseFS.logLevel.Set(slog.LevelDebug)
+
+	interceptors := []Interceptor{fuseFS.recordInterceptor, fuseFS.logInterceptor, fuseFS.unmountInterceptor}
+	fuseFS.chain = buildChain(append(interceptors, opts.Interceptors...))
 
 	return fuseFS
 }
 
+// options returns the current mount options
+func (f *FuseFS) options() *MountOptions {
+	return f.opts.Load()
+}
+
+// rootEmbedder returns the root node passed to go-fuse
+func (f *FuseFS) rootEmbedder() fs.InodeEmbedder {
+	if f.composite != nil {
+		return f.composite
+	}
+	return f.root
+}
+
 // Stats returns a snapshot of current filesystem statistics.
 //
 // The returned Stats structure contains:
@@ -154,15 +230,21 @@ func newFuseFS(absFS absfs.FileSystem, opts *MountOptions) *FuseFS {
 //   - Errors: Total number of errors encountered
 //   - OpenFiles: Number of currently open file handles
 //   - Mountpoint: The path where the filesystem is mounted
+//   - Root: The backend directory mounted as the root, empty for composite mounts
 //   - InodeStats: Cache statistics from the inode manager
+//   - Ops: Per-operation counts, errors, bytes and latency histograms
+//   - Errnos: Errno results returned to the kernel, by errno
 //
 // Statistics are collected atomically and this method is safe to call
 // from multiple goroutines.
 func (f *FuseFS) Stats() Stats {
 	stats := f.stats.snapshot()
-	stats.Mountpoint = f.opts.Mountpoint
+	stats.Mountpoint = f.options().Mountpoint
+	if f.root != nil {
+		stats.Root = f.root.backend.rootPath
+	}
 	stats.OpenFiles = f.handleTracker.Count()
-	stats.InodeStats = f.inodeManager.Stats()
+	stats.InodeStats = f.inodeStats()
 	return stats
 }
 
@@ -0,0 +1,166 @@
+package fusefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mounting a FUSE filesystem takes privileges: either the setuid
+// fusermount helper or CAP_SYS_ADMIN. Where neither is available to the
+// filesystem server, e.g. in an unprivileged container, a privileged
+// process can open /dev/fuse, mount it and hand the file descriptor over:
+//
+//   - Within one process tree, pass the descriptor to the server, e.g.
+//     with exec.Cmd.ExtraFiles, and set MountOptions.FuseFD.
+//   - Across containers, run ServeFuseFD in the privileged process on a
+//     unix socket shared with the server and set MountOptions.FuseFDSocket.
+//
+// The helper protocol is a single message from the helper: fuseFDReady
+// with the descriptor attached as SCM_RIGHTS, or an error text prefixed
+// with fuseFDError. The server keeps the connection open while mounted and
+// shuts down its write side to ask the helper to unmount; the helper
+// replies with an error text, if any, and closes the connection.
+
+const (
+	fuseFDReady = "ok"
+	fuseFDError = "error: "
+)
+
+// usesFuseFD reports whether the filesystem is mounted through a
+// pre-opened /dev/fuse descriptor rather than by go-fuse itself
+func usesFuseFD(opts *MountOptions) bool {
+	return opts.FuseFD > 0 || opts.FuseFDSocket != ""
+}
+
+// mountTarget returns the mountpoint passed to go-fuse: the magic
+// /dev/fd/N path when the mount uses a pre-opened /dev/fuse descriptor
+func (f *FuseFS) mountTarget() (string, error) {
+	opts := f.options()
+	fd := opts.FuseFD
+	if opts.FuseFDSocket != "" {
+		conn, received, err := dialFuseFDHelper(opts.FuseFDSocket)
+		if err != nil {
+			return "", fmt.Errorf("failed to receive /dev/fuse descriptor from %s: %w", opts.FuseFDSocket, err)
+		}
+		f.fdHelper = conn
+		fd = received
+	}
+
+	if fd > 0 {
+		return fmt.Sprintf("/dev/fd/%d", fd), nil
+	}
+	return opts.Mountpoint, nil
+}
+
+// dialFuseFDHelper connects to a ServeFuseFD helper and receives the
+// mounted /dev/fuse descriptor
+func dialFuseFDHelper(socketPath string) (*net.UnixConn, int, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, -1, err
+	}
+
+	fd, err := receiveFuseFD(conn)
+	if err != nil {
+		conn.Close()
+		return nil, -1, err
+	}
+	return conn, fd, nil
+}
+
+// sendFuseFD passes fd to the server at the other end of conn
+func sendFuseFD(conn *net.UnixConn, fd int) error {
+	_, _, err := conn.WriteMsgUnix([]byte(fuseFDReady), unix.UnixRights(fd), nil)
+	return err
+}
+
+// sendFuseFDError reports a failure to the server at the other end of conn
+func sendFuseFDError(conn *net.UnixConn, err error) error {
+	_, writeErr := conn.Write([]byte(fuseFDError + err.Error()))
+	return writeErr
+}
+
+// receiveFuseFD receives a descriptor sent with sendFuseFD
+func receiveFuseFD(conn *net.UnixConn) (int, error) {
+	buf := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return -1, err
+	}
+	if n == 0 {
+		return -1, io.ErrUnexpectedEOF
+	}
+
+	var fds []int
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return -1, err
+	}
+	for i := range msgs {
+		rights, err := unix.ParseUnixRights(&msgs[i])
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+
+	reply := string(buf[:n])
+	if reply == fuseFDReady && len(fds) == 1 {
+		return fds[0], nil
+	}
+
+	for _, fd := range fds {
+		unix.Close(fd)
+	}
+	if reply == fuseFDReady {
+		return -1, fmt.Errorf("helper sent %d descriptors, want 1", len(fds))
+	}
+	return -1, helperError(reply)
+}
+
+// helperError converts an error text sent by the helper to an error
+func helperError(reply string) error {
+	return errors.New(strings.TrimPrefix(reply, fuseFDError))
+}
+
+// unmountFuseFD unmounts a filesystem mounted through a pre-opened
+// /dev/fuse descriptor, which go-fuse cannot unmount itself
+func (f *FuseFS) unmountFuseFD() error {
+	if f.fdHelper != nil {
+		return f.releaseFuseFDHelper()
+	}
+	if f.options().Mountpoint == "" {
+		return fmt.Errorf("filesystem mounted from /dev/fuse descriptor %d must be unmounted by the process that mounted it", f.options().FuseFD)
+	}
+	return unmountPath(f.options().Mountpoint, true)
+}
+
+// releaseFuseFDHelper asks the helper to unmount the filesystem and
+// waits for its reply
+func (f *FuseFS) releaseFuseFDHelper() error {
+	conn := f.fdHelper
+	defer conn.Close()
+
+	// The connection is used once; if the helper fails, retries unmount
+	// the mountpoint directly
+	f.fdHelper = nil
+
+	conn.SetDeadline(time.Now().Add(defaultUnmountTimeout))
+	if err := conn.CloseWrite(); err != nil {
+		return err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if len(reply) > 0 {
+		return helperError(string(reply))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package fusefs
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ServeFuseFD mounts a FUSE filesystem at opts.Mountpoint on behalf of the
+// server at the other end of conn, which called Mount with
+// MountOptions.FuseFDSocket, and passes it the /dev/fuse descriptor. The
+// mount belongs to the connecting user as reported by SO_PEERCRED, so
+// that the server needs no privileges.
+//
+// ServeFuseFD returns when the server unmounts or disconnects, e.g. by
+// exiting, after lazily unmounting the filesystem. The mountpoint is
+// prepared as by Mount. Of the remaining options, only FSName,
+// ReadOnly, AllowOther, DefaultPermissions and MaxWrite apply. Mounting
+// requires CAP_SYS_ADMIN.
+//
+// Example, in a privileged sidecar:
+//
+//	l, _ := net.ListenUnix("unix", &net.UnixAddr{Name: "/run/fusefs.sock", Net: "unix"})
+//	for {
+//	    conn, err := l.AcceptUnix()
+//	    if err != nil {
+//	        log.Fatal(err)
+//	    }
+//	    go fusefs.ServeFuseFD(conn, fusefs.DefaultMountOptions("/mnt/data"))
+//	}
+func ServeFuseFD(conn *net.UnixConn, opts *MountOptions) error {
+	defer conn.Close()
+
+	fd, err := mountFuseDevice(conn, opts)
+	if err != nil {
+		sendFuseFDError(conn, err)
+		return err
+	}
+
+	err = sendFuseFD(conn, fd)
+	unix.Close(fd)
+	if err == nil {
+		// Wait for the server to unmount or exit
+		_, err = io.Copy(io.Discard, conn)
+	}
+
+	if unmountErr := unix.Unmount(opts.Mountpoint, unix.MNT_DETACH); unmountErr != nil {
+		unmountErr = fmt.Errorf("failed to unmount %s: %w", opts.Mountpoint, unmountErr)
+		sendFuseFDError(conn, unmountErr)
+		return unmountErr
+	}
+	return err
+}
+
+// mountFuseDevice opens /dev/fuse and mounts it at opts.Mountpoint for
+// the peer of conn
+func mountFuseDevice(conn *net.UnixConn, opts *MountOptions) (int, error) {
+	if err := prepareMountpoint(opts); err != nil {
+		return -1, err
+	}
+
+	cred, err := peerCredentials(conn)
+	if err != nil {
+		return -1, fmt.Errorf("failed to get peer credentials: %w", err)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(opts.Mountpoint, &st); err != nil {
+		return -1, fmt.Errorf("failed to stat mountpoint: %w", err)
+	}
+
+	fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open /dev/fuse: %w", err)
+	}
+
+	data := []string{
+		fmt.Sprintf("fd=%d", fd),
+		fmt.Sprintf("rootmode=%o", st.Mode&unix.S_IFMT),
+		fmt.Sprintf("user_id=%d", cred.Uid),
+		fmt.Sprintf("group_id=%d", cred.Gid),
+	}
+	if opts.MaxWrite > 0 {
+		data = append(data, fmt.Sprintf("max_read=%d", opts.MaxWrite))
+	}
+	if opts.AllowOther {
+		data = append(data, "allow_other")
+	}
+	if opts.DefaultPermissions {
+		data = append(data, "default_permissions")
+	}
+
+	flags := uintptr(unix.MS_NOSUID | unix.MS_NODEV)
+	if opts.ReadOnly {
+		flags |= unix.MS_RDONLY
+	}
+
+	source := opts.FSName
+	if source == "" {
+		source = DefaultMountOptions("").FSName
+	}
+
+	if err := unix.Mount(source, opts.Mountpoint, "fuse."+source, flags, strings.Join(data, ",")); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("failed to mount %s: %w", opts.Mountpoint, err)
+	}
+	return fd, nil
+}
+
+// peerCredentials returns the credentials of the process at the other end
+// of conn
+func peerCredentials(conn *net.UnixConn) (*unix.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
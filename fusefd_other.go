@@ -0,0 +1,17 @@
+//go:build !linux
+
+package fusefs
+
+import (
+	"errors"
+	"net"
+)
+
+// ServeFuseFD is only supported on Linux. It reports the error to the
+// server at the other end of conn.
+func ServeFuseFD(conn *net.UnixConn, opts *MountOptions) error {
+	defer conn.Close()
+
+	sendFuseFDError(conn, errors.ErrUnsupported)
+	return errors.ErrUnsupported
+}
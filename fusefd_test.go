@@ -0,0 +1,190 @@
+package fusefs
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// unixConnPair returns a connected pair of unix sockets
+func unixConnPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("Socketpair: %v", err)
+	}
+
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		file := os.NewFile(uintptr(fd), "socketpair")
+		conn, err := net.FileConn(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("FileConn: %v", err)
+		}
+		conns[i] = conn.(*net.UnixConn)
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conns[0], conns[1]
+}
+
+// sameFile reports whether two descriptors refer to the same file
+func sameFile(t *testing.T, a, b int) bool {
+	t.Helper()
+	var sa, sb unix.Stat_t
+	if err := unix.Fstat(a, &sa); err != nil {
+		t.Fatalf("Fstat: %v", err)
+	}
+	if err := unix.Fstat(b, &sb); err != nil {
+		t.Fatalf("Fstat: %v", err)
+	}
+	return sa.Dev == sb.Dev && sa.Ino == sb.Ino
+}
+
+func TestFuseFD_SendReceive(t *testing.T) {
+	helper, server := unixConnPair(t)
+
+	file, err := os.CreateTemp(t.TempDir(), "fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := sendFuseFD(helper, int(file.Fd())); err != nil {
+		t.Fatalf("sendFuseFD: %v", err)
+	}
+	fd, err := receiveFuseFD(server)
+	if err != nil {
+		t.Fatalf("receiveFuseFD: %v", err)
+	}
+	defer unix.Close(fd)
+
+	if fd == int(file.Fd()) || !sameFile(t, fd, int(file.Fd())) {
+		t.Errorf("received fd %d is not a copy of %d", fd, file.Fd())
+	}
+}
+
+func TestFuseFD_ReceiveError(t *testing.T) {
+	helper, server := unixConnPair(t)
+
+	if err := sendFuseFDError(helper, os.ErrPermission); err != nil {
+		t.Fatalf("sendFuseFDError: %v", err)
+	}
+	_, err := receiveFuseFD(server)
+	if err == nil || err.Error() != os.ErrPermission.Error() {
+		t.Errorf("receiveFuseFD error = %v, want %q", err, os.ErrPermission)
+	}
+
+	// A helper that hangs up without replying
+	helper.Close()
+	if _, err := receiveFuseFD(server); err == nil {
+		t.Error("receiveFuseFD succeeded on a closed connection")
+	}
+}
+
+func TestFuseFD_Helper(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "helper.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		t.Fatalf("ListenUnix: %v", err)
+	}
+	defer l.Close()
+
+	file, err := os.CreateTemp(t.TempDir(), "fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// A helper that passes the file and reports an unmount failure
+	done := make(chan error, 1)
+	go func() {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		if err := sendFuseFD(conn, int(file.Fd())); err != nil {
+			done <- err
+			return
+		}
+		io.Copy(io.Discard, conn)
+		done <- sendFuseFDError(conn, os.ErrInvalid)
+	}()
+
+	opts := DefaultMountOptions("")
+	opts.FuseFDSocket = socketPath
+	f := newFuseFS(nil, opts)
+
+	target, err := f.mountTarget()
+	if err != nil {
+		t.Fatalf("mountTarget: %v", err)
+	}
+	fd, err := strconv.Atoi(strings.TrimPrefix(target, "/dev/fd/"))
+	if err != nil || !strings.HasPrefix(target, "/dev/fd/") {
+		t.Fatalf("mountTarget = %q, want /dev/fd/N", target)
+	}
+	defer unix.Close(fd)
+	if !sameFile(t, fd, int(file.Fd())) {
+		t.Errorf("%s is not the file sent by the helper", target)
+	}
+
+	err = f.unmountFuseFD()
+	if err == nil || err.Error() != os.ErrInvalid.Error() {
+		t.Errorf("unmountFuseFD error = %v, want %q", err, os.ErrInvalid)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("helper: %v", err)
+	}
+}
+
+func TestFuseFD_MountTarget(t *testing.T) {
+	opts := DefaultMountOptions("/mnt/data")
+	f := newFuseFS(nil, opts)
+	if target, err := f.mountTarget(); err != nil || target != "/mnt/data" {
+		t.Errorf("mountTarget = %q, %v, want /mnt/data", target, err)
+	}
+
+	opts.FuseFD = 7
+	if target, err := f.mountTarget(); err != nil || target != "/dev/fd/7" {
+		t.Errorf("mountTarget with FuseFD = %q, %v, want /dev/fd/7", target, err)
+	}
+
+	opts.FuseFD = 0
+	opts.FuseFDSocket = filepath.Join(t.TempDir(), "missing.sock")
+	if _, err := f.mountTarget(); err == nil {
+		t.Error("mountTarget succeeded without a helper")
+	}
+}
+
+func TestFuseFD_PrepareMountpoint(t *testing.T) {
+	mountpoint := filepath.Join(t.TempDir(), "mnt")
+
+	// The mountpoint is neither required nor created
+	opts := DefaultMountOptions("")
+	opts.FuseFD = 3
+	if err := prepareMountpoint(opts); err != nil {
+		t.Errorf("prepareMountpoint without mountpoint: %v", err)
+	}
+
+	opts.Mountpoint = mountpoint
+	if err := prepareMountpoint(opts); err != nil {
+		t.Errorf("prepareMountpoint: %v", err)
+	}
+	if _, err := os.Stat(mountpoint); !os.IsNotExist(err) {
+		t.Errorf("mountpoint was created: %v", err)
+	}
+
+	// Unmounting needs to know the mountpoint
+	opts.Mountpoint = ""
+	if err := newFuseFS(nil, opts).unmountFuseFD(); err == nil {
+		t.Error("unmountFuseFD succeeded without a mountpoint")
+	}
+}
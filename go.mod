@@ -6,7 +6,9 @@ toolchain go1.24.7
 
 require (
 	github.com/absfs/absfs v0.9.1
+	github.com/absfs/memfs v0.9.1
 	github.com/hanwen/go-fuse/v2 v2.9.0
+	golang.org/x/sys v0.38.0
 )
 
-require golang.org/x/sys v0.38.0 // indirect
+require github.com/absfs/inode v0.9.1 // indirect
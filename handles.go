@@ -1,6 +1,8 @@
 package fusefs
 
 import (
+	"os"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -29,6 +31,21 @@ type handleEntry struct {
 	refCount int32
 	flags    int
 	path     string
+
+	// dirty is set by writes and cleared when the file is synced
+	dirty atomic.Bool
+
+	// passthrough is set for writable files the kernel writes directly,
+	// which stay dirty while open
+	passthrough atomic.Bool
+}
+
+// HandleInfo describes an open file handle
+type HandleInfo struct {
+	ID    uint64 `json:"id"`
+	Path  string `json:"path"`
+	Flags int    `json:"flags"`
+	Dirty bool   `json:"dirty"`
 }
 
 // NewHandleTracker creates a new file handle tracker
@@ -100,15 +117,60 @@ func (ht *HandleTracker) Release(fh uint64) syscall.Errno {
 	return 0
 }
 
-// CloseAll closes all open file handles
-func (ht *HandleTracker) CloseAll() {
+// CloseAll closes all open file handles and returns the handles it closed
+func (ht *HandleTracker) CloseAll() []HandleInfo {
 	ht.mu.Lock()
 	defer ht.mu.Unlock()
 
+	closed := make([]HandleInfo, 0, len(ht.handles))
 	for fh, entry := range ht.handles {
+		closed = append(closed, entry.info(fh))
 		entry.file.Close()
 		delete(ht.handles, fh)
 	}
+
+	sort.Slice(closed, func(i, j int) bool { return closed[i].ID < closed[j].ID })
+	return closed
+}
+
+// MarkDirty records that a handle has writes not yet synced to storage
+func (ht *HandleTracker) MarkDirty(fh uint64) {
+	if entry := ht.GetEntry(fh); entry != nil {
+		entry.dirty.Store(true)
+	}
+}
+
+// MarkPassthrough records that the kernel writes a handle's file directly,
+// bypassing MarkDirty, so a writable handle stays dirty until released
+func (ht *HandleTracker) MarkPassthrough(fh uint64) {
+	entry := ht.GetEntry(fh)
+	if entry == nil || entry.flags&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return
+	}
+	entry.passthrough.Store(true)
+	entry.dirty.Store(true)
+}
+
+// Sync syncs a handle's file to storage if it supports Sync, and clears
+// its dirty state. The handle stays dirty if the sync fails or the kernel
+// writes it through passthrough.
+func (ht *HandleTracker) Sync(fh uint64) error {
+	entry := ht.GetEntry(fh)
+	if entry == nil {
+		return syscall.EBADF
+	}
+
+	// Clear before syncing so that writes racing with the sync mark the
+	// handle dirty again
+	entry.dirty.Store(entry.passthrough.Load())
+
+	if syncer, ok := entry.file.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			entry.dirty.Store(true)
+			return err
+		}
+	}
+	return nil
 }
 
 // Count returns the number of open file handles
@@ -118,3 +180,27 @@ func (ht *HandleTracker) Count() int {
 
 	return len(ht.handles)
 }
+
+// List returns information about all open file handles, ordered by ID
+func (ht *HandleTracker) List() []HandleInfo {
+	ht.mu.RLock()
+	defer ht.mu.RUnlock()
+
+	infos := make([]HandleInfo, 0, len(ht.handles))
+	for fh, entry := range ht.handles {
+		infos = append(infos, entry.info(fh))
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// info describes the entry as handle fh
+func (e *handleEntry) info(fh uint64) HandleInfo {
+	return HandleInfo{
+		ID:    fh,
+		Path:  e.path,
+		Flags: e.flags,
+		Dirty: e.dirty.Load(),
+	}
+}
@@ -441,3 +441,33 @@ func BenchmarkHandleTracker_AddRelease(b *testing.B) {
 		ht.Release(fh)
 	}
 }
+
+func TestHandleTracker_DirtySync(t *testing.T) {
+	ht := NewHandleTracker()
+	fh := ht.Add(newMockFile("/test.txt"), os.O_WRONLY, "/test.txt")
+
+	if ht.List()[0].Dirty {
+		t.Error("new handle is dirty")
+	}
+
+	ht.MarkDirty(fh)
+	if !ht.List()[0].Dirty {
+		t.Error("handle not dirty after MarkDirty")
+	}
+
+	if err := ht.Sync(fh); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if ht.List()[0].Dirty {
+		t.Error("handle dirty after Sync")
+	}
+
+	if err := ht.Sync(fh + 1); err != syscall.EBADF {
+		t.Errorf("Sync of unknown handle = %v, want EBADF", err)
+	}
+
+	closed := ht.CloseAll()
+	if len(closed) != 1 || closed[0].ID != fh {
+		t.Errorf("CloseAll = %+v, want handle %d", closed, fh)
+	}
+}
@@ -2,6 +2,8 @@ package fusefs
 
 import (
 	"os"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,17 +27,22 @@ type InodeManager struct {
 	inodeToPath map[uint64]string
 	nextInode   uint64
 
+	// inodeBase is ORed into allocated inode numbers to keep the inodes
+	// of different backends of one mount apart
+	inodeBase uint64
+
 	// Attribute cache with LRU eviction
 	attrCache *lruCache
-	attrTTL   time.Duration
 
 	// Directory listing cache with LRU eviction
 	dirCache *lruCache
-	dirTTL   time.Duration
 
 	// File metadata for change detection (stable, not evicted)
 	metaMu       sync.RWMutex
 	inodeToMeta  map[uint64]*inodeMeta
+
+	// File metadata when the kernel last cached the data of the file
+	cachedMeta map[uint64]inodeMeta
 }
 
 // inodeMeta stores metadata for change detection
@@ -63,10 +70,9 @@ func NewInodeManager(attrCacheSize, dirCacheSize int, attrTTL, dirTTL time.Durat
 		inodeToPath: make(map[uint64]string),
 		nextInode:   1, // Start at 1, reserve 0
 		attrCache:   newLRUCache(attrCacheSize, attrTTL),
-		attrTTL:     attrTTL,
 		dirCache:    newLRUCache(dirCacheSize, dirTTL),
-		dirTTL:      dirTTL,
 		inodeToMeta: make(map[uint64]*inodeMeta),
+		cachedMeta:  make(map[uint64]inodeMeta),
 	}
 }
 
@@ -88,7 +94,7 @@ func (im *InodeManager) GetInode(path string, info os.FileInfo) uint64 {
 
 	// Allocate new inode
 	im.nextInode++
-	ino := im.nextInode
+	ino := im.inodeBase | im.nextInode
 
 	im.pathToInode[path] = ino
 	im.inodeToPath[ino] = path
@@ -128,6 +134,25 @@ func (im *InodeManager) deleteMetaLocked(ino uint64) {
 	defer im.metaMu.Unlock()
 
 	delete(im.inodeToMeta, ino)
+	delete(im.cachedMeta, ino)
+}
+
+// KeepCache reports whether the kernel may keep the data it has cached for
+// inode ino, opened with the attributes info: that is, whether the file
+// has the same modification time and size as when it was last opened.
+// It records info as the state of the data the kernel caches from now on.
+func (im *InodeManager) KeepCache(ino uint64, info os.FileInfo) bool {
+	im.metaMu.Lock()
+	defer im.metaMu.Unlock()
+
+	meta, cached := im.cachedMeta[ino]
+	im.cachedMeta[ino] = inodeMeta{modTime: info.ModTime(), size: info.Size()}
+
+	// Without modification times, changes cannot be told apart
+	if !cached || info.ModTime().IsZero() {
+		return false
+	}
+	return meta.modTime.Equal(info.ModTime()) && meta.size == info.Size()
 }
 
 // GetCached returns a cached attribute if available and not expired
@@ -180,6 +205,98 @@ func (im *InodeManager) InvalidateAttr(path string) {
 	im.attrCache.Delete(path)
 }
 
+// InvalidateTree removes cached attributes and directory listings for path
+// and everything beneath it, as well as the listing of its parent directory
+func (im *InodeManager) InvalidateTree(p string) {
+	p = path.Clean("/" + p)
+	inTree := func(key string) bool {
+		return key == p || p == "/" || strings.HasPrefix(key, p+"/")
+	}
+
+	im.attrCache.DeleteFunc(inTree)
+	im.dirCache.DeleteFunc(inTree)
+	im.dirCache.Delete(path.Dir(p))
+}
+
+// Rename moves the inodes of oldPath and everything beneath it to
+// newPath, replacing those of newPath, and invalidates the cached data of
+// both trees
+func (im *InodeManager) Rename(oldPath, newPath string) {
+	im.pathMu.Lock()
+	moved := im.takeTreeLocked(oldPath)
+	for _, ino := range im.takeTreeLocked(newPath) {
+		delete(im.inodeToPath, ino)
+		im.deleteMetaLocked(ino)
+	}
+	im.putTreeLocked(moved, oldPath, newPath)
+	im.pathMu.Unlock()
+
+	im.InvalidateTree(oldPath)
+	im.InvalidateTree(newPath)
+}
+
+// Exchange swaps the inodes of path1 and path2 and everything beneath
+// them, and invalidates the cached data of both trees
+func (im *InodeManager) Exchange(path1, path2 string) {
+	im.pathMu.Lock()
+	tree1 := im.takeTreeLocked(path1)
+	tree2 := im.takeTreeLocked(path2)
+	im.putTreeLocked(tree1, path1, path2)
+	im.putTreeLocked(tree2, path2, path1)
+	im.pathMu.Unlock()
+
+	im.InvalidateTree(path1)
+	im.InvalidateTree(path2)
+}
+
+// takeTreeLocked removes the inodes of p and everything beneath it from the
+// path mapping and returns them by path (assumes pathMu is held)
+func (im *InodeManager) takeTreeLocked(p string) map[string]uint64 {
+	tree := make(map[string]uint64)
+	for key, ino := range im.pathToInode {
+		if key == p || strings.HasPrefix(key, p+"/") {
+			tree[key] = ino
+			delete(im.pathToInode, key)
+		}
+	}
+	return tree
+}
+
+// putTreeLocked adds the inodes of a tree taken from oldPath to the path
+// mapping at newPath (assumes pathMu is held)
+func (im *InodeManager) putTreeLocked(tree map[string]uint64, oldPath, newPath string) {
+	for key, ino := range tree {
+		key = newPath + strings.TrimPrefix(key, oldPath)
+		im.pathToInode[key] = ino
+		im.inodeToPath[ino] = key
+	}
+}
+
+// SetTTL changes the attribute and directory cache TTLs.
+// The new TTLs apply to entries already in the caches.
+func (im *InodeManager) SetTTL(attrTTL, dirTTL time.Duration) {
+	im.attrCache.SetTTL(attrTTL)
+	im.dirCache.SetTTL(dirTTL)
+}
+
+// SetMaxSize changes the maximum number of cached attributes and
+// directory listings, evicting least recently used entries at once if
+// a cache holds more
+func (im *InodeManager) SetMaxSize(attrCacheSize, dirCacheSize int) {
+	im.attrCache.SetMaxSize(attrCacheSize)
+	im.dirCache.SetMaxSize(dirCacheSize)
+}
+
+// AttrTTL returns the attribute cache TTL
+func (im *InodeManager) AttrTTL() time.Duration {
+	return im.attrCache.TTL()
+}
+
+// DirTTL returns the directory cache TTL
+func (im *InodeManager) DirTTL() time.Duration {
+	return im.dirCache.TTL()
+}
+
 // Clear removes all cached data
 func (im *InodeManager) Clear() {
 	im.pathMu.Lock()
@@ -192,6 +309,7 @@ func (im *InodeManager) Clear() {
 
 	im.metaMu.Lock()
 	im.inodeToMeta = make(map[uint64]*inodeMeta)
+	im.cachedMeta = make(map[uint64]inodeMeta)
 	im.metaMu.Unlock()
 }
 
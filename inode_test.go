@@ -538,3 +538,100 @@ func BenchmarkInodeManager_GetCached(b *testing.B) {
 		im.GetCached(path)
 	}
 }
+
+func TestInodeManager_InvalidateTree(t *testing.T) {
+	im := NewInodeManager(1000, 100, 5*time.Second, 5*time.Second)
+
+	im.Cache("/a", &fuse.Attr{Ino: 1})
+	im.Cache("/a/b", &fuse.Attr{Ino: 2})
+	im.Cache("/ab", &fuse.Attr{Ino: 3})
+	im.CacheDir("/", []fuse.DirEntry{{Name: "a"}})
+	im.CacheDir("/a", []fuse.DirEntry{{Name: "b"}})
+
+	im.InvalidateTree("/a")
+
+	if im.GetCached("/a") != nil || im.GetCached("/a/b") != nil {
+		t.Error("subtree still cached")
+	}
+	if im.GetDirCache("/a") != nil || im.GetDirCache("/") != nil {
+		t.Error("directory listings still cached")
+	}
+	if im.GetCached("/ab") == nil {
+		t.Error("sibling with common prefix was invalidated")
+	}
+
+	im.InvalidateTree("/")
+	if im.GetCached("/ab") != nil {
+		t.Error("root invalidation left entries cached")
+	}
+}
+
+func TestInodeManager_SetTTL(t *testing.T) {
+	im := NewInodeManager(1000, 100, 5*time.Second, 5*time.Second)
+
+	im.SetTTL(10*time.Millisecond, time.Minute)
+	if im.AttrTTL() != 10*time.Millisecond || im.DirTTL() != time.Minute {
+		t.Fatalf("TTLs = %v/%v", im.AttrTTL(), im.DirTTL())
+	}
+
+	im.Cache("/file", &fuse.Attr{Ino: 1})
+	time.Sleep(20 * time.Millisecond)
+	if im.GetCached("/file") != nil {
+		t.Error("entry outlived the new TTL")
+	}
+}
+
+func TestInodeManager_SetMaxSize(t *testing.T) {
+	im := NewInodeManager(10, 10, 0, 0)
+	for _, p := range []string{"/a", "/b", "/c"} {
+		im.Cache(p, &fuse.Attr{})
+		im.CacheDir(p, nil)
+	}
+
+	im.SetMaxSize(1, 2)
+	stats := im.Stats()
+	if stats.AttrCache.Size != 1 || stats.DirCache.Size != 2 {
+		t.Errorf("sizes = %d/%d, want 1/2", stats.AttrCache.Size, stats.DirCache.Size)
+	}
+	if im.GetCached("/c") == nil {
+		t.Error("most recently cached attribute was evicted")
+	}
+}
+
+func TestInodeManager_KeepCache(t *testing.T) {
+	im := NewInodeManager(10, 10, 0, 0)
+	modTime := time.Now()
+	info := &mockFileInfo{name: "file", size: 100, modTime: modTime}
+
+	if im.KeepCache(1, info) {
+		t.Error("KeepCache on first open = true")
+	}
+	if !im.KeepCache(1, info) {
+		t.Error("KeepCache on an unchanged file = false")
+	}
+	if im.KeepCache(2, info) {
+		t.Error("KeepCache for another inode = true")
+	}
+
+	changes := []*mockFileInfo{
+		{name: "file", size: 200, modTime: modTime},
+		{name: "file", size: 200, modTime: modTime.Add(time.Second)},
+	}
+	for _, changed := range changes {
+		if im.KeepCache(1, changed) {
+			t.Errorf("KeepCache after a change to %+v = true", changed)
+		}
+	}
+
+	// Without modification times, a change of content is not visible
+	noTime := &mockFileInfo{name: "file", size: 100}
+	im.KeepCache(3, noTime)
+	if im.KeepCache(3, noTime) {
+		t.Error("KeepCache without a modification time = true")
+	}
+
+	im.Clear()
+	if im.KeepCache(1, changes[1]) {
+		t.Error("KeepCache after Clear = true")
+	}
+}
@@ -0,0 +1,173 @@
+package fusefs
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Operation names reported in Op.Name.
+const (
+	OpLookup      = "Lookup"
+	OpGetattr     = "Getattr"
+	OpSetattr     = "Setattr"
+	OpOpen        = "Open"
+	OpCreate      = "Create"
+	OpRead        = "Read"
+	OpWrite       = "Write"
+	OpFlush       = "Flush"
+	OpFsync       = "Fsync"
+	OpRelease     = "Release"
+	OpAllocate    = "Allocate"
+	OpReaddir     = "Readdir"
+	OpMkdir       = "Mkdir"
+	OpUnlink      = "Unlink"
+	OpRmdir       = "Rmdir"
+	OpRename      = "Rename"
+	OpSymlink     = "Symlink"
+	OpLink        = "Link"
+	OpReadlink    = "Readlink"
+	OpAccess      = "Access"
+	OpStatfs      = "Statfs"
+	OpGetxattr    = "Getxattr"
+	OpSetxattr    = "Setxattr"
+	OpListxattr   = "Listxattr"
+	OpRemovexattr = "Removexattr"
+	OpGetlk       = "Getlk"
+	OpSetlk       = "Setlk"
+	OpSetlkw      = "Setlkw"
+	OpFlock       = "Flock"
+
+	OpCopyFileRange = "CopyFileRange"
+	OpLseek         = "Lseek"
+)
+
+// opNames lists every operation name, in the order used for statistics
+var opNames = []string{
+	OpLookup, OpGetattr, OpSetattr, OpOpen, OpCreate, OpRead, OpWrite,
+	OpFlush, OpFsync, OpRelease, OpAllocate, OpReaddir, OpMkdir, OpUnlink,
+	OpRmdir, OpRename, OpSymlink, OpLink, OpReadlink, OpAccess, OpStatfs,
+	OpGetxattr, OpSetxattr, OpListxattr, OpRemovexattr, OpGetlk, OpSetlk,
+	OpSetlkw, OpFlock, OpCopyFileRange, OpLseek,
+}
+
+// Op describes a single filesystem operation as it passes through the
+// interceptor chain.
+//
+// Interceptors may inspect the descriptor but should not modify it; changes
+// are not reflected in the operation that is eventually performed.
+type Op struct {
+	// Name identifies the operation (one of the Op* constants)
+	Name string
+
+	// Path is the absfs path the operation acts on
+	Path string
+
+	// Target is the second path of two-path operations: the destination of
+	// Rename and CopyFileRange, the existing file for Link and the link
+	// target for Symlink
+	Target string
+
+	// Handle is the file handle ID for operations on an open file,
+	// or 0 for operations on a node
+	Handle uint64
+
+	// Uid, Gid and Pid identify the calling process, if known
+	Uid uint32
+	Gid uint32
+	Pid uint32
+
+	// Offset and Size describe the byte range of Read, Write and Allocate,
+	// and the source range of CopyFileRange; Offset is also the offset
+	// Lseek searches from
+	Offset int64
+	Size   int64
+
+	// run performs the operation itself at the end of the chain
+	run func(ctx context.Context) syscall.Errno
+
+	// backend accumulates time spent in absfs calls
+	backend time.Duration
+
+	// bytes is the number of bytes transferred by the operation
+	bytes int64
+
+	// err is the backend error the operation failed with, if any
+	err error
+}
+
+// OpFunc continues an operation through the rest of the interceptor chain.
+type OpFunc func(ctx context.Context, op *Op) syscall.Errno
+
+// Interceptor wraps filesystem operations, e.g. for auditing, metrics,
+// rate limiting or fault injection.
+//
+// An interceptor either calls next to continue the operation and returns its
+// result, or returns a non-zero errno without calling next to reject the
+// operation. Interceptors run in the order given in MountOptions.Interceptors
+// and must be safe for concurrent use.
+//
+// Example:
+//
+//	deny := func(ctx context.Context, op *fusefs.Op, next fusefs.OpFunc) syscall.Errno {
+//	    if op.Name == fusefs.OpUnlink && strings.HasPrefix(op.Path, "/archive/") {
+//	        return syscall.EPERM
+//	    }
+//	    return next(ctx, op)
+//	}
+//	opts.Interceptors = append(opts.Interceptors, deny)
+type Interceptor func(ctx context.Context, op *Op, next OpFunc) syscall.Errno
+
+// buildChain composes interceptors into a single OpFunc. The first
+// interceptor is outermost; the innermost call runs the operation itself.
+func buildChain(interceptors []Interceptor) OpFunc {
+	chain := func(ctx context.Context, op *Op) syscall.Errno {
+		return op.run(ctx)
+	}
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], chain
+		chain = func(ctx context.Context, op *Op) syscall.Errno {
+			return interceptor(ctx, op, next)
+		}
+	}
+
+	return chain
+}
+
+// newOp creates an operation descriptor, filling in the caller from ctx
+func newOp(ctx context.Context, name, path string) *Op {
+	op := &Op{
+		Name: name,
+		Path: path,
+	}
+
+	if caller, ok := fuse.FromContext(ctx); ok {
+		op.Uid = caller.Uid
+		op.Gid = caller.Gid
+		op.Pid = caller.Pid
+	}
+
+	return op
+}
+
+// intercept runs fn as the operation described by op through the
+// interceptor chain
+func (f *FuseFS) intercept(ctx context.Context, op *Op, fn func(ctx context.Context) syscall.Errno) syscall.Errno {
+	op.run = fn
+	return f.chain(withOp(ctx, op), op)
+}
+
+// recordInterceptor counts every operation in the statistics and records
+// its outcome and latency
+func (f *FuseFS) recordInterceptor(ctx context.Context, op *Op, next OpFunc) syscall.Errno {
+	f.stats.recordOperation()
+
+	start := time.Now()
+	errno := next(ctx, op)
+	f.stats.recordOp(op.Name, time.Since(start), op.backend, op.bytes, errno)
+
+	return errno
+}
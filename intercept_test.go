@@ -0,0 +1,151 @@
+package fusefs
+
+import (
+	"context"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestBuildChain_Order(t *testing.T) {
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, op *Op, next OpFunc) syscall.Errno {
+			calls = append(calls, name+":before")
+			errno := next(ctx, op)
+			calls = append(calls, name+":after")
+			return errno
+		}
+	}
+
+	chain := buildChain([]Interceptor{record("a"), record("b")})
+	op := &Op{Name: OpRead, run: func(ctx context.Context) syscall.Errno {
+		calls = append(calls, "op")
+		return 0
+	}}
+
+	if errno := chain(context.Background(), op); errno != 0 {
+		t.Fatalf("chain returned %v, want 0", errno)
+	}
+
+	want := []string{"a:before", "b:before", "op", "b:after", "a:after"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestBuildChain_ShortCircuit(t *testing.T) {
+	ran := false
+	deny := func(ctx context.Context, op *Op, next OpFunc) syscall.Errno {
+		if op.Name == OpUnlink {
+			return syscall.EPERM
+		}
+		return next(ctx, op)
+	}
+
+	chain := buildChain([]Interceptor{deny})
+	op := &Op{Name: OpUnlink, run: func(ctx context.Context) syscall.Errno {
+		ran = true
+		return 0
+	}}
+
+	if errno := chain(context.Background(), op); errno != syscall.EPERM {
+		t.Errorf("chain returned %v, want EPERM", errno)
+	}
+	if ran {
+		t.Error("operation ran despite being rejected")
+	}
+}
+
+func TestBuildChain_Empty(t *testing.T) {
+	chain := buildChain(nil)
+	op := &Op{Name: OpGetattr, run: func(ctx context.Context) syscall.Errno {
+		return syscall.ENOENT
+	}}
+
+	if errno := chain(context.Background(), op); errno != syscall.ENOENT {
+		t.Errorf("chain returned %v, want ENOENT", errno)
+	}
+}
+
+func TestNewOp_Caller(t *testing.T) {
+	ctx := fuse.NewContext(context.Background(), &fuse.Caller{
+		Owner: fuse.Owner{Uid: 1000, Gid: 100},
+		Pid:   42,
+	})
+
+	op := newOp(ctx, OpOpen, "/file.txt")
+	if op.Name != OpOpen || op.Path != "/file.txt" {
+		t.Errorf("op = %+v, want Open /file.txt", op)
+	}
+	if op.Uid != 1000 || op.Gid != 100 || op.Pid != 42 {
+		t.Errorf("caller = %d/%d/%d, want 1000/100/42", op.Uid, op.Gid, op.Pid)
+	}
+
+	op = newOp(context.Background(), OpOpen, "/file.txt")
+	if op.Uid != 0 || op.Gid != 0 || op.Pid != 0 {
+		t.Errorf("caller without context = %d/%d/%d, want zero", op.Uid, op.Gid, op.Pid)
+	}
+}
+
+func TestFuseFS_Intercept(t *testing.T) {
+	var seen []*Op
+	opts := DefaultMountOptions("/tmp/fusefs-test")
+	opts.Interceptors = []Interceptor{
+		func(ctx context.Context, op *Op, next OpFunc) syscall.Errno {
+			seen = append(seen, op)
+			return next(ctx, op)
+		},
+	}
+	f := newFuseFS(nil, opts)
+
+	errno := f.intercept(context.Background(), &Op{Name: OpMkdir, Path: "/dir"}, func(ctx context.Context) syscall.Errno {
+		return 0
+	})
+	if errno != 0 {
+		t.Fatalf("intercept returned %v, want 0", errno)
+	}
+	if len(seen) != 1 || seen[0].Path != "/dir" {
+		t.Errorf("interceptor saw %v, want one Mkdir /dir", seen)
+	}
+	if ops := f.Stats().Operations; ops != 1 {
+		t.Errorf("Operations = %d, want 1", ops)
+	}
+}
+
+func TestFuseFS_InterceptUnmounting(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+	f.ops.close()
+
+	ran := false
+	run := func(ctx context.Context) syscall.Errno {
+		ran = true
+		return 0
+	}
+
+	// New operations are rejected, including on open handles
+	if errno := f.intercept(context.Background(), &Op{Name: OpLookup, Path: "/a"}, run); errno != syscall.ENOTCONN {
+		t.Errorf("node op returned %v, want ENOTCONN", errno)
+	}
+	if errno := f.intercept(context.Background(), &Op{Name: OpRead, Path: "/a", Handle: 1}, run); errno != syscall.ENOTCONN {
+		t.Errorf("read returned %v, want ENOTCONN", errno)
+	}
+	if ran {
+		t.Error("operation ran while unmounting")
+	}
+
+	// Flushes and releases of open handles still complete
+	if errno := f.intercept(context.Background(), &Op{Name: OpFlush, Path: "/a", Handle: 1}, run); errno != 0 {
+		t.Errorf("flush returned %v, want 0", errno)
+	}
+	if !ran {
+		t.Error("flush did not run while unmounting")
+	}
+
+	// All operations were counted
+	if ops := f.Stats().Operations; ops != 3 {
+		t.Errorf("Operations = %d, want 3", ops)
+	}
+}
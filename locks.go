@@ -2,6 +2,7 @@ package fusefs
 
 import (
 	"context"
+	"sort"
 	"sync"
 	"syscall"
 
@@ -45,6 +46,17 @@ type posixLock struct {
 	pid   uint32
 }
 
+// LockInfo describes a lock held on a file
+type LockInfo struct {
+	Path  string `json:"path"`
+	Kind  string `json:"kind"` // "flock" or "posix"
+	Type  string `json:"type"` // "shared"/"exclusive" for flock, "read"/"write" for posix
+	Owner uint64 `json:"owner"`
+	Start uint64 `json:"start,omitempty"` // posix only
+	End   uint64 `json:"end,omitempty"`   // posix only, exclusive
+	Pid   uint32 `json:"pid,omitempty"`   // posix only
+}
+
 // NewLockManager creates a new lock manager
 func NewLockManager() *LockManager {
 	return &LockManager{
@@ -315,6 +327,56 @@ func (lm *LockManager) ReleaseOwner(owner uint64) {
 	}
 }
 
+// List returns all currently held locks, ordered by path
+func (lm *LockManager) List() []LockInfo {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+
+	var infos []LockInfo
+	for path, state := range lm.flocks {
+		typ := "shared"
+		if state.lockType == syscall.LOCK_EX {
+			typ = "exclusive"
+		}
+		for owner := range state.owners {
+			infos = append(infos, LockInfo{Path: path, Kind: "flock", Type: typ, Owner: owner})
+		}
+	}
+
+	for path, locks := range lm.posixLocks {
+		for _, lock := range locks {
+			typ := "read"
+			if lock.typ == syscall.F_WRLCK {
+				typ = "write"
+			}
+			infos = append(infos, LockInfo{
+				Path:  path,
+				Kind:  "posix",
+				Type:  typ,
+				Owner: lock.owner,
+				Start: lock.start,
+				End:   lock.end,
+				Pid:   lock.pid,
+			})
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Owner != b.Owner {
+			return a.Owner < b.Owner
+		}
+		return a.Start < b.Start
+	})
+	return infos
+}
+
 // rangesOverlap checks if two byte ranges overlap
 func (lm *LockManager) rangesOverlap(start1, end1, start2, end2 uint64) bool {
 	// Handle special case for "whole file" locks
@@ -330,31 +392,51 @@ func (lm *LockManager) rangesOverlap(start1, end1, start2, end2 uint64) bool {
 
 // Getlk implements POSIX lock testing
 func (fh *fuseFileHandle) Getlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) syscall.Errno {
-	fh.node.fusefs.stats.recordOperation()
+	return fh.node.fusefs.intercept(ctx, fh.newOp(ctx, OpGetlk), func(ctx context.Context) syscall.Errno {
+		return fh.getlk(ctx, owner, lk, flags, out)
+	})
+}
 
+// getlk implements Getlk
+func (fh *fuseFileHandle) getlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) syscall.Errno {
 	*out = *lk
-	return fh.node.fusefs.lockManager.Getlk(fh.node.path, owner, out)
+	return fh.node.fusefs.lockManager.Getlk(fh.node.path(), owner, out)
 }
 
 // Setlk implements POSIX lock acquisition (non-blocking)
 func (fh *fuseFileHandle) Setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) syscall.Errno {
-	fh.node.fusefs.stats.recordOperation()
+	return fh.node.fusefs.intercept(ctx, fh.newOp(ctx, OpSetlk), func(ctx context.Context) syscall.Errno {
+		return fh.setlk(ctx, owner, lk, flags)
+	})
+}
 
-	return fh.node.fusefs.lockManager.Setlk(fh.node.path, owner, lk)
+// setlk implements Setlk
+func (fh *fuseFileHandle) setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) syscall.Errno {
+	return fh.node.fusefs.lockManager.Setlk(fh.node.path(), owner, lk)
 }
 
 // Setlkw implements POSIX lock acquisition (blocking)
 func (fh *fuseFileHandle) Setlkw(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) syscall.Errno {
-	fh.node.fusefs.stats.recordOperation()
+	return fh.node.fusefs.intercept(ctx, fh.newOp(ctx, OpSetlkw), func(ctx context.Context) syscall.Errno {
+		return fh.setlkw(ctx, owner, lk, flags)
+	})
+}
 
-	return fh.node.fusefs.lockManager.Setlkw(fh.node.path, owner, lk)
+// setlkw implements Setlkw
+func (fh *fuseFileHandle) setlkw(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) syscall.Errno {
+	return fh.node.fusefs.lockManager.Setlkw(fh.node.path(), owner, lk)
 }
 
 // Flock implements BSD-style file locking
 func (fh *fuseFileHandle) Flock(ctx context.Context, owner uint64, flags uint32) syscall.Errno {
-	fh.node.fusefs.stats.recordOperation()
+	return fh.node.fusefs.intercept(ctx, fh.newOp(ctx, OpFlock), func(ctx context.Context) syscall.Errno {
+		return fh.flock(ctx, owner, flags)
+	})
+}
 
-	return fh.node.fusefs.lockManager.Flock(fh.node.path, owner, flags)
+// flock implements Flock
+func (fh *fuseFileHandle) flock(ctx context.Context, owner uint64, flags uint32) syscall.Errno {
+	return fh.node.fusefs.lockManager.Flock(fh.node.path(), owner, flags)
 }
 
 // Ensure fuseFileHandle implements locking interfaces
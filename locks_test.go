@@ -1031,3 +1031,21 @@ func BenchmarkLockManager_ConcurrentShared(b *testing.B) {
 		}
 	}
 }
+
+func TestLockManager_List(t *testing.T) {
+	lm := NewLockManager()
+
+	lm.Flock("/b.txt", 1, syscall.LOCK_SH)
+	lm.Setlk("/a.txt", 2, &fuse.FileLock{Start: 0, End: 99, Typ: syscall.F_WRLCK, Pid: 42})
+
+	locks := lm.List()
+	if len(locks) != 2 {
+		t.Fatalf("got %d locks, want 2", len(locks))
+	}
+	if locks[0].Path != "/a.txt" || locks[0].Kind != "posix" || locks[0].Type != "write" || locks[0].Pid != 42 {
+		t.Errorf("locks[0] = %+v", locks[0])
+	}
+	if locks[1].Path != "/b.txt" || locks[1].Kind != "flock" || locks[1].Type != "shared" {
+		t.Errorf("locks[1] = %+v", locks[1])
+	}
+}
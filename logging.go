@@ -0,0 +1,148 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// backendError records an error returned by the absfs backend and maps it
+// to an errno for the kernel.
+//
+// The original error is logged before mapError flattens it, so that errors
+// without a specific mapping, which reach the caller as EIO, remain visible.
+func (f *FuseFS) backendError(ctx context.Context, err error) syscall.Errno {
+	f.stats.recordError()
+	errno := mapError(err)
+
+	op, _ := ctx.Value(opContextKey{}).(*Op)
+	if op != nil {
+		op.err = err
+	}
+
+	level, msg := slog.LevelDebug, "backend error"
+	var raw syscall.Errno
+	if errno == syscall.EIO && !(errors.As(err, &raw) && raw == syscall.EIO) {
+		level, msg = slog.LevelError, "unmapped backend error"
+	}
+	if !f.logEnabled(ctx, level) {
+		return errno
+	}
+
+	attrs := []slog.Attr{
+		slog.String("error", err.Error()),
+		slog.String("errno", errnoName(errno)),
+	}
+	if op != nil {
+		attrs = append(attrs, slog.String("op", op.Name), slog.String("path", op.Path))
+	}
+	f.options().Logger.LogAttrs(ctx, level, msg, attrs...)
+
+	return errno
+}
+
+// logInterceptor emits a structured log record for every operation.
+//
+// Operations are logged at debug level; operations slower than
+// MountOptions.SlowOpThreshold are logged as warnings. The logger and the
+// level are checked for each operation, so that they can be changed while
+// mounted.
+func (f *FuseFS) logInterceptor(ctx context.Context, op *Op, next OpFunc) syscall.Errno {
+	if f.options().Logger == nil {
+		return next(ctx, op)
+	}
+
+	start := time.Now()
+	errno := next(ctx, op)
+	duration := time.Since(start)
+
+	level, msg := slog.LevelDebug, "operation"
+	if threshold := f.options().SlowOpThreshold; threshold > 0 && duration > threshold {
+		level, msg = slog.LevelWarn, "slow operation"
+	}
+
+	if !f.logEnabled(ctx, level) {
+		return errno
+	}
+
+	attrs := make([]slog.Attr, 0, 10)
+	attrs = append(attrs,
+		slog.String("op", op.Name),
+		slog.String("path", op.Path),
+	)
+	if op.Target != "" {
+		attrs = append(attrs, slog.String("target", op.Target))
+	}
+	if op.Handle != 0 {
+		attrs = append(attrs, slog.Uint64("handle", op.Handle))
+	}
+	attrs = append(attrs,
+		slog.Group("caller",
+			slog.Uint64("uid", uint64(op.Uid)),
+			slog.Uint64("gid", uint64(op.Gid)),
+			slog.Uint64("pid", uint64(op.Pid)),
+		),
+		slog.Duration("duration", duration),
+	)
+	if op.backend > 0 {
+		attrs = append(attrs, slog.Duration("backend", op.backend))
+	}
+	if op.bytes > 0 {
+		attrs = append(attrs, slog.Int64("bytes", op.bytes))
+	}
+	if errno != 0 {
+		attrs = append(attrs, slog.String("errno", errnoName(errno)))
+	}
+	if op.err != nil {
+		attrs = append(attrs, slog.String("error", op.err.Error()))
+	}
+	f.options().Logger.LogAttrs(ctx, level, msg, attrs...)
+
+	return errno
+}
+
+// LogLevelOff disables logging when passed to FuseFS.SetLogLevel
+const LogLevelOff = slog.Level(math.MaxInt32)
+
+// SetLogLevel sets the minimum level of records passed to
+// MountOptions.Logger. The logger's own handler may filter further.
+// The default is slog.LevelDebug, which leaves filtering to the handler.
+func (f *FuseFS) SetLogLevel(level slog.Level) {
+	f.logLevel.Set(level)
+}
+
+// LogLevel returns the minimum level of records passed to the logger
+func (f *FuseFS) LogLevel() slog.Level {
+	return f.logLevel.Level()
+}
+
+// logEnabled reports whether a record at level would be logged
+func (f *FuseFS) logEnabled(ctx context.Context, level slog.Level) bool {
+	if f.options().Logger == nil || level < f.logLevel.Level() {
+		return false
+	}
+	return f.options().Logger.Enabled(ctx, level)
+}
+
+// parseLogLevel parses a level name as accepted by slog, or "off"
+func parseLogLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "off") {
+		return LogLevelOff, nil
+	}
+
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// formatLogLevel formats a level for display, the inverse of parseLogLevel
+func formatLogLevel(level slog.Level) string {
+	if level == LogLevelOff {
+		return "off"
+	}
+	return strings.ToLower(level.String())
+}
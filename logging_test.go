@@ -0,0 +1,179 @@
+package fusefs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newLoggedFuseFS creates a FuseFS logging JSON records at the given level
+func newLoggedFuseFS(level slog.Level) (*FuseFS, *bytes.Buffer) {
+	var buf bytes.Buffer
+	opts := DefaultMountOptions("/tmp/fusefs-test")
+	opts.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: level}))
+	opts.SlowOpThreshold = 5 * time.Millisecond
+	return newFuseFS(nil, opts), &buf
+}
+
+// logRecords decodes the JSON log records in buf
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogInterceptor_Operation(t *testing.T) {
+	f, buf := newLoggedFuseFS(slog.LevelDebug)
+
+	op := &Op{Name: OpRead, Path: "/file.txt", Handle: 3, Uid: 1000, Pid: 7}
+	f.intercept(context.Background(), op, func(ctx context.Context) syscall.Errno {
+		op.bytes = 128
+		return 0
+	})
+
+	records := logRecords(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+
+	r := records[0]
+	if r["level"] != "DEBUG" || r["msg"] != "operation" {
+		t.Errorf("level/msg = %v/%v, want DEBUG/operation", r["level"], r["msg"])
+	}
+	if r["op"] != "Read" || r["path"] != "/file.txt" || r["bytes"] != float64(128) || r["handle"] != float64(3) {
+		t.Errorf("unexpected record %v", r)
+	}
+	caller, _ := r["caller"].(map[string]any)
+	if caller["uid"] != float64(1000) || caller["pid"] != float64(7) {
+		t.Errorf("caller = %v, want uid 1000 pid 7", caller)
+	}
+	if _, ok := r["errno"]; ok {
+		t.Error("successful operation logged an errno")
+	}
+}
+
+func TestLogInterceptor_SlowOperation(t *testing.T) {
+	f, buf := newLoggedFuseFS(slog.LevelWarn)
+
+	f.intercept(context.Background(), &Op{Name: OpLookup, Path: "/a"}, func(ctx context.Context) syscall.Errno {
+		return 0
+	})
+	if buf.Len() != 0 {
+		t.Errorf("fast operation logged at warn level: %s", buf.String())
+	}
+
+	f.intercept(context.Background(), &Op{Name: OpLookup, Path: "/slow"}, func(ctx context.Context) syscall.Errno {
+		time.Sleep(10 * time.Millisecond)
+		return 0
+	})
+
+	records := logRecords(t, buf)
+	if len(records) != 1 || records[0]["msg"] != "slow operation" || records[0]["path"] != "/slow" {
+		t.Errorf("records = %v, want one slow operation for /slow", records)
+	}
+}
+
+func TestLogInterceptor_ReconfiguredLogger(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+	lookup := func(ctx context.Context) syscall.Errno { return 0 }
+
+	// A logger set after the filesystem is created logs operations
+	var buf bytes.Buffer
+	err := f.Reconfigure(func(o *MountOptions) {
+		o.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	})
+	if err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	buf.Reset()
+	f.intercept(context.Background(), &Op{Name: OpLookup, Path: "/a"}, lookup)
+
+	records := logRecords(t, &buf)
+	if len(records) != 1 || records[0]["path"] != "/a" {
+		t.Fatalf("records = %v, want one operation for /a", records)
+	}
+
+	// Raising the level takes effect on the next operation
+	f.SetLogLevel(slog.LevelInfo)
+	f.intercept(context.Background(), &Op{Name: OpLookup, Path: "/b"}, lookup)
+	if records := logRecords(t, &buf); len(records) != 1 {
+		t.Errorf("records = %v, want no record below info level", records)
+	}
+}
+
+func TestBackendError_Logging(t *testing.T) {
+	f, buf := newLoggedFuseFS(slog.LevelDebug)
+
+	op := &Op{Name: OpGetattr, Path: "/remote"}
+	errno := f.intercept(context.Background(), op, func(ctx context.Context) syscall.Errno {
+		return f.backendError(ctx, errors.New("connection reset by peer"))
+	})
+	if errno != syscall.EIO {
+		t.Fatalf("errno = %v, want EIO", errno)
+	}
+
+	records := logRecords(t, buf)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	// The unmapped error is logged before it is flattened to EIO
+	if records[0]["level"] != "ERROR" || records[0]["msg"] != "unmapped backend error" {
+		t.Errorf("backend record = %v, want unmapped backend error at ERROR", records[0])
+	}
+	if records[0]["error"] != "connection reset by peer" || records[0]["op"] != "Getattr" {
+		t.Errorf("backend record = %v", records[0])
+	}
+
+	// The operation record carries both the errno and the original error
+	if records[1]["errno"] != "EIO" || records[1]["error"] != "connection reset by peer" {
+		t.Errorf("operation record = %v", records[1])
+	}
+
+	if f.Stats().Errors != 1 {
+		t.Errorf("Errors = %d, want 1", f.Stats().Errors)
+	}
+}
+
+func TestBackendError_MappedIsDebug(t *testing.T) {
+	f, buf := newLoggedFuseFS(slog.LevelInfo)
+
+	errno := f.intercept(context.Background(), &Op{Name: OpLookup, Path: "/missing"}, func(ctx context.Context) syscall.Errno {
+		return f.backendError(ctx, os.ErrNotExist)
+	})
+	if errno != syscall.ENOENT {
+		t.Errorf("errno = %v, want ENOENT", errno)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("mapped error logged above debug level: %s", buf.String())
+	}
+}
+
+func TestBackendError_NoLogger(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+
+	if errno := f.backendError(context.Background(), syscall.EIO); errno != syscall.EIO {
+		t.Errorf("errno = %v, want EIO", errno)
+	}
+	if f.Stats().Errors != 1 {
+		t.Errorf("Errors = %d, want 1", f.Stats().Errors)
+	}
+}
@@ -0,0 +1,415 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// ManagerOptions configures a MountManager
+type ManagerOptions struct {
+	// RestartDelay is the wait before restarting a mount whose server
+	// exited unexpectedly. It doubles with each consecutive failed
+	// restart, up to MaxRestartDelay.
+	RestartDelay    time.Duration
+	MaxRestartDelay time.Duration
+
+	// MaxRestarts limits the consecutive failed restarts of a mount,
+	// after which the mount is given up and removed from the manager.
+	// Zero retries forever.
+	MaxRestarts int
+
+	// UnmountTimeout bounds the graceful unmount of each mount, see
+	// FuseFS.UnmountContext
+	UnmountTimeout time.Duration
+
+	// Logger receives records of restarts and failures. Nil disables
+	// logging.
+	Logger *slog.Logger
+}
+
+// DefaultManagerOptions returns manager options with sensible defaults:
+// restarts are retried forever, starting after 1 second and backing off
+// to at most 1 minute, and each unmount may take 10 seconds.
+func DefaultManagerOptions() *ManagerOptions {
+	return &ManagerOptions{
+		RestartDelay:    1 * time.Second,
+		MaxRestartDelay: 1 * time.Minute,
+		MaxRestarts:     0,
+		UnmountTimeout:  defaultUnmountTimeout,
+	}
+}
+
+// MountManager runs and supervises many mounts in one process.
+//
+// Mounts are identified by name. Each mount is watched, and one whose
+// FUSE server exits without being unmounted through the manager, e.g.
+// because it was unmounted externally or its connection was aborted, is
+// mounted again with the same filesystem and options. Run unmounts all
+// mounts gracefully on SIGINT or SIGTERM.
+//
+// Example:
+//
+//	m := fusefs.NewMountManager(nil)
+//	for name, fsys := range filesystems {
+//	    if _, err := m.Mount(name, fsys, fusefs.DefaultMountOptions("/mnt/"+name)); err != nil {
+//	        log.Fatal(err)
+//	    }
+//	}
+//	if err := m.Run(context.Background()); err != nil {
+//	    log.Print(err)
+//	}
+type MountManager struct {
+	opts *ManagerOptions
+
+	mu     sync.Mutex
+	mounts map[string]*managedMount
+	closed bool
+
+	// mount and wait mount a filesystem and block until its server exits
+	mount func(absfs.FileSystem, *MountOptions) (*FuseFS, error)
+	wait  func(*FuseFS)
+}
+
+// managedMount is a mount supervised by a MountManager
+type managedMount struct {
+	name string
+	fsys absfs.FileSystem
+	opts *MountOptions
+
+	// fs is the current mount, replaced on restart, and unmounting is
+	// set while the manager unmounts it; guarded by the manager's mutex
+	fs         *FuseFS
+	restarts   int
+	unmounting bool
+
+	// stop is closed when the mount is unmounted through the manager;
+	// done is closed when its supervisor returns
+	stop chan struct{}
+	done chan struct{}
+}
+
+// ManagerStats contains the statistics of the mounts of a MountManager
+type ManagerStats struct {
+	// Total sums the statistics of all mounts
+	Total Stats
+
+	// Mounts contains the statistics of each mount by name
+	Mounts map[string]Stats
+
+	// Restarts counts the restarts of each mount by name
+	Restarts map[string]int
+}
+
+// NewMountManager creates a mount manager. Nil options use
+// DefaultManagerOptions.
+func NewMountManager(opts *ManagerOptions) *MountManager {
+	if opts == nil {
+		opts = DefaultManagerOptions()
+	}
+	return &MountManager{
+		opts:   opts,
+		mounts: make(map[string]*managedMount),
+		mount:  Mount,
+		wait: func(f *FuseFS) {
+			f.Wait()
+		},
+	}
+}
+
+// Mount mounts fsys under name and supervises it until it is unmounted
+// through Unmount, UnmountAll or Run. Names must be unique among the
+// manager's mounts.
+func (m *MountManager) Mount(name string, fsys absfs.FileSystem, opts *MountOptions) (*FuseFS, error) {
+	if name == "" {
+		return nil, fmt.Errorf("mount name cannot be empty")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, fmt.Errorf("mount manager is closed")
+	}
+	if _, exists := m.mounts[name]; exists {
+		return nil, fmt.Errorf("mount %q already exists", name)
+	}
+
+	fuseFS, err := m.mount(fsys, opts)
+	if err != nil {
+		return nil, fmt.Errorf("mount %q: %w", name, err)
+	}
+
+	mm := &managedMount{
+		name: name,
+		fsys: fsys,
+		opts: opts,
+		fs:   fuseFS,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	m.mounts[name] = mm
+	go m.supervise(mm)
+
+	return fuseFS, nil
+}
+
+// Unmount gracefully unmounts the mount called name and stops
+// supervising it. If the FUSE filesystem cannot be unmounted, e.g. with
+// EBUSY, the mount stays managed and Unmount may be retried.
+func (m *MountManager) Unmount(name string) error {
+	m.mu.Lock()
+	mm, ok := m.mounts[name]
+	if ok {
+		delete(m.mounts, name)
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("mount %q not found", name)
+	}
+	return m.unmount(mm)
+}
+
+// UnmountAll gracefully unmounts all mounts concurrently. Mounts that
+// cannot be unmounted stay managed, as with Unmount.
+func (m *MountManager) UnmountAll() error {
+	m.mu.Lock()
+	mounts := m.mounts
+	m.mounts = make(map[string]*managedMount)
+	m.mu.Unlock()
+
+	errs := make([]error, 0, len(mounts))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, mm := range mounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.unmount(mm); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Run blocks until ctx is done or the process receives SIGINT or SIGTERM,
+// then unmounts all mounts and closes the manager to new mounts
+func (m *MountManager) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	<-ctx.Done()
+	m.log(slog.LevelInfo, "unmounting all", slog.Int("mounts", len(m.Names())))
+	return m.Close()
+}
+
+// Close unmounts all mounts and rejects further mounts
+func (m *MountManager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	return m.UnmountAll()
+}
+
+// Get returns the current FuseFS of the mount called name. The instance
+// changes when the mount is restarted.
+func (m *MountManager) Get(name string) (*FuseFS, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mm, ok := m.mounts[name]
+	if !ok {
+		return nil, false
+	}
+	return mm.fs, true
+}
+
+// Names returns the names of all mounts in sorted order
+func (m *MountManager) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.mounts))
+	for name := range m.mounts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Stats returns the statistics of each mount and their total. The
+// statistics of a restarted mount start over from zero.
+func (m *MountManager) Stats() ManagerStats {
+	m.mu.Lock()
+	mounts := make(map[string]*FuseFS, len(m.mounts))
+	stats := ManagerStats{
+		Mounts:   make(map[string]Stats, len(m.mounts)),
+		Restarts: make(map[string]int, len(m.mounts)),
+	}
+	for name, mm := range m.mounts {
+		mounts[name] = mm.fs
+		stats.Restarts[name] = mm.restarts
+	}
+	m.mu.Unlock()
+
+	stats.Total = Stats{Time: time.Now()}
+	for name, fuseFS := range mounts {
+		s := fuseFS.Stats()
+		stats.Mounts[name] = s
+		stats.Total = stats.Total.add(s)
+	}
+	return stats
+}
+
+// unmount unmounts mm and stops supervising it. If the FUSE filesystem
+// stays mounted, mm is managed again under its name, unless the name was
+// reused meanwhile.
+func (m *MountManager) unmount(mm *managedMount) error {
+	m.mu.Lock()
+	mm.unmounting = true
+	fuseFS := mm.fs
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.opts.UnmountTimeout)
+	defer cancel()
+
+	_, err := fuseFS.UnmountContext(ctx)
+	if !fuseFS.isUnmounted() {
+		// The server is still running and supervised
+		m.mu.Lock()
+		mm.unmounting = false
+		if _, taken := m.mounts[mm.name]; !taken {
+			m.mounts[mm.name] = mm
+		}
+		m.mu.Unlock()
+		return fmt.Errorf("unmount %q: %w", mm.name, err)
+	}
+
+	close(mm.stop)
+	<-mm.done
+	if err != nil {
+		return fmt.Errorf("unmount %q: %w", mm.name, err)
+	}
+	return nil
+}
+
+// stopped reports whether mm is being or was unmounted through the
+// manager. It must be called with the manager's mutex held.
+func (mm *managedMount) stopped() bool {
+	if mm.unmounting {
+		return true
+	}
+	select {
+	case <-mm.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// supervise waits for the server of mm to exit and restarts it unless
+// mm was unmounted through the manager
+func (m *MountManager) supervise(mm *managedMount) {
+	defer close(mm.done)
+
+	for {
+		m.mu.Lock()
+		fuseFS := mm.fs
+		m.mu.Unlock()
+
+		m.wait(fuseFS)
+		m.mu.Lock()
+		stopped := mm.stopped()
+		m.mu.Unlock()
+		if stopped {
+			return
+		}
+
+		m.log(slog.LevelWarn, "mount exited unexpectedly, restarting",
+			slog.String("name", mm.name),
+			slog.String("mountpoint", mm.opts.Mountpoint))
+
+		// Release what is left of the old mount
+		fuseFS.UnmountContext(context.Background())
+
+		if !m.restart(mm, fuseFS.options()) {
+			return
+		}
+	}
+}
+
+// restart mounts mm again with the options of its last mount, including
+// changes made with FuseFS.Reconfigure, retrying with backoff. It returns
+// false if mm was unmounted through the manager or given up.
+func (m *MountManager) restart(mm *managedMount, last *MountOptions) bool {
+	// The old mount may be left stale at the mountpoint
+	opts := *last
+	opts.RecoverStale = true
+
+	delay := m.opts.RestartDelay
+	for failures := 0; ; failures++ {
+		if m.opts.MaxRestarts > 0 && failures >= m.opts.MaxRestarts {
+			m.log(slog.LevelError, "giving up restarting mount",
+				slog.String("name", mm.name),
+				slog.Int("failures", failures))
+			m.mu.Lock()
+			if m.mounts[mm.name] == mm {
+				delete(m.mounts, mm.name)
+			}
+			m.mu.Unlock()
+			return false
+		}
+
+		select {
+		case <-mm.stop:
+			return false
+		case <-time.After(delay):
+		}
+
+		fuseFS, err := m.mount(mm.fsys, &opts)
+		if err == nil {
+			m.mu.Lock()
+			if mm.stopped() {
+				// Unmount raced with the restart
+				m.mu.Unlock()
+				fuseFS.Unmount()
+				return false
+			}
+			mm.fs = fuseFS
+			mm.restarts++
+			m.mu.Unlock()
+
+			m.log(slog.LevelInfo, "mount restarted", slog.String("name", mm.name))
+			return true
+		}
+
+		m.log(slog.LevelError, "failed to restart mount",
+			slog.String("name", mm.name),
+			slog.String("error", err.Error()))
+		delay = min(2*delay, max(m.opts.MaxRestartDelay, m.opts.RestartDelay))
+	}
+}
+
+// log writes a record to the manager's logger, if any
+func (m *MountManager) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	if m.opts.Logger == nil {
+		return
+	}
+	m.opts.Logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
@@ -0,0 +1,302 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/absfs/absfs"
+)
+
+// fakeMounts replaces the mounting of a MountManager with FuseFS
+// instances that are never mounted. A mount's server "exits" when it is
+// unmounted or crashed.
+type fakeMounts struct {
+	mu      sync.Mutex
+	mounted []*FuseFS
+	crashes map[*FuseFS]chan struct{}
+	fail    error
+}
+
+func newTestManager(t *testing.T) (*MountManager, *fakeMounts) {
+	t.Helper()
+	fake := &fakeMounts{crashes: make(map[*FuseFS]chan struct{})}
+
+	opts := DefaultManagerOptions()
+	opts.RestartDelay = time.Millisecond
+	opts.MaxRestartDelay = time.Millisecond
+	m := NewMountManager(opts)
+	m.mount = func(fsys absfs.FileSystem, opts *MountOptions) (*FuseFS, error) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if fake.fail != nil {
+			return nil, fake.fail
+		}
+		f := newFuseFS(fsys, opts)
+		fake.mounted = append(fake.mounted, f)
+		fake.crashes[f] = make(chan struct{})
+		return f, nil
+	}
+	m.wait = func(f *FuseFS) {
+		fake.mu.Lock()
+		crash := fake.crashes[f]
+		fake.mu.Unlock()
+		for !f.isUnmounted() {
+			select {
+			case <-crash:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	t.Cleanup(func() { m.Close() })
+	return m, fake
+}
+
+// crash makes the server of f exit
+func (fake *fakeMounts) crash(f *FuseFS) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	close(fake.crashes[f])
+}
+
+func (fake *fakeMounts) count() int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return len(fake.mounted)
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMountManager_MountUnmount(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	a, err := m.Mount("a", nil, DefaultMountOptions("/mnt/a"))
+	if err != nil {
+		t.Fatalf("Mount a: %v", err)
+	}
+	if _, err := m.Mount("b", nil, DefaultMountOptions("/mnt/b")); err != nil {
+		t.Fatalf("Mount b: %v", err)
+	}
+	if _, err := m.Mount("a", nil, DefaultMountOptions("/mnt/a2")); err == nil {
+		t.Error("duplicate name was mounted")
+	}
+	if _, err := m.Mount("", nil, DefaultMountOptions("/mnt/c")); err == nil {
+		t.Error("empty name was mounted")
+	}
+
+	if names := m.Names(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Names = %v, want [a b]", names)
+	}
+	if got, ok := m.Get("a"); !ok || got != a {
+		t.Errorf("Get(a) = %p, %v, want %p", got, ok, a)
+	}
+
+	if err := m.Unmount("a"); err != nil {
+		t.Fatalf("Unmount a: %v", err)
+	}
+	if !a.checkUnmounting() {
+		t.Error("a was not unmounted")
+	}
+	if _, ok := m.Get("a"); ok {
+		t.Error("a is still managed after Unmount")
+	}
+	if err := m.Unmount("a"); err == nil {
+		t.Error("second Unmount of a succeeded")
+	}
+}
+
+func TestMountManager_UnmountFails(t *testing.T) {
+	m, _ := newTestManager(t)
+	a, err := m.Mount("a", nil, DefaultMountOptions("/mnt/a"))
+	if err != nil {
+		t.Fatalf("Mount a: %v", err)
+	}
+
+	// The kernel refuses to unmount while files are open
+	var busy atomic.Bool
+	busy.Store(true)
+	a.kernelUnmount = func() error {
+		if busy.Load() {
+			return syscall.EBUSY
+		}
+		return nil
+	}
+
+	for _, unmount := range []func() error{
+		func() error { return m.Unmount("a") },
+		m.UnmountAll,
+	} {
+		done := make(chan error, 1)
+		go func() { done <- unmount() }()
+		select {
+		case err := <-done:
+			if !errors.Is(err, syscall.EBUSY) {
+				t.Errorf("unmount error = %v, want EBUSY", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("unmount hangs after the kernel unmount failed")
+		}
+		if got, ok := m.Get("a"); !ok || got != a {
+			t.Errorf("Get(a) after a failed unmount = %p, %v, want %p", got, ok, a)
+		}
+	}
+
+	busy.Store(false)
+	if err := m.Unmount("a"); err != nil {
+		t.Fatalf("retried Unmount: %v", err)
+	}
+	if names := m.Names(); len(names) != 0 {
+		t.Errorf("Names = %v, want none", names)
+	}
+}
+
+func TestMountManager_MountError(t *testing.T) {
+	m, fake := newTestManager(t)
+	fake.fail = errors.New("no fuse")
+
+	if _, err := m.Mount("a", nil, DefaultMountOptions("/mnt/a")); err == nil {
+		t.Fatal("Mount succeeded")
+	}
+	if names := m.Names(); len(names) != 0 {
+		t.Errorf("Names = %v, want none", names)
+	}
+}
+
+func TestMountManager_Restart(t *testing.T) {
+	m, fake := newTestManager(t)
+
+	opts := DefaultMountOptions("/mnt/a")
+	first, err := m.Mount("a", nil, opts)
+	if err != nil {
+		t.Fatalf("Mount: %v", err)
+	}
+
+	fake.crash(first)
+	waitFor(t, "restart", func() bool {
+		f, _ := m.Get("a")
+		return f != first
+	})
+
+	if !first.checkUnmounting() {
+		t.Error("crashed mount was not released")
+	}
+	if opts.RecoverStale {
+		t.Error("restart modified the caller's options")
+	}
+	second, _ := m.Get("a")
+	if !second.options().RecoverStale || second.options().Mountpoint != "/mnt/a" {
+		t.Errorf("restart options = %+v, want /mnt/a with RecoverStale", second.options())
+	}
+	if restarts := m.Stats().Restarts["a"]; restarts != 1 {
+		t.Errorf("Restarts = %d, want 1", restarts)
+	}
+
+	// Unmounting through the manager does not restart
+	if err := m.Unmount("a"); err != nil {
+		t.Fatalf("Unmount: %v", err)
+	}
+	if n := fake.count(); n != 2 {
+		t.Errorf("mounted %d times, want 2", n)
+	}
+}
+
+func TestMountManager_GiveUp(t *testing.T) {
+	m, fake := newTestManager(t)
+	m.opts.MaxRestarts = 3
+
+	first, err := m.Mount("a", nil, DefaultMountOptions("/mnt/a"))
+	if err != nil {
+		t.Fatalf("Mount: %v", err)
+	}
+
+	fake.mu.Lock()
+	fake.fail = errors.New("backend gone")
+	fake.mu.Unlock()
+	fake.crash(first)
+
+	waitFor(t, "give up", func() bool {
+		_, ok := m.Get("a")
+		return !ok
+	})
+}
+
+func TestMountManager_Run(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	a, _ := m.Mount("a", nil, DefaultMountOptions("/mnt/a"))
+	b, _ := m.Mount("b", nil, DefaultMountOptions("/mnt/b"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	if !a.checkUnmounting() || !b.checkUnmounting() {
+		t.Error("Run did not unmount all mounts")
+	}
+	if _, err := m.Mount("c", nil, DefaultMountOptions("/mnt/c")); err == nil {
+		t.Error("Mount succeeded after Run returned")
+	}
+}
+
+func TestMountManager_Stats(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	a, _ := m.Mount("a", nil, DefaultMountOptions("/mnt/a"))
+	b, _ := m.Mount("b", nil, DefaultMountOptions("/mnt/b"))
+
+	a.stats.recordOperation()
+	a.stats.recordOp(OpRead, time.Millisecond, 0, 100, 0)
+	b.stats.recordOperation()
+	b.stats.recordOperation()
+	b.stats.recordOp(OpRead, time.Second, 0, 50, 0)
+	b.stats.recordOp(OpLookup, time.Millisecond, 0, 0, syscall.ENOENT)
+
+	stats := m.Stats()
+	if len(stats.Mounts) != 2 || stats.Mounts["a"].Mountpoint != "/mnt/a" {
+		t.Errorf("Mounts = %v, want a and b", stats.Mounts)
+	}
+
+	total := stats.Total
+	if total.Operations != 3 {
+		t.Errorf("Total Operations = %d, want 3", total.Operations)
+	}
+	read := total.Ops[OpRead]
+	if read.Count != 2 || read.Bytes != 150 || read.Latency.Count != 2 {
+		t.Errorf("Total Read = %+v, want 2 reads of 150 bytes", read)
+	}
+	if read.Latency.Quantile(1) != time.Second {
+		t.Errorf("Total Read max latency = %v, want 1s", read.Latency.Quantile(1))
+	}
+	if total.Errnos[syscall.ENOENT] != 1 {
+		t.Errorf("Total Errnos = %v, want one ENOENT", total.Errnos)
+	}
+	if total.Mountpoint != "" {
+		t.Errorf("Total Mountpoint = %q, want empty", total.Mountpoint)
+	}
+}
@@ -0,0 +1,247 @@
+package fusefs
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// MetricsHandler serves filesystem statistics in the Prometheus text
+// exposition format.
+//
+// Every series carries "mountpoint" and "fsname" labels, so a single handler
+// can export several mounts of one process without the series colliding.
+//
+// Example:
+//
+//	metrics := fusefs.NewMetricsHandler(fuseFS)
+//	http.Handle("/metrics", metrics)
+//	go http.ListenAndServe(":9100", nil)
+type MetricsHandler struct {
+	mu     sync.RWMutex
+	mounts []*FuseFS
+}
+
+// NewMetricsHandler creates a metrics handler exporting the given filesystems
+func NewMetricsHandler(mounts ...*FuseFS) *MetricsHandler {
+	return &MetricsHandler{
+		mounts: append([]*FuseFS(nil), mounts...),
+	}
+}
+
+// Add starts exporting statistics for f
+func (h *MetricsHandler) Add(f *FuseFS) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, m := range h.mounts {
+		if m == f {
+			return
+		}
+	}
+	h.mounts = append(h.mounts, f)
+}
+
+// Remove stops exporting statistics for f
+func (h *MetricsHandler) Remove(f *FuseFS) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, m := range h.mounts {
+		if m == f {
+			h.mounts = append(h.mounts[:i], h.mounts[i+1:]...)
+			return
+		}
+	}
+}
+
+// ServeHTTP writes the current statistics of all exported filesystems
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	mounts := append([]*FuseFS(nil), h.mounts...)
+	h.mu.RUnlock()
+
+	samples := make([]metricsSample, 0, len(mounts))
+	for _, f := range mounts {
+		samples = append(samples, metricsSample{
+			labels: fmt.Sprintf(`mountpoint="%s",fsname="%s"`,
+				escapeLabel(f.options().Mountpoint), escapeLabel(f.options().FSName)),
+			stats: f.Stats(),
+		})
+	}
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, samples)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// metricsSample is the statistics snapshot of one mount with its labels
+type metricsSample struct {
+	labels string
+	stats  Stats
+}
+
+// writeMetrics writes all metric families for the given samples
+func writeMetrics(buf *bytes.Buffer, samples []metricsSample) {
+	counter := func(name, help string, value func(Stats) uint64) {
+		writeHeader(buf, name, "counter", help)
+		for _, s := range samples {
+			fmt.Fprintf(buf, "%s{%s} %d\n", name, s.labels, value(s.stats))
+		}
+	}
+	gauge := func(name, help string, value func(Stats) int) {
+		writeHeader(buf, name, "gauge", help)
+		for _, s := range samples {
+			fmt.Fprintf(buf, "%s{%s} %d\n", name, s.labels, value(s.stats))
+		}
+	}
+
+	counter("fusefs_operations_total", "Total number of FUSE operations.",
+		func(s Stats) uint64 { return s.Operations })
+	counter("fusefs_read_bytes_total", "Total bytes read from the filesystem.",
+		func(s Stats) uint64 { return s.BytesRead })
+	counter("fusefs_written_bytes_total", "Total bytes written to the filesystem.",
+		func(s Stats) uint64 { return s.BytesWritten })
+	counter("fusefs_backend_errors_total", "Total number of errors returned by the absfs backend.",
+		func(s Stats) uint64 { return s.Errors })
+	counter("fusefs_passthrough_opens_total", "Total number of opens handed to the kernel for passthrough.",
+		func(s Stats) uint64 { return s.PassthroughOpens })
+	counter("fusefs_passthrough_fallbacks_total", "Total number of opens using normal I/O with passthrough enabled.",
+		func(s Stats) uint64 { return s.PassthroughFallbacks })
+	gauge("fusefs_open_files", "Number of open file handles.",
+		func(s Stats) int { return s.OpenFiles })
+	gauge("fusefs_inodes", "Number of allocated inode numbers.",
+		func(s Stats) int { return s.InodeStats.TotalInodes })
+
+	// Cache statistics, labelled by cache
+	caches := func(s Stats) map[string]CacheStats {
+		return map[string]CacheStats{
+			"attr": s.InodeStats.AttrCache,
+			"dir":  s.InodeStats.DirCache,
+		}
+	}
+	cacheFamily := func(name, typ, help string, value func(CacheStats) string) {
+		writeHeader(buf, name, typ, help)
+		for _, s := range samples {
+			c := caches(s.stats)
+			for _, cache := range []string{"attr", "dir"} {
+				fmt.Fprintf(buf, "%s{%s,cache=\"%s\"} %s\n", name, s.labels, cache, value(c[cache]))
+			}
+		}
+	}
+	cacheFamily("fusefs_cache_hits_total", "counter", "Total number of cache hits.",
+		func(c CacheStats) string { return strconv.FormatUint(c.Hits, 10) })
+	cacheFamily("fusefs_cache_misses_total", "counter", "Total number of cache misses.",
+		func(c CacheStats) string { return strconv.FormatUint(c.Misses, 10) })
+	cacheFamily("fusefs_cache_evictions_total", "counter", "Total number of cache evictions.",
+		func(c CacheStats) string { return strconv.FormatUint(c.Evictions, 10) })
+	cacheFamily("fusefs_cache_entries", "gauge", "Number of entries in the cache.",
+		func(c CacheStats) string { return strconv.Itoa(c.Size) })
+	cacheFamily("fusefs_cache_max_entries", "gauge", "Maximum number of entries in the cache.",
+		func(c CacheStats) string { return strconv.Itoa(c.MaxSize) })
+
+	// Per-operation statistics, labelled by op
+	opFamily := func(name, help string, value func(OpStats) uint64) {
+		writeHeader(buf, name, "counter", help)
+		for _, s := range samples {
+			for _, op := range sortedOps(s.stats) {
+				fmt.Fprintf(buf, "%s{%s,op=\"%s\"} %d\n", name, s.labels, op, value(s.stats.Ops[op]))
+			}
+		}
+	}
+	opFamily("fusefs_op_total", "Total number of operations by type.",
+		func(o OpStats) uint64 { return o.Count })
+	opFamily("fusefs_op_errors_total", "Total number of operations returning an errno, by type.",
+		func(o OpStats) uint64 { return o.Errors })
+	opFamily("fusefs_op_bytes_total", "Total bytes transferred, by operation type.",
+		func(o OpStats) uint64 { return o.Bytes })
+
+	histFamily := func(name, help string, value func(OpStats) Histogram) {
+		writeHeader(buf, name, "histogram", help)
+		for _, s := range samples {
+			for _, op := range sortedOps(s.stats) {
+				labels := fmt.Sprintf(`%s,op="%s"`, s.labels, op)
+				writeHistogram(buf, name, labels, value(s.stats.Ops[op]))
+			}
+		}
+	}
+	histFamily("fusefs_op_duration_seconds", "Time spent serving FUSE operations.",
+		func(o OpStats) Histogram { return o.Latency })
+	histFamily("fusefs_op_backend_duration_seconds", "Time spent in absfs backend calls per operation.",
+		func(o OpStats) Histogram { return o.BackendLatency })
+
+	// Errno results, labelled by errno name
+	writeHeader(buf, "fusefs_errno_total", "counter", "Total number of errno results returned to the kernel.")
+	for _, s := range samples {
+		errnos := make([]syscall.Errno, 0, len(s.stats.Errnos))
+		for errno := range s.stats.Errnos {
+			errnos = append(errnos, errno)
+		}
+		sort.Slice(errnos, func(i, j int) bool { return errnos[i] < errnos[j] })
+
+		for _, errno := range errnos {
+			fmt.Fprintf(buf, "fusefs_errno_total{%s,errno=\"%s\"} %d\n", s.labels, errnoName(errno), s.stats.Errnos[errno])
+		}
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric family
+func writeHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, typ)
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of h
+func writeHistogram(buf *bytes.Buffer, name, labels string, h Histogram) {
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		if i < len(h.Counts) {
+			cumulative += h.Counts[i]
+		}
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatSeconds(bound), cumulative)
+	}
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count)
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, formatSeconds(h.Sum))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.Count)
+}
+
+// sortedOps returns the operation names present in s in sorted order
+func sortedOps(s Stats) []string {
+	ops := make([]string, 0, len(s.Ops))
+	for op := range s.Ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// formatSeconds formats a duration as a floating point number of seconds
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// errnoName returns the symbolic name of an errno, e.g. "ENOENT"
+func errnoName(errno syscall.Errno) string {
+	if name := unix.ErrnoName(errno); name != "" {
+		return name
+	}
+	return "errno_" + strconv.Itoa(int(errno))
+}
+
+// labelEscaper escapes label values for the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value for the text exposition format
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
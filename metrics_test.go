@@ -0,0 +1,120 @@
+package fusefs
+
+import (
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler_Output(t *testing.T) {
+	opts := DefaultMountOptions("/mnt/data")
+	opts.FSName = "datafs"
+	f := newFuseFS(nil, opts)
+
+	f.stats.recordOperation()
+	f.stats.recordRead(4096)
+	f.stats.recordOp(OpRead, 3*time.Millisecond, 2*time.Millisecond, 4096, 0)
+	f.stats.recordOp(OpLookup, 20*time.Microsecond, 0, 0, syscall.ENOENT)
+
+	rec := httptest.NewRecorder()
+	NewMetricsHandler(f).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	body := rec.Body.String()
+	labels := `mountpoint="/mnt/data",fsname="datafs"`
+	want := []string{
+		"# TYPE fusefs_operations_total counter",
+		"fusefs_operations_total{" + labels + "} 1",
+		"fusefs_read_bytes_total{" + labels + "} 4096",
+		"fusefs_open_files{" + labels + "} 0",
+		"fusefs_cache_hits_total{" + labels + `,cache="attr"} 0`,
+		"fusefs_cache_max_entries{" + labels + `,cache="dir"} 1000`,
+		"fusefs_op_total{" + labels + `,op="Read"} 1`,
+		"fusefs_op_errors_total{" + labels + `,op="Lookup"} 1`,
+		"fusefs_op_bytes_total{" + labels + `,op="Read"} 4096`,
+		"# TYPE fusefs_op_duration_seconds histogram",
+		"fusefs_op_duration_seconds_bucket{" + labels + `,op="Read",le="0.0025"} 0`,
+		"fusefs_op_duration_seconds_bucket{" + labels + `,op="Read",le="0.005"} 1`,
+		"fusefs_op_duration_seconds_bucket{" + labels + `,op="Read",le="+Inf"} 1`,
+		"fusefs_op_duration_seconds_sum{" + labels + `,op="Read"} 0.003`,
+		"fusefs_op_duration_seconds_count{" + labels + `,op="Read"} 1`,
+		"fusefs_op_backend_duration_seconds_count{" + labels + `,op="Read"} 1`,
+		"fusefs_op_backend_duration_seconds_count{" + labels + `,op="Lookup"} 0`,
+		"fusefs_errno_total{" + labels + `,errno="ENOENT"} 1`,
+	}
+	for _, line := range want {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("output missing %q", line)
+		}
+	}
+}
+
+func TestMetricsHandler_MultipleMounts(t *testing.T) {
+	a := newFuseFS(nil, DefaultMountOptions("/mnt/a"))
+	bOpts := DefaultMountOptions("/mnt/b")
+	bOpts.FSName = "other"
+	b := newFuseFS(nil, bOpts)
+
+	a.stats.recordOperation()
+	b.stats.recordOperation()
+	b.stats.recordOperation()
+
+	h := NewMetricsHandler(a)
+	h.Add(b)
+	h.Add(b) // duplicates are ignored
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	if !strings.Contains(body, `fusefs_operations_total{mountpoint="/mnt/a",fsname="fusefs"} 1`) {
+		t.Error("missing series for /mnt/a")
+	}
+	if !strings.Contains(body, `fusefs_operations_total{mountpoint="/mnt/b",fsname="other"} 2`) {
+		t.Error("missing series for /mnt/b")
+	}
+	if n := strings.Count(body, "fusefs_operations_total{"); n != 2 {
+		t.Errorf("got %d operations series, want 2", n)
+	}
+	if n := strings.Count(body, "# TYPE fusefs_operations_total"); n != 1 {
+		t.Errorf("got %d TYPE lines, want 1", n)
+	}
+
+	h.Remove(a)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(rec.Body.String(), `mountpoint="/mnt/a"`) {
+		t.Error("removed mount still exported")
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/mnt/plain", "/mnt/plain"},
+		{`/mnt/"quoted"`, `/mnt/\"quoted\"`},
+		{`C:\mnt`, `C:\\mnt`},
+		{"line\nbreak", `line\nbreak`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeLabel(tt.in); got != tt.want {
+			t.Errorf("escapeLabel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestErrnoName(t *testing.T) {
+	if got := errnoName(syscall.ENOENT); got != "ENOENT" {
+		t.Errorf("errnoName(ENOENT) = %q, want ENOENT", got)
+	}
+	if got := errnoName(syscall.Errno(4000)); got != "errno_4000" {
+		t.Errorf("errnoName(4000) = %q, want errno_4000", got)
+	}
+}
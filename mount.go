@@ -1,10 +1,9 @@
 package fusefs
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"path/filepath"
-	"syscall"
 
 	"github.com/absfs/absfs"
 	"github.com/hanwen/go-fuse/v2/fs"
@@ -15,10 +14,15 @@ import (
 // FuseFS instance that can be used to unmount and query statistics.
 //
 // The function will:
-//  1. Create the mountpoint directory if it doesn't exist
-//  2. Verify the mountpoint is empty
-//  3. Initialize the FUSE adapter with inode and handle tracking
-//  4. Mount the filesystem using go-fuse v2 library
+//  1. Recover a stale FUSE mount at the mountpoint if RecoverStale is set
+//  2. Create the mountpoint directory if it doesn't exist
+//  3. Verify the mountpoint is empty
+//  4. Initialize the FUSE adapter with inode and handle tracking
+//  5. Mount the filesystem using go-fuse v2 library
+//
+// Steps 1 to 3 are skipped when the filesystem is served from a /dev/fuse
+// file descriptor mounted by a privileged process, see
+// MountOptions.FuseFD and MountOptions.FuseFDSocket.
 //
 // The returned FuseFS instance should be unmounted when done using Unmount()
 // or the filesystem can be left mounted and controlled externally.
@@ -35,33 +39,86 @@ import (
 //
 // Errors:
 //   - Returns error if mountpoint is not empty
-//   - Returns error if mount options are invalid
+//   - Returns error if Root is not a directory of the filesystem
+//   - Returns error if a stale FUSE mount occupies the mountpoint and
+//     RecoverStale is not set
+//   - Returns error if mount options are invalid, see MountOptions.Validate
+//   - Returns error if the FuseFDSocket helper fails to mount
+//   - Returns error if the ControlSocket cannot be created
 //   - Returns error if FUSE mount fails (e.g., FUSE not available, permissions)
 func Mount(absFS absfs.FileSystem, opts *MountOptions) (*FuseFS, error) {
+	if err := prepareMountpoint(opts); err != nil {
+		return nil, err
+	}
+
+	// Create FUSE filesystem
+	fuseFS := newFuseFS(absFS, opts)
+
+	// The root must be an existing directory of the backend
+	rootPath := fuseFS.root.backend.rootPath
+	if info, err := absFS.Stat(rootPath); err != nil {
+		return nil, fmt.Errorf("failed to stat root %s: %w", rootPath, err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("root %s is not a directory", rootPath)
+	}
+
+	if err := fuseFS.mount(); err != nil {
+		return nil, err
+	}
+	return fuseFS, nil
+}
+
+// prepareMountpoint validates the options and the mountpoint, recovering a
+// stale mount and creating the directory as needed. The mountpoint is left
+// alone when the filesystem is served from a pre-opened /dev/fuse
+// descriptor.
+func prepareMountpoint(opts *MountOptions) error {
 	if opts == nil {
-		return nil, fmt.Errorf("mount options cannot be nil")
+		return fmt.Errorf("mount options cannot be nil")
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	// A pre-opened /dev/fuse descriptor is mounted already
+	if usesFuseFD(opts) {
+		return nil
 	}
 
 	if opts.Mountpoint == "" {
-		return nil, fmt.Errorf("mountpoint cannot be empty")
+		return fmt.Errorf("mountpoint cannot be empty")
+	}
+
+	// Recover a mountpoint left behind by a crashed server
+	if err := recoverStale(opts); err != nil {
+		return err
 	}
 
 	// Create mountpoint if it doesn't exist
 	if err := os.MkdirAll(opts.Mountpoint, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create mountpoint: %w", err)
+		return fmt.Errorf("failed to create mountpoint: %w", err)
 	}
 
 	// Check if mountpoint is empty
 	entries, err := os.ReadDir(opts.Mountpoint)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read mountpoint: %w", err)
+		return fmt.Errorf("failed to read mountpoint: %w", err)
 	}
 	if len(entries) > 0 {
-		return nil, fmt.Errorf("mountpoint is not empty")
+		return fmt.Errorf("mountpoint is not empty")
 	}
 
-	// Create FUSE filesystem
-	fuseFS := newFuseFS(absFS, opts)
+	return nil
+}
+
+// mount mounts the filesystem at the mountpoint with go-fuse
+func (f *FuseFS) mount() error {
+	opts := f.options()
+
+	// Finish the renames interrupted at the last mount before serving
+	if err := f.recoverDirRenames(); err != nil {
+		return err
+	}
 
 	// Build FUSE mount options
 	fuseOpts := &fs.Options{
@@ -76,8 +133,9 @@ func Mount(absFS absfs.FileSystem, opts *MountOptions) (*FuseFS, error) {
 			MaxReadAhead:  int(opts.MaxReadahead),
 			MaxWrite:      int(opts.MaxWrite),
 		},
-		AttrTimeout:  &opts.AttrTimeout,
-		EntryTimeout: &opts.EntryTimeout,
+		// AttrTimeout and EntryTimeout are left unset: the nodes set the
+		// timeouts of every reply from the current options, and go-fuse
+		// would replace a timeout reconfigured to zero with its default
 	}
 
 	// Add read-only option if specified
@@ -101,46 +159,77 @@ func Mount(absFS absfs.FileSystem, opts *MountOptions) (*FuseFS, error) {
 	}
 
 	// Mount the filesystem
-	server, err := fs.Mount(opts.Mountpoint, fuseFS.root, fuseOpts)
+	target, err := f.mountTarget()
+	if err != nil {
+		return err
+	}
+	server, err := fs.Mount(target, f.rootEmbedder(), fuseOpts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to mount filesystem: %w", err)
+		if f.fdHelper != nil {
+			f.fdHelper.Close()
+		}
+		return fmt.Errorf("failed to mount filesystem: %w", err)
 	}
 
-	fuseFS.server = server
+	f.server = server
+	f.checkPassthrough()
+	f.kernelUnmount = server.Unmount
+	if usesFuseFD(opts) {
+		f.kernelUnmount = f.unmountFuseFD
+	}
 
-	return fuseFS, nil
+	if err := f.startControlSocket(); err != nil {
+		f.Unmount()
+		return err
+	}
+
+	return nil
+}
+
+// recoverStale checks the mountpoint for a stale FUSE mount, which fails
+// every access with ENOTCONN, and lazily unmounts it if
+// MountOptions.RecoverStale is set
+func recoverStale(opts *MountOptions) error {
+	info, err := FindMount(opts.Mountpoint)
+	if err != nil || info == nil || !info.Stale {
+		// Errors are reported by the mountpoint checks in Mount
+		return nil
+	}
+
+	if !opts.RecoverStale {
+		return fmt.Errorf("stale FUSE mount at %s (transport endpoint is not connected); set RecoverStale to unmount it", info.Mountpoint)
+	}
+	if err := unmountPath(info.Mountpoint, true); err != nil {
+		return fmt.Errorf("failed to unmount stale mount at %s: %w", info.Mountpoint, err)
+	}
+	return nil
 }
 
 // Unmount gracefully unmounts the filesystem and cleans up resources.
 //
 // This method:
-//  1. Signals all pending operations to complete
-//  2. Closes all open file handles
-//  3. Clears all caches (inode, attribute, directory)
-//  4. Unmounts the FUSE filesystem
+//  1. Stops accepting new operations
+//  2. Waits for in-flight operations and flushes handles with unsynced writes
+//  3. Closes all open file handles
+//  4. Clears all caches (inode, attribute, directory)
+//  5. Unmounts the FUSE filesystem
+//
+// Step 2 is bounded by a 10 second timeout; use UnmountContext to choose
+// the deadline and to learn which handles were force-closed.
 //
-// It is safe to call Unmount multiple times; subsequent calls will be no-ops.
+// It is safe to call Unmount multiple times; subsequent calls retry a
+// failed unmount of the FUSE filesystem and are otherwise no-ops.
 //
 // Example:
 //
 //	fuseFS, _ := fusefs.Mount(fs, opts)
 //	defer fuseFS.Unmount()
 func (f *FuseFS) Unmount() error {
-	// Signal all operations to complete
-	f.unmounting.Store(true)
-
-	// Close all open file handles
-	f.handleTracker.CloseAll()
-
-	// Clear caches
-	f.inodeManager.Clear()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultUnmountTimeout)
+	defer cancel()
 
-	// Unmount FUSE filesystem
-	if f.server != nil {
-		return f.server.Unmount()
-	}
-
-	return nil
+	_, err := f.UnmountContext(ctx)
+	return err
 }
 
 // Wait blocks until the filesystem is unmounted externally (e.g., via fusermount -u)
@@ -158,6 +247,11 @@ func (f *FuseFS) Wait() error {
 	}
 
 	f.server.Wait()
+	if f.controlSocket != nil {
+		// Answer an unmount request before the caller moves on, e.g.
+		// exits the process
+		f.controlSocket.shutdown()
+	}
 	return nil
 }
 
@@ -180,25 +274,19 @@ func MountAndWait(absFS absfs.FileSystem, opts *MountOptions) error {
 	return fuseFS.Wait()
 }
 
-// IsMounted checks if a directory is a FUSE mountpoint
+// IsMounted checks if a directory is a mountpoint, including a stale FUSE
+// mount whose server has exited. Use FindMount for the filesystem type
+// and source. It fails if path does not exist.
 func IsMounted(path string) (bool, error) {
-	absPath, err := filepath.Abs(path)
+	info, err := FindMount(path)
 	if err != nil {
 		return false, err
 	}
-
-	var stat syscall.Stat_t
-	if err := syscall.Stat(absPath, &stat); err != nil {
-		return false, err
+	if info == nil {
+		// Not a mountpoint, but report a missing path as before
+		if _, err := os.Stat(path); err != nil {
+			return false, err
+		}
 	}
-
-	// Get parent directory stats
-	parent := filepath.Dir(absPath)
-	var parentStat syscall.Stat_t
-	if err := syscall.Stat(parent, &parentStat); err != nil {
-		return false, err
-	}
-
-	// If device IDs differ, it's a mount point
-	return stat.Dev != parentStat.Dev, nil
+	return info != nil, nil
 }
@@ -0,0 +1,219 @@
+package fusefs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MountInfo describes a mounted filesystem as listed in /proc/self/mountinfo
+type MountInfo struct {
+	// Mountpoint is the absolute path of the mount
+	Mountpoint string
+
+	// FSType is the filesystem type, e.g. "ext4" or "fuse.sshfs"
+	FSType string
+
+	// Subtype is the part of FSType after "fuse." for FUSE mounts, which
+	// go-fuse sets from the mount name
+	Subtype string
+
+	// Source is the mount source; for FUSE mounts this is the FSName
+	Source string
+
+	// Options are the per-mount options, e.g. "rw" and "nosuid"
+	Options []string
+
+	// SuperOptions are the filesystem-wide options, e.g. "user_id=1000"
+	SuperOptions []string
+
+	// Fusefs reports whether the mount has the form of those created by
+	// this package, which mounts with its FSName as both the source and
+	// the subtype. Match Source against the FSName to find a given mount.
+	Fusefs bool
+
+	// Stale reports whether the FUSE server behind the mount has gone
+	// away, leaving a mountpoint that fails with ENOTCONN. A mount whose
+	// server does not answer within a second is not reported as stale.
+	Stale bool
+}
+
+// IsFUSE reports whether the mount is a FUSE filesystem
+func (m *MountInfo) IsFUSE() bool {
+	return m.FSType == "fuse" || m.FSType == "fuseblk" || strings.HasPrefix(m.FSType, "fuse.")
+}
+
+// parseMountinfo parses the format of /proc/self/mountinfo:
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// Fields are the mount ID, parent ID, device, root, mountpoint, mount
+// options, optional fields terminated by "-", then the filesystem type,
+// source and super options.
+func parseMountinfo(r io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 6 || sep < 0 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("malformed mountinfo line: %q", line)
+		}
+
+		info := MountInfo{
+			Mountpoint: unescapeMountinfo(fields[4]),
+			FSType:     unescapeMountinfo(fields[sep+1]),
+			Source:     unescapeMountinfo(fields[sep+2]),
+			Options:    strings.Split(fields[5], ","),
+		}
+		if subtype, ok := strings.CutPrefix(info.FSType, "fuse."); ok {
+			info.Subtype = subtype
+			info.Fusefs = subtype == info.Source
+		}
+		if len(fields) > sep+3 {
+			info.SuperOptions = strings.Split(fields[sep+3], ",")
+		}
+
+		mounts = append(mounts, info)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// unescapeMountinfo decodes the octal escapes (e.g. "\040" for a space)
+// that the kernel uses for whitespace and backslashes in mountinfo fields
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ListMounts returns the FUSE mounts visible to the process, in mount
+// order, including stale mounts whose server has exited and mounts created
+// by other processes. Use MountInfo.Fusefs to select mounts in the form
+// created by this package and MountInfo.Source to match them to their
+// FSName.
+//
+// The mounts are checked for staleness concurrently, each for at most a
+// second, since accessing the mountpoint of a hung server blocks.
+//
+// ListMounts requires /proc/self/mountinfo and fails with
+// errors.ErrUnsupported on other platforms.
+func ListMounts() ([]MountInfo, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	fuseMounts := make([]MountInfo, 0, len(mounts))
+	for _, m := range mounts {
+		if m.IsFUSE() {
+			fuseMounts = append(fuseMounts, m)
+		}
+	}
+	markStale(fuseMounts, os.Stat)
+	return fuseMounts, nil
+}
+
+// staleTimeout bounds the check of a mount for staleness
+const staleTimeout = time.Second
+
+// markStale sets Stale for the FUSE mounts of mounts, checking them
+// concurrently with stat
+func markStale(mounts []MountInfo, stat func(string) (os.FileInfo, error)) {
+	var wg sync.WaitGroup
+	for i := range mounts {
+		if !mounts[i].IsFUSE() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mounts[i].Stale = isStale(mounts[i].Mountpoint, stat)
+		}()
+	}
+	wg.Wait()
+}
+
+// isStale reports whether mountpoint is a FUSE mount whose server has gone
+// away. The stat of a mount whose server hangs is abandoned after
+// staleTimeout and the mount reported as not stale.
+func isStale(mountpoint string, stat func(string) (os.FileInfo, error)) bool {
+	done := make(chan error, 1)
+	go func() {
+		_, err := stat(mountpoint)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return errors.Is(err, syscall.ENOTCONN)
+	case <-time.After(staleTimeout):
+		return false
+	}
+}
+
+// UnmountPath unmounts the filesystem mounted at mountpoint, which may
+// belong to another process, e.g. a mount created by the fusefs command or
+// a stale mount. Unmounting fails with EBUSY while files are open, unless
+// lazy is set: then the mount is detached at once and released when it is
+// no longer in use. On Linux, unprivileged users unmount through
+// fusermount.
+//
+// Prefer FuseFS.Unmount for mounts served by the current process, which
+// flushes open files first.
+func UnmountPath(mountpoint string, lazy bool) error {
+	err := unmountPath(mountpoint, lazy)
+	if errno, ok := err.(syscall.Errno); ok {
+		return &os.PathError{Op: "unmount", Path: mountpoint, Err: errno}
+	}
+	return err
+}
+
+// FindMount returns the mount whose mountpoint is path, or nil if path is
+// not a mountpoint. If several mounts are stacked on path, the topmost is
+// returned. A FUSE mount is checked for staleness, for at most a second
+// if its server hangs.
+func FindMount(path string) (*MountInfo, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return findMount(absPath)
+}
@@ -0,0 +1,73 @@
+package fusefs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountinfoPath is the mount table of the current mount namespace
+const mountinfoPath = "/proc/self/mountinfo"
+
+// readMounts returns all mounts visible to the process. Stale is not set,
+// see markStale.
+func readMounts() ([]MountInfo, error) {
+	f, err := os.Open(mountinfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMountinfo(f)
+}
+
+// findMount returns the topmost mount at absPath, or nil. Only that mount
+// is checked for staleness.
+func findMount(absPath string) (*MountInfo, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *MountInfo
+	for i := range mounts {
+		if mounts[i].Mountpoint == absPath {
+			found = &mounts[i]
+		}
+	}
+	if found != nil && found.IsFUSE() {
+		found.Stale = isStale(absPath, os.Stat)
+	}
+	return found, nil
+}
+
+// unmountPath unmounts the mount at mountpoint; a lazy unmount detaches
+// it even if it is busy or its server is gone. Unprivileged users fall back
+// to fusermount.
+func unmountPath(mountpoint string, lazy bool) error {
+	flags, args := 0, []string{"-u", mountpoint}
+	if lazy {
+		flags, args = unix.MNT_DETACH, []string{"-u", "-z", mountpoint}
+	}
+
+	err := unix.Unmount(mountpoint, flags)
+	if err == nil || !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+
+	for _, bin := range []string{"fusermount3", "fusermount"} {
+		path, lookErr := exec.LookPath(bin)
+		if lookErr != nil {
+			continue
+		}
+		if out, runErr := exec.Command(path, args...).CombinedOutput(); runErr != nil {
+			return &os.PathError{Op: bin, Path: mountpoint, Err: errors.New(strings.TrimSpace(string(out)))}
+		}
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+//go:build !linux
+
+package fusefs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// readMounts is not supported without /proc/self/mountinfo
+func readMounts() ([]MountInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// findMount detects a mount at absPath by comparing its device with that
+// of its parent. The filesystem type and source are not available.
+func findMount(absPath string) (*MountInfo, error) {
+	if isStale(absPath, os.Stat) {
+		return &MountInfo{Mountpoint: absPath, Stale: true}, nil
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(absPath, &stat); err != nil {
+		return nil, err
+	}
+
+	var parentStat syscall.Stat_t
+	if err := syscall.Stat(filepath.Dir(absPath), &parentStat); err != nil {
+		return nil, err
+	}
+
+	if stat.Dev == parentStat.Dev {
+		return nil, nil
+	}
+	return &MountInfo{Mountpoint: absPath}, nil
+}
+
+// unmountPath unmounts the mount at mountpoint; a lazy unmount is forced
+// even if the mount is busy or its server is gone
+func unmountPath(mountpoint string, lazy bool) error {
+	flags := 0
+	if lazy {
+		flags = unix.MNT_FORCE
+	}
+	return unix.Unmount(mountpoint, flags)
+}
@@ -0,0 +1,143 @@
+package fusefs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const testMountinfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+45 22 0:40 / /mnt/data rw,nosuid,nodev,relatime shared:30 - fuse.datafs datafs rw,user_id=1000,group_id=1000
+46 22 0:41 / /mnt/with\040space rw,nosuid,nodev - fuse sshfs#host: rw,user_id=0,group_id=0
+47 22 0:42 / /tmp rw shared:2 master:1 - tmpfs tmpfs rw
+`
+
+func TestParseMountinfo(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatalf("parseMountinfo: %v", err)
+	}
+	if len(mounts) != 4 {
+		t.Fatalf("got %d mounts, want 4", len(mounts))
+	}
+
+	want := MountInfo{
+		Mountpoint:   "/mnt/data",
+		FSType:       "fuse.datafs",
+		Subtype:      "datafs",
+		Source:       "datafs",
+		Options:      []string{"rw", "nosuid", "nodev", "relatime"},
+		SuperOptions: []string{"rw", "user_id=1000", "group_id=1000"},
+		Fusefs:       true,
+	}
+	if !reflect.DeepEqual(mounts[1], want) {
+		t.Errorf("mounts[1] = %+v, want %+v", mounts[1], want)
+	}
+
+	if mounts[2].Mountpoint != "/mnt/with space" || mounts[2].Subtype != "" || !mounts[2].IsFUSE() || mounts[2].Fusefs {
+		t.Errorf("mounts[2] = %+v", mounts[2])
+	}
+	if mounts[0].IsFUSE() || mounts[3].IsFUSE() {
+		t.Error("non-FUSE mount reported as FUSE")
+	}
+	if mounts[3].FSType != "tmpfs" || mounts[3].Mountpoint != "/tmp" {
+		t.Errorf("mounts[3] = %+v, optional fields not skipped", mounts[3])
+	}
+}
+
+func TestParseMountinfo_Malformed(t *testing.T) {
+	for _, line := range []string{
+		"22 1 8:1 / / rw,relatime shared:1 ext4 /dev/sda1 rw",
+		"22 1 8:1 / /",
+		"22 1 8:1 / / rw - ext4",
+	} {
+		if _, err := parseMountinfo(strings.NewReader(line)); err == nil {
+			t.Errorf("parseMountinfo(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestUnescapeMountinfo(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/mnt/plain", "/mnt/plain"},
+		{`/mnt/a\040b`, "/mnt/a b"},
+		{`/mnt/tab\011x`, "/mnt/tab\tx"},
+		{`/mnt/back\134slash`, `/mnt/back\slash`},
+		{`/mnt/trailing\04`, `/mnt/trailing\04`},
+	}
+
+	for _, tt := range tests {
+		if got := unescapeMountinfo(tt.in); got != tt.want {
+			t.Errorf("unescapeMountinfo(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsMounted(t *testing.T) {
+	mounted, err := IsMounted(t.TempDir())
+	if err != nil {
+		t.Fatalf("IsMounted: %v", err)
+	}
+	if mounted {
+		t.Error("temporary directory reported as mounted")
+	}
+
+	if _, err := IsMounted(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("IsMounted of a missing path error = %v, want not exist", err)
+	}
+}
+
+func TestListMounts(t *testing.T) {
+	mounts, err := ListMounts()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("mountinfo not available")
+	}
+	if err != nil {
+		t.Fatalf("ListMounts: %v", err)
+	}
+	for _, m := range mounts {
+		if !m.IsFUSE() {
+			t.Errorf("non-FUSE mount listed: %+v", m)
+		}
+	}
+}
+
+func TestMarkStale(t *testing.T) {
+	mounts := []MountInfo{
+		{Mountpoint: "/mnt/live", FSType: "fuse.data"},
+		{Mountpoint: "/mnt/stale", FSType: "fuse.data"},
+		{Mountpoint: "/mnt/hung", FSType: "fuse.data"},
+		{Mountpoint: "/mnt/disk", FSType: "ext4"},
+	}
+	hung := make(chan struct{})
+	defer close(hung)
+	stat := func(name string) (os.FileInfo, error) {
+		switch name {
+		case "/mnt/stale":
+			return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.ENOTCONN}
+		case "/mnt/hung":
+			<-hung
+		case "/mnt/disk":
+			t.Errorf("non-FUSE mount %s checked", name)
+		}
+		return nil, nil
+	}
+
+	start := time.Now()
+	markStale(mounts, stat)
+	if elapsed := time.Since(start); elapsed > 2*staleTimeout {
+		t.Errorf("markStale took %v with a hung mount", elapsed)
+	}
+	for _, m := range mounts {
+		if want := m.Mountpoint == "/mnt/stale"; m.Stale != want {
+			t.Errorf("%s Stale = %v, want %v", m.Mountpoint, m.Stale, want)
+		}
+	}
+}
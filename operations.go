@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"syscall"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -13,35 +14,56 @@ import (
 
 // Lookup looks up a child node by name
 func (n *fuseNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return nil, syscall.ENOTCONN
-	}
+	var child *fs.Inode
+	op := newOp(ctx, OpLookup, n.childPath(name))
+	errno := n.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		// The control directory is served by fusefs, not the backend
+		if n.isControlName(name) {
+			child, errno = n.lookupControl(ctx, out)
+		} else if n.isJournalName(name) {
+			errno = syscall.ENOENT
+		} else {
+			child, errno = n.lookup(ctx, name, out)
+		}
+		return errno
+	})
+	return child, errno
+}
 
+// lookup implements Lookup
+func (n *fuseNode) lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	// Build full path
-	fullPath := path.Join(n.path, name)
+	fullPath := n.childPath(name)
+
+	// Follow symbolic links ourselves so they stay within the mount root
+	if n.backend.confined() {
+		start := time.Now()
+		resolved, err := n.backend.resolve(n.path(), name)
+		timeBackend(ctx, start)
+		if err != nil {
+			return nil, n.fusefs.backendError(ctx, err)
+		}
+		fullPath = resolved
+	}
 
 	// Stat the file
-	info, err := n.fusefs.absFS.Stat(fullPath)
+	start := time.Now()
+	info, err := n.backend.fs().Stat(fullPath)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
 	// Get or allocate inode
-	ino := n.fusefs.inodeManager.GetInode(fullPath, info)
+	ino := n.backend.inodes.GetInode(fullPath, info)
 
 	// Fill entry attributes
 	n.fillAttr(&out.Attr, info, ino)
-	out.SetEntryTimeout(n.fusefs.opts.EntryTimeout)
-	out.SetAttrTimeout(n.fusefs.opts.AttrTimeout)
+	out.SetEntryTimeout(n.fusefs.options().EntryTimeout)
+	out.SetAttrTimeout(n.fusefs.options().AttrTimeout)
 
 	// Create child node
-	child := &fuseNode{
-		fusefs: n.fusefs,
-		path:   fullPath,
-	}
+	child := newFuseNode(n.fusefs, n.backend, fullPath)
 
 	// Determine node mode
 	mode := uint32(syscall.S_IFREG)
@@ -60,67 +82,79 @@ func (n *fuseNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut)
 
 // Getattr gets file attributes
 func (n *fuseNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
-	}
+	return n.fusefs.intercept(ctx, newOp(ctx, OpGetattr, n.path()), func(ctx context.Context) syscall.Errno {
+		return n.getattr(ctx, f, out)
+	})
+}
 
+// getattr implements Getattr
+func (n *fuseNode) getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
 	// Check cache first
-	if cached := n.fusefs.inodeManager.GetCached(n.path); cached != nil {
+	if cached := n.backend.inodes.GetCached(n.path()); cached != nil {
 		out.Attr = *cached
-		out.SetTimeout(n.fusefs.opts.AttrTimeout)
+		out.SetTimeout(n.fusefs.options().AttrTimeout)
 		return 0
 	}
 
-	// Stat the file
-	info, err := n.fusefs.absFS.Stat(n.path)
+	// Stat the file, or the link itself for symbolic links
+	stat := n.backend.fs().Stat
+	if n.StableAttr().Mode == syscall.S_IFLNK {
+		if lstatFS, ok := n.backend.fs().(interface {
+			Lstat(name string) (os.FileInfo, error)
+		}); ok {
+			stat = lstatFS.Lstat
+		}
+	}
+	start := time.Now()
+	info, err := stat(n.path())
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return mapError(err)
+		return n.fusefs.backendError(ctx, err)
 	}
 
 	// Get or allocate inode
-	ino := n.fusefs.inodeManager.GetInode(n.path, info)
+	ino := n.backend.inodes.GetInode(n.path(), info)
 
 	// Fill attributes
 	n.fillAttr(&out.Attr, info, ino)
-	out.SetTimeout(n.fusefs.opts.AttrTimeout)
+	out.SetTimeout(n.fusefs.options().AttrTimeout)
 
-	// Cache for future lookups
-	n.fusefs.inodeManager.Cache(n.path, &out.Attr)
+	// Cache a copy for future lookups: go-fuse reuses out for other
+	// requests
+	attr := out.Attr
+	n.backend.inodes.Cache(n.path(), &attr)
 
 	return 0
 }
 
 // Open opens a file
 func (n *fuseNode) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return nil, 0, syscall.ENOTCONN
-	}
+	errno = n.fusefs.intercept(ctx, newOp(ctx, OpOpen, n.path()), func(ctx context.Context) (errno syscall.Errno) {
+		fh, fuseFlags, errno = n.open(ctx, flags)
+		return errno
+	})
+	return fh, fuseFlags, errno
+}
 
+// open implements Open
+func (n *fuseNode) open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	// Map FUSE flags to absfs flags
 	absFlags := n.mapOpenFlags(flags)
 
 	// Open file through absfs
-	file, err := n.fusefs.absFS.OpenFile(n.path, absFlags, 0)
+	start := time.Now()
+	file, err := n.backend.fs().OpenFile(n.path(), absFlags, 0)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, 0, mapError(err)
+		return nil, 0, n.fusefs.backendError(ctx, err)
 	}
 
 	// Allocate file handle
-	handle := n.fusefs.handleTracker.Add(file, absFlags, n.path)
+	handle := n.fusefs.handleTracker.Add(file, absFlags, n.path())
 
 	// Create file handle
-	fileHandle := &fuseFileHandle{
-		node:   n,
-		handle: handle,
-	}
-
-	return fileHandle, 0, 0
+	fh, fuseFlags = n.openHandle(file, handle, n.openFlags(ctx, file))
+	return fh, fuseFlags, 0
 }
 
 // fuseFileHandle represents an open file handle
@@ -129,10 +163,31 @@ type fuseFileHandle struct {
 	handle uint64
 }
 
+// newOp creates an operation descriptor for this file handle
+func (fh *fuseFileHandle) newOp(ctx context.Context, name string) *Op {
+	op := newOp(ctx, name, fh.node.path())
+	op.Handle = fh.handle
+	return op
+}
+
 // Read reads data from the file
 func (fh *fuseFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
-	fh.node.fusefs.stats.recordOperation()
+	var result fuse.ReadResult
+	op := fh.newOp(ctx, OpRead)
+	op.Offset = off
+	op.Size = int64(len(dest))
+	errno := fh.node.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		result, errno = fh.read(ctx, dest, off)
+		if result != nil {
+			op.bytes = int64(result.Size())
+		}
+		return errno
+	})
+	return result, errno
+}
 
+// read implements Read
+func (fh *fuseFileHandle) read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
 	file := fh.node.fusefs.handleTracker.Get(fh.handle)
 	if file == nil {
 		fh.node.fusefs.stats.recordError()
@@ -141,18 +196,20 @@ func (fh *fuseFileHandle) Read(ctx context.Context, dest []byte, off int64) (fus
 
 	// Seek to offset if file supports seeking
 	if seeker, ok := file.(io.Seeker); ok {
+		start := time.Now()
 		_, err := seeker.Seek(off, io.SeekStart)
+		timeBackend(ctx, start)
 		if err != nil {
-			fh.node.fusefs.stats.recordError()
-			return nil, mapError(err)
+			return nil, fh.node.fusefs.backendError(ctx, err)
 		}
 	}
 
 	// Read data
+	start := time.Now()
 	n, err := file.Read(dest)
+	timeBackend(ctx, start)
 	if err != nil && err != io.EOF {
-		fh.node.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, fh.node.fusefs.backendError(ctx, err)
 	}
 
 	fh.node.fusefs.stats.recordRead(n)
@@ -161,38 +218,72 @@ func (fh *fuseFileHandle) Read(ctx context.Context, dest []byte, off int64) (fus
 
 // Write writes data to the file
 func (fh *fuseFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
-	fh.node.fusefs.stats.recordOperation()
+	op := fh.newOp(ctx, OpWrite)
+	op.Offset = off
+	op.Size = int64(len(data))
+	errno = fh.node.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		written, errno = fh.write(ctx, data, off)
+		op.bytes = int64(written)
+		return errno
+	})
+	return written, errno
+}
 
-	file := fh.node.fusefs.handleTracker.Get(fh.handle)
-	if file == nil {
+// write implements Write
+func (fh *fuseFileHandle) write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
+	entry := fh.node.fusefs.handleTracker.GetEntry(fh.handle)
+	if entry == nil {
 		fh.node.fusefs.stats.recordError()
 		return 0, syscall.EBADF
 	}
+	file := entry.file
+
+	// Handles opened with O_APPEND write at the end of the file, whatever
+	// offset the kernel passes, and no other write of the mount may move
+	// the end meanwhile
+	appending := entry.flags&os.O_APPEND != 0
+	unlock := fh.node.backend.writeLocks.lock(fh.node.StableAttr().Ino, appending)
+	defer unlock()
 
 	// Seek to offset if file supports seeking
+	pos := off
 	if seeker, ok := file.(io.Seeker); ok {
-		_, err := seeker.Seek(off, io.SeekStart)
+		start := time.Now()
+		var err error
+		if appending {
+			pos, err = seeker.Seek(0, io.SeekEnd)
+		} else {
+			_, err = seeker.Seek(off, io.SeekStart)
+		}
+		timeBackend(ctx, start)
 		if err != nil {
-			fh.node.fusefs.stats.recordError()
-			return 0, mapError(err)
+			return 0, fh.node.fusefs.backendError(ctx, err)
 		}
 	}
 
 	// Write data
+	start := time.Now()
 	n, err := file.Write(data)
+	timeBackend(ctx, start)
 	if err != nil {
-		fh.node.fusefs.stats.recordError()
-		return 0, mapError(err)
+		return 0, fh.node.fusefs.backendError(ctx, err)
 	}
 
+	fh.node.fusefs.handleTracker.MarkDirty(fh.handle)
 	fh.node.fusefs.stats.recordWrite(n)
+	if pos != off {
+		fh.notifyAppended()
+	}
 	return uint32(n), 0
 }
 
 // Release closes the file handle
 func (fh *fuseFileHandle) Release(ctx context.Context) syscall.Errno {
-	fh.node.fusefs.stats.recordOperation()
+	return fh.node.fusefs.intercept(ctx, fh.newOp(ctx, OpRelease), fh.release)
+}
 
+// release implements Release
+func (fh *fuseFileHandle) release(ctx context.Context) syscall.Errno {
 	// Release any locks held by this file handle
 	// The owner is derived from the handle ID for lock tracking
 	fh.node.fusefs.lockManager.ReleaseOwner(fh.handle)
@@ -202,68 +293,22 @@ func (fh *fuseFileHandle) Release(ctx context.Context) syscall.Errno {
 
 // Flush flushes cached data
 func (fh *fuseFileHandle) Flush(ctx context.Context) syscall.Errno {
-	fh.node.fusefs.stats.recordOperation()
-
-	file := fh.node.fusefs.handleTracker.Get(fh.handle)
-	if file == nil {
-		return syscall.EBADF
-	}
-
-	// If file supports Sync, call it
-	if syncer, ok := file.(interface{ Sync() error }); ok {
-		if err := syncer.Sync(); err != nil {
-			fh.node.fusefs.stats.recordError()
-			return mapError(err)
-		}
-	}
-
-	return 0
+	return fh.node.fusefs.intercept(ctx, fh.newOp(ctx, OpFlush), fh.flush)
 }
 
-// Allocate pre-allocates space for the file (fallocate)
-func (fh *fuseFileHandle) Allocate(ctx context.Context, off uint64, size uint64, mode uint32) syscall.Errno {
-	fh.node.fusefs.stats.recordOperation()
-
+// flush implements Flush
+func (fh *fuseFileHandle) flush(ctx context.Context) syscall.Errno {
 	file := fh.node.fusefs.handleTracker.Get(fh.handle)
 	if file == nil {
-		fh.node.fusefs.stats.recordError()
 		return syscall.EBADF
 	}
 
-	// Check if the underlying file supports allocation
-	// This is typically a Linux-specific feature
-	allocator, ok := file.(interface {
-		Allocate(offset int64, length int64) error
-	})
-	if !ok {
-		// If not supported, try to emulate with Truncate if mode is 0 (default allocation)
-		if mode == 0 {
-			truncater, ok := file.(interface{ Truncate(int64) error })
-			if ok {
-				info, err := file.Stat()
-				if err != nil {
-					fh.node.fusefs.stats.recordError()
-					return mapError(err)
-				}
-
-				// Only extend the file, don't shrink it
-				newSize := int64(off + size)
-				if newSize > info.Size() {
-					if err := truncater.Truncate(newSize); err != nil {
-						fh.node.fusefs.stats.recordError()
-						return mapError(err)
-					}
-				}
-				return 0
-			}
-		}
-		return syscall.ENOTSUP
-	}
-
-	// Call Allocate on the underlying file
-	if err := allocator.Allocate(int64(off), int64(size)); err != nil {
-		fh.node.fusefs.stats.recordError()
-		return mapError(err)
+	// Sync the file if it supports it
+	start := time.Now()
+	err := fh.node.fusefs.handleTracker.Sync(fh.handle)
+	timeBackend(ctx, start)
+	if err != nil {
+		return fh.node.fusefs.backendError(ctx, err)
 	}
 
 	return 0
@@ -271,37 +316,43 @@ func (fh *fuseFileHandle) Allocate(ctx context.Context, off uint64, size uint64,
 
 // Readdir reads directory entries
 func (n *fuseNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return nil, syscall.ENOTCONN
-	}
+	var stream fs.DirStream
+	errno := n.fusefs.intercept(ctx, newOp(ctx, OpReaddir, n.path()), func(ctx context.Context) (errno syscall.Errno) {
+		stream, errno = n.readdir(ctx)
+		return errno
+	})
+	return stream, errno
+}
 
+// readdir implements Readdir
+func (n *fuseNode) readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 	// Check directory cache
-	if entries := n.fusefs.inodeManager.GetDirCache(n.path); entries != nil {
+	if entries := n.backend.inodes.GetDirCache(n.path()); entries != nil {
 		return fs.NewListDirStream(n.convertDirEntries(entries)), 0
 	}
 
 	// Open directory and read entries
-	dir, err := n.fusefs.absFS.Open(n.path)
+	start := time.Now()
+	dir, err := n.backend.fs().Open(n.path())
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 	defer dir.Close()
 
 	// Read all directory entries
+	start = time.Now()
 	infos, err := dir.Readdir(-1)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
 	// Convert to FUSE directory entries
 	fuseEntries := make([]fuse.DirEntry, 0, len(infos))
 	for _, info := range infos {
-		fullPath := path.Join(n.path, info.Name())
-		ino := n.fusefs.inodeManager.GetInode(fullPath, info)
+		fullPath := n.childPath(info.Name())
+		ino := n.backend.inodes.GetInode(fullPath, info)
 
 		mode := uint32(syscall.S_IFREG)
 		if info.IsDir() {
@@ -316,56 +367,81 @@ func (n *fuseNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 	}
 
 	// Cache directory listing
-	n.fusefs.inodeManager.CacheDir(n.path, fuseEntries)
+	n.backend.inodes.CacheDir(n.path(), fuseEntries)
 
 	return fs.NewListDirStream(n.convertDirEntries(fuseEntries)), 0
 }
 
 // Create creates a new file
 func (n *fuseNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
-	n.fusefs.stats.recordOperation()
+	op := newOp(ctx, OpCreate, n.childPath(name))
+	errno = n.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		node, fh, fuseFlags, errno = n.create(ctx, name, flags, mode, out)
+		return errno
+	})
+	return node, fh, fuseFlags, errno
+}
 
-	if n.fusefs.checkUnmounting() {
-		return nil, nil, 0, syscall.ENOTCONN
+// create implements Create
+func (n *fuseNode) create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
+	if n.isControlName(name) {
+		return nil, nil, 0, syscall.EEXIST
+	}
+	if n.isJournalName(name) {
+		return nil, nil, 0, syscall.EPERM
 	}
 
 	// Build full path
-	fullPath := path.Join(n.path, name)
+	fullPath := n.childPath(name)
+
+	// Create through symbolic links ourselves so they stay within the
+	// mount root
+	if n.backend.confined() {
+		start := time.Now()
+		resolved, err := n.backend.resolveCreate(n.path(), name)
+		timeBackend(ctx, start)
+		if err != nil {
+			return nil, nil, 0, n.fusefs.backendError(ctx, err)
+		}
+		fullPath = resolved
+	}
 
 	// Map FUSE flags to absfs flags
 	absFlags := n.mapOpenFlags(flags) | os.O_CREATE
 
 	// Create and open file
-	file, err := n.fusefs.absFS.OpenFile(fullPath, absFlags, os.FileMode(mode))
+	start := time.Now()
+	file, err := n.backend.fs().OpenFile(fullPath, absFlags, os.FileMode(mode))
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, nil, 0, mapError(err)
+		return nil, nil, 0, n.fusefs.backendError(ctx, err)
 	}
 
-	// Invalidate parent directory cache
-	n.fusefs.inodeManager.InvalidateDir(n.path)
+	// Invalidate parent directory cache, and that of a link's target
+	n.backend.inodes.InvalidateDir(n.path())
+	if dir := path.Dir(fullPath); dir != n.path() {
+		n.backend.inodes.InvalidateDir(dir)
+	}
 
 	// Get file info
-	info, err := n.fusefs.absFS.Stat(fullPath)
+	start = time.Now()
+	info, err := n.backend.fs().Stat(fullPath)
+	timeBackend(ctx, start)
 	if err != nil {
 		file.Close()
-		n.fusefs.stats.recordError()
-		return nil, nil, 0, mapError(err)
+		return nil, nil, 0, n.fusefs.backendError(ctx, err)
 	}
 
 	// Allocate inode
-	ino := n.fusefs.inodeManager.GetInode(fullPath, info)
+	ino := n.backend.inodes.GetInode(fullPath, info)
 
 	// Fill entry attributes
 	n.fillAttr(&out.Attr, info, ino)
-	out.SetEntryTimeout(n.fusefs.opts.EntryTimeout)
-	out.SetAttrTimeout(n.fusefs.opts.AttrTimeout)
+	out.SetEntryTimeout(n.fusefs.options().EntryTimeout)
+	out.SetAttrTimeout(n.fusefs.options().AttrTimeout)
 
 	// Create child node
-	child := &fuseNode{
-		fusefs: n.fusefs,
-		path:   fullPath,
-	}
+	child := newFuseNode(n.fusefs, n.backend, fullPath)
 
 	// Create the inode
 	childInode := n.NewInode(ctx, child, fs.StableAttr{
@@ -377,55 +453,62 @@ func (n *fuseNode) Create(ctx context.Context, name string, flags uint32, mode u
 	handle := n.fusefs.handleTracker.Add(file, absFlags, fullPath)
 
 	// Create file handle
-	fileHandle := &fuseFileHandle{
-		node:   child,
-		handle: handle,
-	}
-
-	return childInode, fileHandle, 0, 0
+	fh, fuseFlags = child.openHandle(file, handle, child.openFlags(ctx, file))
+	return childInode, fh, fuseFlags, 0
 }
 
 // Mkdir creates a new directory
 func (n *fuseNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	n.fusefs.stats.recordOperation()
+	var child *fs.Inode
+	op := newOp(ctx, OpMkdir, n.childPath(name))
+	errno := n.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		child, errno = n.mkdir(ctx, name, mode, out)
+		return errno
+	})
+	return child, errno
+}
 
-	if n.fusefs.checkUnmounting() {
-		return nil, syscall.ENOTCONN
+// mkdir implements Mkdir
+func (n *fuseNode) mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if n.isControlName(name) {
+		return nil, syscall.EEXIST
+	}
+	if n.isJournalName(name) {
+		return nil, syscall.EPERM
 	}
 
 	// Build full path
-	fullPath := path.Join(n.path, name)
+	fullPath := n.childPath(name)
 
 	// Create directory
-	err := n.fusefs.absFS.Mkdir(fullPath, os.FileMode(mode))
+	start := time.Now()
+	err := n.backend.fs().Mkdir(fullPath, os.FileMode(mode))
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
 	// Invalidate parent directory cache
-	n.fusefs.inodeManager.InvalidateDir(n.path)
+	n.backend.inodes.InvalidateDir(n.path())
 
 	// Get directory info
-	info, err := n.fusefs.absFS.Stat(fullPath)
+	start = time.Now()
+	info, err := n.backend.fs().Stat(fullPath)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
 	// Allocate inode
-	ino := n.fusefs.inodeManager.GetInode(fullPath, info)
+	ino := n.backend.inodes.GetInode(fullPath, info)
 
 	// Fill entry attributes
 	n.fillAttr(&out.Attr, info, ino)
-	out.SetEntryTimeout(n.fusefs.opts.EntryTimeout)
-	out.SetAttrTimeout(n.fusefs.opts.AttrTimeout)
+	out.SetEntryTimeout(n.fusefs.options().EntryTimeout)
+	out.SetAttrTimeout(n.fusefs.options().AttrTimeout)
 
 	// Create child node
-	child := &fuseNode{
-		fusefs: n.fusefs,
-		path:   fullPath,
-	}
+	child := newFuseNode(n.fusefs, n.backend, fullPath)
 
 	// Create the inode
 	childInode := n.NewInode(ctx, child, fs.StableAttr{
@@ -438,102 +521,89 @@ func (n *fuseNode) Mkdir(ctx context.Context, name string, mode uint32, out *fus
 
 // Unlink removes a file
 func (n *fuseNode) Unlink(ctx context.Context, name string) syscall.Errno {
-	n.fusefs.stats.recordOperation()
+	return n.fusefs.intercept(ctx, newOp(ctx, OpUnlink, n.childPath(name)), func(ctx context.Context) syscall.Errno {
+		return n.unlink(ctx, name)
+	})
+}
 
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
+// unlink implements Unlink
+func (n *fuseNode) unlink(ctx context.Context, name string) syscall.Errno {
+	if n.isControlName(name) || n.isJournalName(name) {
+		return syscall.EPERM
 	}
 
 	// Build full path
-	fullPath := path.Join(n.path, name)
+	fullPath := n.childPath(name)
 
 	// Remove file
-	err := n.fusefs.absFS.Remove(fullPath)
+	start := time.Now()
+	err := n.backend.fs().Remove(fullPath)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return mapError(err)
+		return n.fusefs.backendError(ctx, err)
 	}
 
 	// Invalidate parent directory cache
-	n.fusefs.inodeManager.InvalidateDir(n.path)
+	n.backend.inodes.InvalidateDir(n.path())
 
 	return 0
 }
 
 // Rmdir removes a directory
 func (n *fuseNode) Rmdir(ctx context.Context, name string) syscall.Errno {
-	n.fusefs.stats.recordOperation()
+	return n.fusefs.intercept(ctx, newOp(ctx, OpRmdir, n.childPath(name)), func(ctx context.Context) syscall.Errno {
+		return n.rmdir(ctx, name)
+	})
+}
 
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
+// rmdir implements Rmdir
+func (n *fuseNode) rmdir(ctx context.Context, name string) syscall.Errno {
+	if n.isControlName(name) || n.isJournalName(name) {
+		return syscall.EPERM
 	}
 
 	// Build full path
-	fullPath := path.Join(n.path, name)
+	fullPath := n.childPath(name)
 
 	// Remove directory
-	err := n.fusefs.absFS.Remove(fullPath)
+	start := time.Now()
+	err := n.backend.fs().Remove(fullPath)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return mapError(err)
+		return n.fusefs.backendError(ctx, err)
 	}
 
 	// Invalidate parent directory cache
-	n.fusefs.inodeManager.InvalidateDir(n.path)
-
-	return 0
-}
-
-// Rename renames a file or directory
-func (n *fuseNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
-	}
-
-	// Build paths
-	oldPath := path.Join(n.path, name)
-
-	newParentNode, ok := newParent.(*fuseNode)
-	if !ok {
-		return syscall.EINVAL
-	}
-	newPath := path.Join(newParentNode.path, newName)
-
-	// Rename through absfs
-	err := n.fusefs.absFS.Rename(oldPath, newPath)
-	if err != nil {
-		n.fusefs.stats.recordError()
-		return mapError(err)
-	}
-
-	// Invalidate both directory caches
-	n.fusefs.inodeManager.InvalidateDir(n.path)
-	n.fusefs.inodeManager.InvalidateDir(newParentNode.path)
+	n.backend.inodes.InvalidateDir(n.path())
 
 	return 0
 }
 
 // Setattr sets file attributes
 func (n *fuseNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
-	}
+	return n.fusefs.intercept(ctx, newOp(ctx, OpSetattr, n.path()), func(ctx context.Context) syscall.Errno {
+		return n.setattr(ctx, f, in, out)
+	})
+}
 
+// setattr implements Setattr
+func (n *fuseNode) setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
 	// Handle size changes (truncate)
 	if sz, ok := in.GetSize(); ok {
 		// If we have a file handle, truncate through it
 		if f != nil {
-			if fh, ok := f.(*fuseFileHandle); ok {
+			if fh, ok := fileHandle(f); ok {
 				file := n.fusefs.handleTracker.Get(fh.handle)
 				if truncater, ok := file.(interface{ Truncate(int64) error }); ok {
-					if err := truncater.Truncate(int64(sz)); err != nil {
-						n.fusefs.stats.recordError()
-						return mapError(err)
+					unlock := n.backend.writeLocks.lock(n.StableAttr().Ino, false)
+					start := time.Now()
+					err := truncater.Truncate(int64(sz))
+					timeBackend(ctx, start)
+					unlock()
+					if err != nil {
+						return n.fusefs.backendError(ctx, err)
 					}
+					n.fusefs.handleTracker.MarkDirty(fh.handle)
 				}
 			}
 		}
@@ -541,12 +611,14 @@ func (n *fuseNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAtt
 
 	// Handle mode changes
 	if mode, ok := in.GetMode(); ok {
-		if chmodder, ok := n.fusefs.absFS.(interface {
+		if chmodder, ok := n.backend.fs().(interface {
 			Chmod(string, os.FileMode) error
 		}); ok {
-			if err := chmodder.Chmod(n.path, os.FileMode(mode)); err != nil {
-				n.fusefs.stats.recordError()
-				return mapError(err)
+			start := time.Now()
+			err := chmodder.Chmod(n.path(), os.FileMode(mode))
+			timeBackend(ctx, start)
+			if err != nil {
+				return n.fusefs.backendError(ctx, err)
 			}
 		}
 	}
@@ -555,37 +627,46 @@ func (n *fuseNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAtt
 	if mtime, ok := in.GetMTime(); ok {
 		// Try to use Chtimes if the filesystem supports it
 		// Note: We use atime = mtime for simplicity
-		if err := n.fusefs.absFS.Chtimes(n.path, mtime, mtime); err != nil {
+		start := time.Now()
+		err := n.backend.fs().Chtimes(n.path(), mtime, mtime)
+		timeBackend(ctx, start)
+		if err != nil {
 			// Ignore error if Chtimes is not supported
 			_ = err
 		}
 	}
 
-	// Get updated attributes
-	return n.Getattr(ctx, f, out)
+	// Get updated attributes, not the ones cached before the change
+	n.backend.inodes.InvalidateAttr(n.path())
+	return n.getattr(ctx, f, out)
 }
 
 // Fsync ensures writes to the file are flushed to storage
 func (n *fuseNode) Fsync(ctx context.Context, f fs.FileHandle, flags uint32) syscall.Errno {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
+	op := newOp(ctx, OpFsync, n.path())
+	if fh, ok := fileHandle(f); ok {
+		op.Handle = fh.handle
 	}
+	return n.fusefs.intercept(ctx, op, func(ctx context.Context) syscall.Errno {
+		return n.fsync(ctx, f, flags)
+	})
+}
 
+// fsync implements Fsync
+func (n *fuseNode) fsync(ctx context.Context, f fs.FileHandle, flags uint32) syscall.Errno {
 	// If we have a file handle, sync through it
-	if fh, ok := f.(*fuseFileHandle); ok {
+	if fh, ok := fileHandle(f); ok {
 		file := n.fusefs.handleTracker.Get(fh.handle)
 		if file == nil {
 			return syscall.EBADF
 		}
 
-		// Call Sync if the file supports it
-		if syncer, ok := file.(interface{ Sync() error }); ok {
-			if err := syncer.Sync(); err != nil {
-				n.fusefs.stats.recordError()
-				return mapError(err)
-			}
+		// Sync the file if it supports it
+		start := time.Now()
+		err := n.fusefs.handleTracker.Sync(fh.handle)
+		timeBackend(ctx, start)
+		if err != nil {
+			return n.fusefs.backendError(ctx, err)
 		}
 	}
 
@@ -594,17 +675,30 @@ func (n *fuseNode) Fsync(ctx context.Context, f fs.FileHandle, flags uint32) sys
 
 // Symlink creates a symbolic link
 func (n *fuseNode) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	n.fusefs.stats.recordOperation()
+	var child *fs.Inode
+	op := newOp(ctx, OpSymlink, n.childPath(name))
+	op.Target = target
+	errno := n.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		child, errno = n.symlink(ctx, target, name, out)
+		return errno
+	})
+	return child, errno
+}
 
-	if n.fusefs.checkUnmounting() {
-		return nil, syscall.ENOTCONN
+// symlink implements Symlink
+func (n *fuseNode) symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if n.isControlName(name) {
+		return nil, syscall.EEXIST
+	}
+	if n.isJournalName(name) {
+		return nil, syscall.EPERM
 	}
 
 	// Build full path
-	fullPath := path.Join(n.path, name)
+	fullPath := n.childPath(name)
 
 	// Check if filesystem supports symlinks
-	symlinkFS, ok := n.fusefs.absFS.(interface {
+	symlinkFS, ok := n.backend.fs().(interface {
 		Symlink(oldname, newname string) error
 	})
 	if !ok {
@@ -612,44 +706,45 @@ func (n *fuseNode) Symlink(ctx context.Context, target, name string, out *fuse.E
 	}
 
 	// Create symlink
-	err := symlinkFS.Symlink(target, fullPath)
+	start := time.Now()
+	err := symlinkFS.Symlink(n.backend.linkTargetIn(target), fullPath)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
 	// Invalidate parent directory cache
-	n.fusefs.inodeManager.InvalidateDir(n.path)
+	n.backend.inodes.InvalidateDir(n.path())
 
 	// Get link info (using Lstat to get the link itself, not its target)
 	var info os.FileInfo
-	if lstatFS, ok := n.fusefs.absFS.(interface {
+	if lstatFS, ok := n.backend.fs().(interface {
 		Lstat(name string) (os.FileInfo, error)
 	}); ok {
+		start = time.Now()
 		info, err = lstatFS.Lstat(fullPath)
+		timeBackend(ctx, start)
 	} else {
 		// Fall back to Stat if Lstat not available
-		info, err = n.fusefs.absFS.Stat(fullPath)
+		start = time.Now()
+		info, err = n.backend.fs().Stat(fullPath)
+		timeBackend(ctx, start)
 	}
 
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
 	// Allocate inode
-	ino := n.fusefs.inodeManager.GetInode(fullPath, info)
+	ino := n.backend.inodes.GetInode(fullPath, info)
 
 	// Fill entry attributes
 	n.fillAttr(&out.Attr, info, ino)
-	out.SetEntryTimeout(n.fusefs.opts.EntryTimeout)
-	out.SetAttrTimeout(n.fusefs.opts.AttrTimeout)
+	out.SetEntryTimeout(n.fusefs.options().EntryTimeout)
+	out.SetAttrTimeout(n.fusefs.options().AttrTimeout)
 
 	// Create child node
-	child := &fuseNode{
-		fusefs: n.fusefs,
-		path:   fullPath,
-	}
+	child := newFuseNode(n.fusefs, n.backend, fullPath)
 
 	// Create the inode
 	childInode := n.NewInode(ctx, child, fs.StableAttr{
@@ -662,10 +757,25 @@ func (n *fuseNode) Symlink(ctx context.Context, target, name string, out *fuse.E
 
 // Link creates a hard link
 func (n *fuseNode) Link(ctx context.Context, target fs.InodeEmbedder, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	n.fusefs.stats.recordOperation()
+	var child *fs.Inode
+	op := newOp(ctx, OpLink, n.childPath(name))
+	if targetNode, ok := target.(*fuseNode); ok {
+		op.Target = targetNode.path()
+	}
+	errno := n.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		child, errno = n.link(ctx, target, name, out)
+		return errno
+	})
+	return child, errno
+}
 
-	if n.fusefs.checkUnmounting() {
-		return nil, syscall.ENOTCONN
+// link implements Link
+func (n *fuseNode) link(ctx context.Context, target fs.InodeEmbedder, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if n.isControlName(name) {
+		return nil, syscall.EEXIST
+	}
+	if n.isJournalName(name) {
+		return nil, syscall.EPERM
 	}
 
 	// Get target node
@@ -673,12 +783,15 @@ func (n *fuseNode) Link(ctx context.Context, target fs.InodeEmbedder, name strin
 	if !ok {
 		return nil, syscall.EINVAL
 	}
+	if targetNode.backend != n.backend {
+		return nil, syscall.EXDEV
+	}
 
 	// Build new path
-	newPath := path.Join(n.path, name)
+	newPath := n.childPath(name)
 
 	// Check if filesystem supports hard links
-	linkFS, ok := n.fusefs.absFS.(interface {
+	linkFS, ok := n.backend.fs().(interface {
 		Link(oldname, newname string) error
 	})
 	if !ok {
@@ -686,35 +799,34 @@ func (n *fuseNode) Link(ctx context.Context, target fs.InodeEmbedder, name strin
 	}
 
 	// Create hard link
-	err := linkFS.Link(targetNode.path, newPath)
+	start := time.Now()
+	err := linkFS.Link(targetNode.path(), newPath)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
 	// Invalidate parent directory cache
-	n.fusefs.inodeManager.InvalidateDir(n.path)
+	n.backend.inodes.InvalidateDir(n.path())
 
 	// Get file info
-	info, err := n.fusefs.absFS.Stat(newPath)
+	start = time.Now()
+	info, err := n.backend.fs().Stat(newPath)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
 	// Use the same inode as the target (hard links share inodes)
-	ino := n.fusefs.inodeManager.GetInode(newPath, info)
+	ino := n.backend.inodes.GetInode(newPath, info)
 
 	// Fill entry attributes
 	n.fillAttr(&out.Attr, info, ino)
-	out.SetEntryTimeout(n.fusefs.opts.EntryTimeout)
-	out.SetAttrTimeout(n.fusefs.opts.AttrTimeout)
+	out.SetEntryTimeout(n.fusefs.options().EntryTimeout)
+	out.SetAttrTimeout(n.fusefs.options().AttrTimeout)
 
 	// Create child node
-	child := &fuseNode{
-		fusefs: n.fusefs,
-		path:   newPath,
-	}
+	child := newFuseNode(n.fusefs, n.backend, newPath)
 
 	// Create the inode
 	childInode := n.NewInode(ctx, child, fs.StableAttr{
@@ -727,14 +839,18 @@ func (n *fuseNode) Link(ctx context.Context, target fs.InodeEmbedder, name strin
 
 // Readlink reads the target of a symbolic link
 func (n *fuseNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return nil, syscall.ENOTCONN
-	}
+	var target []byte
+	errno := n.fusefs.intercept(ctx, newOp(ctx, OpReadlink, n.path()), func(ctx context.Context) (errno syscall.Errno) {
+		target, errno = n.readlink(ctx)
+		return errno
+	})
+	return target, errno
+}
 
+// readlink implements Readlink
+func (n *fuseNode) readlink(ctx context.Context) ([]byte, syscall.Errno) {
 	// Check if filesystem supports reading symlinks
-	readlinkFS, ok := n.fusefs.absFS.(interface {
+	readlinkFS, ok := n.backend.fs().(interface {
 		Readlink(name string) (string, error)
 	})
 	if !ok {
@@ -742,13 +858,14 @@ func (n *fuseNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
 	}
 
 	// Read the symlink target
-	target, err := readlinkFS.Readlink(n.path)
+	start := time.Now()
+	target, err := readlinkFS.Readlink(n.path())
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return nil, mapError(err)
+		return nil, n.fusefs.backendError(ctx, err)
 	}
 
-	return []byte(target), 0
+	return []byte(n.backend.linkTargetOut(target)), 0
 }
 
 // Helper methods
@@ -757,19 +874,19 @@ func (n *fuseNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
 func (n *fuseNode) fillAttr(attr *fuse.Attr, info os.FileInfo, ino uint64) {
 	attr.Ino = ino
 	attr.Size = uint64(info.Size())
-	attr.Mode = uint32(info.Mode())
+	attr.Mode = unixMode(info.Mode())
 	attr.Mtime = uint64(info.ModTime().Unix())
 	attr.Mtimensec = uint32(info.ModTime().Nanosecond())
 
 	// Set UID/GID from options if provided
-	if n.fusefs.opts.UID != 0 {
-		attr.Uid = n.fusefs.opts.UID
+	if n.fusefs.options().UID != 0 {
+		attr.Uid = n.fusefs.options().UID
 	} else {
 		attr.Uid = uint32(os.Getuid())
 	}
 
-	if n.fusefs.opts.GID != 0 {
-		attr.Gid = n.fusefs.opts.GID
+	if n.fusefs.options().GID != 0 {
+		attr.Gid = n.fusefs.options().GID
 	} else {
 		attr.Gid = uint32(os.Getgid())
 	}
@@ -780,6 +897,38 @@ func (n *fuseNode) fillAttr(attr *fuse.Attr, info os.FileInfo, ino uint64) {
 	attr.Blksize = 4096
 }
 
+// unixMode converts a Go file mode to a unix mode with S_IF* type bits
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+
+	switch {
+	case mode.IsDir():
+		m |= syscall.S_IFDIR
+	case mode&os.ModeSymlink != 0:
+		m |= syscall.S_IFLNK
+	case mode&os.ModeNamedPipe != 0:
+		m |= syscall.S_IFIFO
+	case mode&os.ModeSocket != 0:
+		m |= syscall.S_IFSOCK
+	case mode&os.ModeCharDevice != 0:
+		m |= syscall.S_IFCHR
+	case mode&os.ModeDevice != 0:
+		m |= syscall.S_IFBLK
+	default:
+		m |= syscall.S_IFREG
+	}
+	return m
+}
+
 // mapOpenFlags maps FUSE open flags to absfs flags
 func (n *fuseNode) mapOpenFlags(flags uint32) int {
 	absFlags := 0
@@ -810,9 +959,31 @@ func (n *fuseNode) mapOpenFlags(flags uint32) int {
 	return absFlags
 }
 
-// convertDirEntries converts fuse.DirEntry to fs.DirEntry
+// convertDirEntries converts fuse.DirEntry to fs.DirEntry.
+// In the root directory, a backend entry shadowed by the control directory
+// is dropped, and the control directory is listed if configured visible.
+// The rename journal is dropped from the root of its backend.
 func (n *fuseNode) convertDirEntries(entries []fuse.DirEntry) []fuse.DirEntry {
-	return entries
+	opts := n.fusefs.options()
+	control := opts.ControlDir != "" && n == n.fusefs.root
+	if !control && !n.isJournalName(dirRenameJournal) {
+		return entries
+	}
+
+	result := make([]fuse.DirEntry, 0, len(entries)+1)
+	for _, entry := range entries {
+		if (control && entry.Name == opts.ControlDir) || n.isJournalName(entry.Name) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	if control && opts.ControlDirVisible {
+		result = append(result, fuse.DirEntry{
+			Name: opts.ControlDir,
+			Mode: syscall.S_IFDIR,
+		})
+	}
+	return result
 }
 
 // Ensure fuseFileHandle implements required interfaces
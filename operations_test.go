@@ -0,0 +1,72 @@
+package fusefs
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestUnixMode(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want uint32
+	}{
+		{0644, syscall.S_IFREG | 0644},
+		{os.ModeDir | 0755, syscall.S_IFDIR | 0755},
+		{os.ModeSymlink | 0777, syscall.S_IFLNK | 0777},
+		{os.ModeNamedPipe | 0600, syscall.S_IFIFO | 0600},
+		{os.ModeDevice | os.ModeCharDevice | 0666, syscall.S_IFCHR | 0666},
+		{os.ModeDevice | 0660, syscall.S_IFBLK | 0660},
+		{os.ModeDir | os.ModeSticky | 0777, syscall.S_IFDIR | syscall.S_ISVTX | 0777},
+		{os.ModeSetuid | os.ModeSetgid | 0755, syscall.S_IFREG | syscall.S_ISUID | syscall.S_ISGID | 0755},
+	}
+	for _, tt := range tests {
+		if got := unixMode(tt.mode); got != tt.want {
+			t.Errorf("unixMode(%v) = %o, want %o", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestGetattr_CachesCopy(t *testing.T) {
+	_, _, file := newRenameTestFS(t, newMemFS(t))
+
+	var out fuse.AttrOut
+	if errno := file.Getattr(context.Background(), nil, &out); errno != 0 {
+		t.Fatalf("Getattr = %v", errno)
+	}
+	want := out.Attr
+
+	// go-fuse reuses the reply for other requests
+	out.Attr = fuse.Attr{}
+
+	var cached fuse.AttrOut
+	if errno := file.Getattr(context.Background(), nil, &cached); errno != 0 {
+		t.Fatalf("cached Getattr = %v", errno)
+	}
+	if cached.Attr != want {
+		t.Errorf("cached Getattr = %+v, want %+v", cached.Attr, want)
+	}
+}
+
+func TestSetattr_RefreshesCachedAttr(t *testing.T) {
+	_, _, file := newRenameTestFS(t, newMemFS(t))
+
+	var out fuse.AttrOut
+	if errno := file.Getattr(context.Background(), nil, &out); errno != 0 {
+		t.Fatalf("Getattr = %v", errno)
+	}
+
+	in := &fuse.SetAttrIn{SetAttrInCommon: fuse.SetAttrInCommon{Valid: fuse.FATTR_MODE, Mode: 0600}}
+	if errno := file.Setattr(context.Background(), nil, in, &out); errno != 0 {
+		t.Fatalf("Setattr = %v", errno)
+	}
+	if perm := out.Mode & 0777; perm != 0600 {
+		t.Errorf("Setattr mode = %o, want 600", perm)
+	}
+	if errno := file.Getattr(context.Background(), nil, &out); errno != 0 || out.Mode&0777 != 0600 {
+		t.Errorf("Getattr after Setattr = %o, %v, want 600", out.Mode&0777, errno)
+	}
+}
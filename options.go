@@ -1,7 +1,17 @@
 package fusefs
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
 )
 
 // MountOptions configures the FUSE mount behavior and performance characteristics.
@@ -12,6 +22,12 @@ type MountOptions struct {
 	// Mountpoint is the directory where the filesystem will be mounted
 	Mountpoint string
 
+	// Root is the directory of the absfs.FileSystem to mount as the root,
+	// e.g. "/projects/foo". Empty mounts the whole filesystem. Paths,
+	// including ".." and symbolic links, cannot reach outside Root;
+	// absolute link targets are interpreted relative to it.
+	Root string
+
 	// ReadOnly mounts the filesystem in read-only mode
 	ReadOnly bool
 
@@ -32,6 +48,25 @@ type MountOptions struct {
 	// DirectIO disables page cache for reads/writes
 	DirectIO bool
 
+	// DirectIORules choose between direct I/O and the page cache for the
+	// files they match, overriding DirectIO, e.g. to read log files that
+	// other hosts append to directly while caching static assets. The
+	// first matching rule applies.
+	DirectIORules []DirectIORule
+
+	// Passthrough lets the kernel read and write files backed by a host
+	// file descriptor, such as the *os.File of a local directory, directly
+	// instead of through fusefs. It needs Linux 6.9 or later and
+	// CAP_SYS_ADMIN. Reads and writes of such files bypass interceptors
+	// and operation statistics. Other files, files opened with direct I/O
+	// and all files on kernels without passthrough use normal I/O;
+	// Stats.PassthroughOpens and PassthroughFallbacks count both kinds.
+	// The kernel needs every open of a file to use passthrough while one
+	// does, so a file opened with direct I/O then uses passthrough too.
+	// As fusefs cannot tell whether such files were written,
+	// UnmountContext syncs every one open for writing.
+	Passthrough bool
+
 	// MaxReadahead sets maximum readahead (bytes)
 	MaxReadahead uint32
 
@@ -47,17 +82,81 @@ type MountOptions struct {
 	// EntryTimeout sets directory entry cache timeout
 	EntryTimeout time.Duration
 
-	// FSName is the name shown in mount table
+	// FSName is the name shown in mount table, as the mount source and in
+	// the mount type "fuse.<FSName>", see ListMounts
 	FSName string
 
-	// Options contains additional FUSE options
+	// RecoverStale unmounts a stale FUSE mount left at Mountpoint by a
+	// server that exited without unmounting, e.g. after a crash. Without
+	// it, Mount fails on such a mountpoint.
+	RecoverStale bool
+
+	// FuseFD is a /dev/fuse file descriptor that a privileged process has
+	// already opened and mounted, e.g. a sidecar in a container that
+	// cannot run fusermount. Mount serves it through the magic
+	// "/dev/fd/N" mountpoint and leaves Mountpoint untouched; Mountpoint
+	// may name the real mountpoint for statistics and for Unmount, or be
+	// empty. Zero disables it.
+	FuseFD int
+
+	// FuseFDSocket is the path of a unix socket served by ServeFuseFD in
+	// a privileged helper process. Mount receives the mounted /dev/fuse
+	// file descriptor from the helper, which unmounts the filesystem
+	// when Unmount is called or the connection is lost.
+	FuseFDSocket string
+
+	// Options contains additional FUSE options, passed to the kernel as
+	// they are. Options that go-fuse sets itself, such as "subtype" or
+	// "max_read", and options contradicting a field, such as "rw" with
+	// ReadOnly, are rejected by Validate.
 	Options []string
 
-	// Debug enables debug logging
+	// Debug enables go-fuse's raw protocol dump on the standard logger
 	Debug bool
 
+	// Logger receives structured records for each operation and for
+	// backend errors. Operations are logged at debug level, slow operations
+	// as warnings and backend errors without an errno mapping as errors.
+	// Nil disables logging.
+	Logger *slog.Logger
+
+	// SlowOpThreshold is the duration above which an operation is logged
+	// as slow. Zero disables slow operation reporting.
+	SlowOpThreshold time.Duration
+
+	// ControlDir names a virtual directory in the root of the mount that
+	// exposes statistics and runtime controls as files, e.g. ".fusefs".
+	// It shadows any backend entry of the same name. Empty disables it.
+	ControlDir string
+
+	// ControlDirVisible lists ControlDir in the root directory. When
+	// false, the directory is only reachable by name.
+	ControlDirVisible bool
+
+	// ControlSocket is the path of a unix socket that serves statistics
+	// and runtime controls as JSON requests, for programs administering
+	// the mount; see ControlClient. Empty disables it.
+	ControlSocket string
+
+	// EmulateDirRename renames directories by copying their tree and then
+	// removing the original when the backend cannot rename them, such as
+	// an object store. The rename is not atomic: it is recorded in a
+	// journal file, .fusefs-rename.json in the root of the backend, which
+	// is hidden from the mount, and an interrupted rename is rolled back
+	// or completed at the next mount with EmulateDirRename, which fails
+	// if it cannot do so. Progress is
+	// reported in Stats.DirRenames. Without it, directory renames the
+	// backend does not support fail with EXDEV, so that tools such as
+	// mv(1) copy instead.
+	EmulateDirRename bool
+
+	// Interceptors wrap every filesystem operation, outermost first.
+	// See Interceptor for details.
+	Interceptors []Interceptor
+
 	// Cache configuration for user-space caches
 	// These control the behavior of internal caches, separate from kernel FUSE caching
+	// They can be changed while mounted with FuseFS.Reconfigure
 
 	// AttrCacheTTL sets how long file attributes are cached in user-space
 	// before being re-fetched from the underlying filesystem.
@@ -115,3 +214,394 @@ func DefaultMountOptions(mountpoint string) *MountOptions {
 		MaxCachedDirs:      1000,
 	}
 }
+
+// maxKernelWrite is the largest read or write request the kernel sends
+const maxKernelWrite = fuse.MAX_KERNEL_WRITE
+
+// passthroughOptions are generic mount options that ParseMountOptions
+// keeps in MountOptions.Options
+var passthroughOptions = map[string]bool{
+	"nosuid":       true,
+	"suid":         true,
+	"nodev":        true,
+	"dev":          true,
+	"noexec":       true,
+	"exec":         true,
+	"sync":         true,
+	"async":        true,
+	"dirsync":      true,
+	"atime":        true,
+	"noatime":      true,
+	"diratime":     true,
+	"nodiratime":   true,
+	"relatime":     true,
+	"norelatime":   true,
+	"strictatime":  true,
+	"lazytime":     true,
+	"nolazytime":   true,
+	"auto_unmount": true,
+	"blksize":      true,
+}
+
+// ignoredOptions are fstab options meant for mount(8) rather than the
+// filesystem; ParseMountOptions skips them, as do options starting with
+// "x-"
+var ignoredOptions = map[string]bool{
+	"defaults": true,
+	"auto":     true,
+	"noauto":   true,
+	"user":     true,
+	"nouser":   true,
+	"users":    true,
+	"owner":    true,
+	"group":    true,
+	"_netdev":  true,
+	"nofail":   true,
+}
+
+// reservedOptions are set by fusefs itself and may not appear in
+// MountOptions.Options
+var reservedOptions = map[string]bool{
+	"subtype":  true,
+	"max_read": true,
+	"fd":       true,
+	"rootmode": true,
+	"user_id":  true,
+	"group_id": true,
+}
+
+// negatedOptions name the negation of flags that have a conventional name
+var negatedOptions = map[string]string{
+	"noro":         "rw",
+	"noasync_read": "sync_read",
+}
+
+// optionField is a field of MountOptions with a mount option name. value
+// is a *bool, *time.Duration, *uint32, *int or *string.
+type optionField struct {
+	name  string
+	value any
+}
+
+// optionFields returns the fields of o that have a mount option name, in
+// the order String writes them
+func (o *MountOptions) optionFields() []optionField {
+	return []optionField{
+		{"ro", &o.ReadOnly},
+		{"allow_other", &o.AllowOther},
+		{"allow_root", &o.AllowRoot},
+		{"default_permissions", &o.DefaultPermissions},
+		{"direct_io", &o.DirectIO},
+		{"async_read", &o.AsyncRead},
+		{"debug", &o.Debug},
+		{"recover_stale", &o.RecoverStale},
+		{"control_dir_visible", &o.ControlDirVisible},
+		{"emulate_dir_rename", &o.EmulateDirRename},
+		{"passthrough", &o.Passthrough},
+		{"fsname", &o.FSName},
+		{"root", &o.Root},
+		{"control_dir", &o.ControlDir},
+		{"control_socket", &o.ControlSocket},
+		{"uid", &o.UID},
+		{"gid", &o.GID},
+		{"max_write", &o.MaxWrite},
+		{"max_readahead", &o.MaxReadahead},
+		{"attr_timeout", &o.AttrTimeout},
+		{"entry_timeout", &o.EntryTimeout},
+		{"attr_cache_ttl", &o.AttrCacheTTL},
+		{"dir_cache_ttl", &o.DirCacheTTL},
+		{"max_cached_inodes", &o.MaxCachedInodes},
+		{"max_cached_dirs", &o.MaxCachedDirs},
+		{"slow_op_threshold", &o.SlowOpThreshold},
+		{"fuse_fd", &o.FuseFD},
+		{"fuse_fd_socket", &o.FuseFDSocket},
+	}
+}
+
+// ParseMountOptions parses a mount(8)-style option string, as found in
+// /etc/fstab, into mount options. Options not in the string keep their
+// DefaultMountOptions values; the mountpoint is left empty.
+//
+// Flags such as "ro", "allow_other" or "direct_io" are set by their name
+// and cleared with a "no" prefix, e.g. "nodefault_permissions"; "rw" and
+// "sync_read" are accepted as well. Other options take a value, e.g.
+// "uid=1000", "max_write=1048576" or "fsname=data". Timeouts and TTLs are
+// in seconds, e.g. "attr_timeout=0.5", or Go durations such as "5m". A
+// comma or backslash within a value is escaped with a backslash.
+//
+// Generic mount options such as "nosuid" or "noatime" are kept in
+// MountOptions.Options. Options for mount(8) itself, such as "defaults",
+// "noauto" and "x-*", are ignored. Unknown options are an error, as are
+// options that fail Validate.
+//
+// Example:
+//
+//	opts, err := fusefs.ParseMountOptions("ro,allow_other,attr_timeout=5,uid=1000")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	opts.Mountpoint = "/mnt/data"
+func ParseMountOptions(s string) (*MountOptions, error) {
+	opts := DefaultMountOptions("")
+	if err := opts.Set(s); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// Set applies a mount option string to o, as ParseMountOptions does to the
+// defaults, without validating the result. Together with String, it makes
+// *MountOptions a flag.Value:
+//
+//	opts := fusefs.DefaultMountOptions("/mnt/data")
+//	flag.Var(opts, "o", "mount options, e.g. ro,allow_other")
+func (o *MountOptions) Set(s string) error {
+	for _, opt := range splitMountOptions(s) {
+		if opt == "" {
+			continue
+		}
+		if err := o.setOption(opt); err != nil {
+			return fmt.Errorf("option %q: %w", opt, err)
+		}
+	}
+	return nil
+}
+
+// setOption applies a single option of a mount option string
+func (o *MountOptions) setOption(opt string) error {
+	name, value, hasValue := strings.Cut(opt, "=")
+	if ignoredOptions[name] || strings.HasPrefix(name, "x-") {
+		return nil
+	}
+	if passthroughOptions[name] {
+		o.Options = append(o.Options, opt)
+		return nil
+	}
+
+	for negated, alias := range negatedOptions {
+		if name == alias {
+			name = negated
+		}
+	}
+	set := true
+	field, ok := o.optionField(name)
+	if !ok && strings.HasPrefix(name, "no") {
+		field, ok = o.optionField(strings.TrimPrefix(name, "no"))
+		set = false
+	}
+	if !ok {
+		return fmt.Errorf("unknown option: %w", os.ErrInvalid)
+	}
+
+	if flag, isFlag := field.value.(*bool); isFlag {
+		if hasValue {
+			return fmt.Errorf("flag takes no value: %w", os.ErrInvalid)
+		}
+		*flag = set
+		return nil
+	}
+	if !set || !hasValue {
+		return fmt.Errorf("option requires a value: %w", os.ErrInvalid)
+	}
+
+	switch v := field.value.(type) {
+	case *string:
+		*v = value
+	case *time.Duration:
+		d, err := parseOptionDuration(value)
+		if err != nil {
+			return err
+		}
+		*v = d
+	case *uint32:
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid number: %w", os.ErrInvalid)
+		}
+		*v = uint32(n)
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number: %w", os.ErrInvalid)
+		}
+		*v = n
+	}
+	return nil
+}
+
+// optionField returns the field of o named name
+func (o *MountOptions) optionField(name string) (optionField, bool) {
+	for _, field := range o.optionFields() {
+		if field.name == name {
+			return field, true
+		}
+	}
+	return optionField{}, false
+}
+
+// parseOptionDuration parses a timeout in seconds or a Go duration
+func parseOptionDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(math.Round(seconds * float64(time.Second))), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %w", os.ErrInvalid)
+	}
+	return d, nil
+}
+
+// String returns o as a mount option string that ParseMountOptions turns
+// back into o. Only options that differ from DefaultMountOptions are
+// included. Mountpoint, Logger, Interceptors and DirectIORules have no
+// option string form and are omitted.
+func (o *MountOptions) String() string {
+	defaults := DefaultMountOptions("").optionFields()
+
+	var parts []string
+	for i, field := range o.optionFields() {
+		switch v := field.value.(type) {
+		case *bool:
+			if *v == *defaults[i].value.(*bool) {
+				continue
+			}
+			name := field.name
+			if !*v {
+				name = "no" + name
+				if alias, ok := negatedOptions[name]; ok {
+					name = alias
+				}
+			}
+			parts = append(parts, name)
+		case *string:
+			if *v != *defaults[i].value.(*string) {
+				parts = append(parts, field.name+"="+escapeMountOption(*v))
+			}
+		case *time.Duration:
+			if *v != *defaults[i].value.(*time.Duration) {
+				parts = append(parts, field.name+"="+strconv.FormatFloat(v.Seconds(), 'f', -1, 64))
+			}
+		case *uint32:
+			if *v != *defaults[i].value.(*uint32) {
+				parts = append(parts, field.name+"="+strconv.FormatUint(uint64(*v), 10))
+			}
+		case *int:
+			if *v != *defaults[i].value.(*int) {
+				parts = append(parts, field.name+"="+strconv.Itoa(*v))
+			}
+		}
+	}
+
+	for _, opt := range o.Options {
+		parts = append(parts, escapeMountOption(opt))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Validate checks the options for values the kernel or fusefs would
+// reject, so that mistakes are reported clearly rather than as a failed
+// mount. The errors wrap os.ErrInvalid. Mount and Reconfigure validate
+// options before using them.
+//
+// Validate rejects:
+//   - MaxWrite above the kernel limit of 1 MiB
+//   - negative timeouts, TTLs, cache sizes or file descriptors
+//   - AllowOther combined with AllowRoot
+//   - FuseFD combined with FuseFDSocket
+//   - a ControlDir that is not a single file name
+//   - a DirectIORules pattern that is malformed
+//   - Options set by fusefs itself, such as "subtype", or contradicting a
+//     field, such as "rw" with ReadOnly
+//
+// Other Options are not checked, since the kernel and fusermount accept
+// options unknown to fusefs.
+func (o *MountOptions) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format+": %w", append(args, os.ErrInvalid)...))
+	}
+
+	if o.MaxWrite > maxKernelWrite {
+		invalid("MaxWrite %d exceeds the kernel limit of %d", o.MaxWrite, maxKernelWrite)
+	}
+
+	nonNegative := []struct {
+		name  string
+		value int64
+	}{
+		{"AttrTimeout", int64(o.AttrTimeout)},
+		{"EntryTimeout", int64(o.EntryTimeout)},
+		{"AttrCacheTTL", int64(o.AttrCacheTTL)},
+		{"DirCacheTTL", int64(o.DirCacheTTL)},
+		{"SlowOpThreshold", int64(o.SlowOpThreshold)},
+		{"MaxCachedInodes", int64(o.MaxCachedInodes)},
+		{"MaxCachedDirs", int64(o.MaxCachedDirs)},
+		{"FuseFD", int64(o.FuseFD)},
+	}
+	for _, field := range nonNegative {
+		if field.value < 0 {
+			invalid("%s cannot be negative", field.name)
+		}
+	}
+
+	if o.AllowOther && o.AllowRoot {
+		invalid("AllowOther and AllowRoot are mutually exclusive")
+	}
+	if o.FuseFD > 0 && o.FuseFDSocket != "" {
+		invalid("FuseFD and FuseFDSocket are mutually exclusive")
+	}
+	if o.ControlDir != "" && (strings.Contains(o.ControlDir, "/") || o.ControlDir == "." || o.ControlDir == "..") {
+		invalid("ControlDir %q must be a file name", o.ControlDir)
+	}
+	for _, rule := range o.DirectIORules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			invalid("DirectIORules pattern %q is malformed", rule.Pattern)
+		}
+	}
+
+	// go-fuse joins the options with commas
+	for _, opt := range strings.Split(strings.Join(o.Options, ","), ",") {
+		name, _, _ := strings.Cut(opt, "=")
+		switch {
+		case reservedOptions[name]:
+			invalid("option %q is set by fusefs", opt)
+		case name == "rw" && o.ReadOnly:
+			invalid("option %q conflicts with ReadOnly", opt)
+		case name == "allow_other" && o.AllowRoot:
+			invalid("option %q conflicts with AllowRoot", opt)
+		case name == "allow_root" && o.AllowOther:
+			invalid("option %q conflicts with AllowOther", opt)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// splitMountOptions splits a comma separated option string. A comma or
+// backslash escaped with a backslash is taken literally.
+func splitMountOptions(s string) []string {
+	var opts []string
+	var current strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			current.WriteByte(s[i])
+		case s[i] == ',':
+			opts = append(opts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(s[i])
+		}
+	}
+	return append(opts, current.String())
+}
+
+// escapeMountOption escapes commas and backslashes in an option value
+func escapeMountOption(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `,`, `\,`).Replace(s)
+}
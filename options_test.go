@@ -0,0 +1,139 @@
+package fusefs
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	opts, err := ParseMountOptions("ro,allow_other,nodefault_permissions,sync_read,attr_timeout=5,entry_timeout=250ms," +
+		"max_write=1048576,uid=1000,gid=100,fsname=data\\,archive,max_cached_inodes=50,nosuid,noatime,defaults,noauto,x-systemd.automount")
+	if err != nil {
+		t.Fatalf("ParseMountOptions: %v", err)
+	}
+
+	want := DefaultMountOptions("")
+	want.ReadOnly = true
+	want.AllowOther = true
+	want.DefaultPermissions = false
+	want.AsyncRead = false
+	want.AttrTimeout = 5 * time.Second
+	want.EntryTimeout = 250 * time.Millisecond
+	want.MaxWrite = 1048576
+	want.UID = 1000
+	want.GID = 100
+	want.FSName = "data,archive"
+	want.MaxCachedInodes = 50
+	want.Options = []string{"nosuid", "noatime"}
+
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("ParseMountOptions =\n%+v\nwant\n%+v", opts, want)
+	}
+}
+
+func TestParseMountOptions_Errors(t *testing.T) {
+	tests := []string{
+		"bogus",
+		"ro=1",
+		"uid",
+		"uid=-1",
+		"attr_timeout=soon",
+		"nofsname",
+		"max_write=2097152",
+		"attr_cache_ttl=-1",
+		"allow_other,allow_root",
+	}
+
+	for _, s := range tests {
+		if _, err := ParseMountOptions(s); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("ParseMountOptions(%q) error = %v, want ErrInvalid", s, err)
+		}
+	}
+}
+
+func TestMountOptions_StringRoundTrip(t *testing.T) {
+	if s := DefaultMountOptions("/mnt").String(); s != "" {
+		t.Errorf("String of defaults = %q, want empty", s)
+	}
+
+	opts := DefaultMountOptions("")
+	opts.ReadOnly = true
+	opts.DefaultPermissions = false
+	opts.AsyncRead = false
+	opts.Root = `/projects/a,b\c`
+	opts.ControlDir = ".fusefs"
+	opts.AttrTimeout = 1500 * time.Millisecond
+	opts.DirCacheTTL = 0
+	opts.MaxReadahead = 65536
+	opts.FuseFDSocket = "/run/fusefs.sock"
+	opts.Options = []string{"nodev"}
+
+	s := opts.String()
+	for _, part := range []string{"ro", "nodefault_permissions", "sync_read", "attr_timeout=1.5", "dir_cache_ttl=0", "nodev"} {
+		if !strings.Contains(","+s+",", ","+part+",") {
+			t.Errorf("String = %q, missing %q", s, part)
+		}
+	}
+
+	parsed, err := ParseMountOptions(s)
+	if err != nil {
+		t.Fatalf("ParseMountOptions(%q): %v", s, err)
+	}
+	if !reflect.DeepEqual(parsed, opts) {
+		t.Errorf("round trip of %q =\n%+v\nwant\n%+v", s, parsed, opts)
+	}
+}
+
+func TestMountOptions_Validate(t *testing.T) {
+	if err := DefaultMountOptions("/mnt").Validate(); err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*MountOptions)
+	}{
+		{"max write", func(o *MountOptions) { o.MaxWrite = 4 << 20 }},
+		{"negative ttl", func(o *MountOptions) { o.AttrCacheTTL = -time.Second }},
+		{"negative timeout", func(o *MountOptions) { o.EntryTimeout = -time.Second }},
+		{"negative cache size", func(o *MountOptions) { o.MaxCachedDirs = -1 }},
+		{"allow other and root", func(o *MountOptions) { o.AllowOther, o.AllowRoot = true, true }},
+		{"fd and socket", func(o *MountOptions) { o.FuseFD, o.FuseFDSocket = 3, "/run/fusefs.sock" }},
+		{"control dir path", func(o *MountOptions) { o.ControlDir = "a/b" }},
+		{"reserved option", func(o *MountOptions) { o.Options = []string{"subtype=other"} }},
+		{"joined reserved option", func(o *MountOptions) { o.Options = []string{"nosuid,max_read=4096"} }},
+		{"rw and read-only", func(o *MountOptions) { o.ReadOnly, o.Options = true, []string{"rw"} }},
+		{"allow other and root option", func(o *MountOptions) { o.AllowRoot, o.Options = true, []string{"allow_other"} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultMountOptions("/mnt")
+			tt.modify(opts)
+			if err := opts.Validate(); !errors.Is(err, os.ErrInvalid) {
+				t.Errorf("Validate error = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestMountOptions_ValidateRawOptions(t *testing.T) {
+	opts := DefaultMountOptions("/mnt")
+	opts.Options = []string{"nonempty", "kernel_cache", "max_readahead=65536", "ro", "fsname=data", "nosuid,nodev"}
+	if err := opts.Validate(); err != nil {
+		t.Errorf("Validate of options unknown to fusefs: %v", err)
+	}
+}
+
+func TestMount_InvalidOptions(t *testing.T) {
+	opts := DefaultMountOptions(t.TempDir())
+	opts.MaxWrite = 4 << 20
+
+	if _, err := Mount(nil, opts); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("Mount error = %v, want ErrInvalid", err)
+	}
+}
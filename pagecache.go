@@ -0,0 +1,70 @@
+package fusefs
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// DirectIORule selects direct I/O or the page cache for the files matching
+// a pattern, see MountOptions.DirectIORules
+type DirectIORule struct {
+	// Pattern is a path.Match pattern. A pattern containing a slash
+	// matches the path of the file within the mount, e.g. "/logs/*";
+	// other patterns match the file name, e.g. "*.log".
+	Pattern string
+
+	// DirectIO bypasses the page cache for the matching files; false
+	// caches them even if MountOptions.DirectIO is set
+	DirectIO bool
+}
+
+// matches reports whether the rule applies to the file at p, a path
+// within the mount
+func (r DirectIORule) matches(p string) bool {
+	name := path.Base(p)
+	if strings.Contains(r.Pattern, "/") {
+		name = p
+	}
+	matched, _ := path.Match(r.Pattern, name)
+	return matched
+}
+
+// directIO reports whether the file at p, a path within the mount, is
+// opened with direct I/O
+func (o *MountOptions) directIO(p string) bool {
+	for _, rule := range o.DirectIORules {
+		if rule.matches(p) {
+			return rule.DirectIO
+		}
+	}
+	return o.DirectIO
+}
+
+// mountedPath maps p, a path of the backend, to its path within the mount
+func (b *backend) mountedPath(p string) string {
+	rel, _ := withinPath(p, b.rootPath)
+	return path.Join(b.mountPath, rel)
+}
+
+// openFlags returns the FOPEN flags for file, opened from the node: direct
+// I/O if the options select it, or else keeping the data the kernel has
+// cached if the file has not changed since. This gives close-to-open
+// consistency at page cache speed.
+func (n *fuseNode) openFlags(ctx context.Context, file absfs.File) uint32 {
+	if n.fusefs.options().directIO(n.backend.mountedPath(n.path())) {
+		return fuse.FOPEN_DIRECT_IO
+	}
+
+	start := time.Now()
+	info, err := file.Stat()
+	timeBackend(ctx, start)
+	if err == nil && n.backend.inodes.KeepCache(n.StableAttr().Ino, info) {
+		return fuse.FOPEN_KEEP_CACHE
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestOpen_KeepCache(t *testing.T) {
+	fsys := newMemFS(t)
+	f, _, file := newRenameTestFS(t, fsys)
+
+	open := func() uint32 {
+		t.Helper()
+		fh, flags, errno := file.Open(context.Background(), uint32(os.O_RDONLY))
+		if errno != 0 {
+			t.Fatalf("Open = %v", errno)
+		}
+		fh.(*fuseFileHandle).Release(context.Background())
+		return flags
+	}
+
+	if flags := open(); flags != 0 {
+		t.Errorf("first Open flags = %#x, want 0", flags)
+	}
+	if flags := open(); flags != fuse.FOPEN_KEEP_CACHE {
+		t.Errorf("Open of an unchanged file flags = %#x, want FOPEN_KEEP_CACHE", flags)
+	}
+
+	// A change made outside the mount drops the cached data once
+	fsys.Chtimes("/dir/file", time.Now(), time.Now().Add(time.Hour))
+	if flags := open(); flags != 0 {
+		t.Errorf("Open of a changed file flags = %#x, want 0", flags)
+	}
+	if flags := open(); flags != fuse.FOPEN_KEEP_CACHE {
+		t.Errorf("second Open of a changed file flags = %#x, want FOPEN_KEEP_CACHE", flags)
+	}
+
+	// Direct I/O never keeps the cache
+	opts := *f.options()
+	opts.DirectIO = true
+	f.opts.Store(&opts)
+	if flags := open(); flags != fuse.FOPEN_DIRECT_IO {
+		t.Errorf("Open with DirectIO flags = %#x, want FOPEN_DIRECT_IO", flags)
+	}
+}
+
+func TestMountOptions_DirectIORules(t *testing.T) {
+	opts := DefaultMountOptions("/mnt")
+	opts.DirectIO = true
+	opts.DirectIORules = []DirectIORule{
+		{Pattern: "/static/*", DirectIO: false},
+		{Pattern: "*.log", DirectIO: true},
+		{Pattern: "*.css", DirectIO: false},
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/static/app.log", false},
+		{"/var/app.log", true},
+		{"/site/style.css", false},
+		{"/static/css/style.css", false},
+		{"/data.bin", true},
+	}
+	for _, tt := range tests {
+		if got := opts.directIO(tt.path); got != tt.want {
+			t.Errorf("directIO(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	opts.DirectIORules = append(opts.DirectIORules, DirectIORule{Pattern: "[a-"})
+	if err := opts.Validate(); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("Validate with a malformed pattern = %v, want ErrInvalid", err)
+	}
+}
+
+func TestOpen_DirectIORules(t *testing.T) {
+	fsys := newMemFS(t)
+	fsys.MkdirAll("/projects/foo/logs", 0755)
+	for _, name := range []string{"/projects/foo/logs/app.log", "/projects/foo/index.html"} {
+		file, _ := fsys.Create(name)
+		file.Close()
+	}
+
+	// Patterns are matched against paths within the mount, below Root
+	opts := DefaultMountOptions("/mnt")
+	opts.Root = "/projects/foo"
+	opts.DirectIORules = []DirectIORule{{Pattern: "/logs/*", DirectIO: true}}
+	f := newFuseFS(fsys, opts)
+
+	tests := []struct {
+		name string
+		want uint32
+	}{
+		{"/projects/foo/logs/app.log", fuse.FOPEN_DIRECT_IO},
+		{"/projects/foo/index.html", 0},
+	}
+	for _, tt := range tests {
+		fh := openTestHandle(t, f, tt.name, os.O_RDONLY)
+		file := f.handleTracker.Get(fh.handle)
+		if flags := fh.node.openFlags(context.Background(), file); flags != tt.want {
+			t.Errorf("openFlags(%s) = %#x, want %#x", tt.name, flags, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package fusefs
+
+import (
+	"context"
+	"log/slog"
+	"syscall"
+
+	"github.com/absfs/absfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// fdFile is implemented by absfs files backed by a host file descriptor,
+// such as *os.File
+type fdFile interface {
+	Fd() uintptr
+}
+
+// passthroughFileHandle is an open file read and written by the kernel
+// through passthrough. go-fuse hands the backing file of an inode to
+// every open returning a FilePassthroughFder, so other opens return a
+// plain fuseFileHandle.
+type passthroughFileHandle struct {
+	*fuseFileHandle
+}
+
+var _ fs.FilePassthroughFder = (*passthroughFileHandle)(nil)
+var _ fs.FileReleaser = (*passthroughFileHandle)(nil)
+
+// PassthroughFd returns the host file descriptor of the handle's file, for
+// the kernel to read and write it directly. go-fuse calls it when the file
+// is opened and no other handle of the inode has registered a backing
+// file yet.
+func (fh *passthroughFileHandle) PassthroughFd() (int, bool) {
+	file, ok := fh.node.fusefs.handleTracker.Get(fh.handle).(fdFile)
+	if !ok {
+		return 0, false
+	}
+	return int(file.Fd()), true
+}
+
+// Release closes the file, ending passthrough for the inode once its
+// last passthrough handle is released
+func (fh *passthroughFileHandle) Release(ctx context.Context) syscall.Errno {
+	defer fh.node.passthroughHandles.Add(-1)
+	return fh.fuseFileHandle.Release(ctx)
+}
+
+// fileHandle returns the fuseFileHandle of a handle returned by Open or
+// Create
+func fileHandle(f fs.FileHandle) (*fuseFileHandle, bool) {
+	switch fh := f.(type) {
+	case *fuseFileHandle:
+		return fh, true
+	case *passthroughFileHandle:
+		return fh.fuseFileHandle, true
+	}
+	return nil, false
+}
+
+// openHandle returns the handle and FOPEN flags for file, opened from the
+// node with the flags fuseFlags. With MountOptions.Passthrough set it
+// counts the open and decides passthrough per inode: the kernel fails
+// opens without passthrough while the inode has a backing file, so once
+// an open goes passthrough every open does until all are released, and
+// otherwise files opened with direct I/O never get a backing file.
+func (n *fuseNode) openHandle(file absfs.File, handle uint64, fuseFlags uint32) (fs.FileHandle, uint32) {
+	fh := &fuseFileHandle{node: n, handle: handle}
+	if !n.fusefs.options().Passthrough {
+		return fh, fuseFlags
+	}
+
+	_, ok := file.(fdFile)
+	if !ok || !n.fusefs.passthroughOK {
+		n.fusefs.stats.passthroughFallbacks.Add(1)
+		return fh, fuseFlags
+	}
+	// Decide and claim in one step, so that a direct I/O open never
+	// misses a concurrent open that gives the inode a backing file
+	for {
+		count := n.passthroughHandles.Load()
+		if count == 0 && fuseFlags&fuse.FOPEN_DIRECT_IO != 0 {
+			// Direct I/O is left to fusefs, which then sees every
+			// read and write
+			n.fusefs.stats.passthroughFallbacks.Add(1)
+			return fh, fuseFlags
+		}
+		if n.passthroughHandles.CompareAndSwap(count, count+1) {
+			break
+		}
+	}
+
+	// fusefs never sees the writes, so the handle stays dirty for
+	// UnmountContext to sync
+	n.fusefs.handleTracker.MarkPassthrough(handle)
+	n.fusefs.stats.passthroughOpens.Add(1)
+	return &passthroughFileHandle{fh}, fuseFlags &^ fuse.FOPEN_DIRECT_IO
+}
+
+// checkPassthrough records whether the kernel negotiated passthrough
+// when the filesystem was mounted
+func (f *FuseFS) checkPassthrough() {
+	f.passthroughOK = f.server.KernelSettings().Flags64()&fuse.CAP_PASSTHROUGH != 0
+	if f.options().Passthrough && !f.passthroughOK && f.logEnabled(context.Background(), slog.LevelWarn) {
+		f.options().Logger.Warn("kernel passthrough unavailable, using normal I/O")
+	}
+}
@@ -0,0 +1,256 @@
+package fusefs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// hostFS opens the files of a host directory, like an osfs backend
+type hostFS struct {
+	*memfs.FileSystem
+	dir string
+}
+
+func (h *hostFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	return os.OpenFile(filepath.Join(h.dir, name), flag, perm)
+}
+
+// newPassthroughTestFS returns a FuseFS with Passthrough over a host
+// directory holding /file, and a kernel that supports passthrough, and
+// the node of /file
+func newPassthroughTestFS(t *testing.T) (*FuseFS, *fuseNode) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultMountOptions("/mnt")
+	opts.Passthrough = true
+	f := newFuseFS(&hostFS{FileSystem: newMemFS(t), dir: dir}, opts)
+	f.passthroughOK = true
+	return f, newFuseNode(f, f.root.backend, "/file")
+}
+
+// openPassthrough opens the file of node as the kernel would
+func openPassthrough(t *testing.T, node *fuseNode) (fs.FileHandle, uint32) {
+	t.Helper()
+	fh, fuseFlags, errno := node.Open(context.Background(), uint32(os.O_RDWR))
+	if errno != 0 {
+		t.Fatalf("Open = %v", errno)
+	}
+	t.Cleanup(func() { fh.(fs.FileReleaser).Release(context.Background()) })
+	return fh, fuseFlags
+}
+
+// setDirectIO opens /file of f with direct I/O from now on
+func setDirectIO(f *FuseFS) {
+	opts := *f.options()
+	opts.DirectIORules = []DirectIORule{{Pattern: "file", DirectIO: true}}
+	f.opts.Store(&opts)
+}
+
+// passthroughFd returns the descriptor go-fuse would register as the
+// backing file of fh, if fh may have one
+func passthroughFd(fh fs.FileHandle) (int, bool) {
+	pth, ok := fh.(fs.FilePassthroughFder)
+	if !ok {
+		return 0, false
+	}
+	return pth.PassthroughFd()
+}
+
+func TestPassthrough_HostFile(t *testing.T) {
+	f, node := newPassthroughTestFS(t)
+	fh, _ := openPassthrough(t, node)
+
+	fd, ok := passthroughFd(fh)
+	handle, _ := fileHandle(fh)
+	file := f.handleTracker.Get(handle.handle).(*os.File)
+	if !ok || fd != int(file.Fd()) {
+		t.Errorf("PassthroughFd = %d, %v, want %d, true", fd, ok, file.Fd())
+	}
+
+	stats := f.Stats()
+	if stats.PassthroughOpens != 1 || stats.PassthroughFallbacks != 0 {
+		t.Errorf("passthrough opens, fallbacks = %d, %d, want 1, 0", stats.PassthroughOpens, stats.PassthroughFallbacks)
+	}
+}
+
+func TestPassthrough_Fallback(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(f *FuseFS)
+	}{
+		{"NoFd", func(f *FuseFS) {
+			var fsys absfs.FileSystem = newMemFS(t)
+			file, _ := fsys.Create("/file")
+			file.Close()
+			f.root.backend.fsys.Store(&fsys)
+		}},
+		{"DirectIO", setDirectIO},
+		{"Unsupported", func(f *FuseFS) {
+			f.passthroughOK = false
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, node := newPassthroughTestFS(t)
+			tt.setup(f)
+			fh, fuseFlags := openPassthrough(t, node)
+
+			if _, ok := passthroughFd(fh); ok {
+				t.Error("PassthroughFd = true")
+			}
+			if fuseFlags&fuse.FOPEN_PASSTHROUGH != 0 {
+				t.Errorf("Open flags = %#x", fuseFlags)
+			}
+			stats := f.Stats()
+			if stats.PassthroughOpens != 0 || stats.PassthroughFallbacks != 1 {
+				t.Errorf("passthrough opens, fallbacks = %d, %d, want 0, 1", stats.PassthroughOpens, stats.PassthroughFallbacks)
+			}
+		})
+	}
+}
+
+func TestPassthrough_Disabled(t *testing.T) {
+	f, node := newPassthroughTestFS(t)
+	opts := *f.options()
+	opts.Passthrough = false
+	f.opts.Store(&opts)
+
+	fh, _ := openPassthrough(t, node)
+	if _, ok := passthroughFd(fh); ok {
+		t.Error("PassthroughFd = true without Passthrough")
+	}
+	if stats := f.Stats(); stats.PassthroughOpens+stats.PassthroughFallbacks != 0 {
+		t.Errorf("passthrough opens, fallbacks = %d, %d without Passthrough", stats.PassthroughOpens, stats.PassthroughFallbacks)
+	}
+}
+
+func TestPassthrough_MixedModes(t *testing.T) {
+	f, node := newPassthroughTestFS(t)
+	open := func() (fs.FileHandle, uint32) {
+		t.Helper()
+		fh, fuseFlags, errno := node.Open(context.Background(), uint32(os.O_RDWR))
+		if errno != 0 {
+			t.Fatalf("Open = %v", errno)
+		}
+		return fh, fuseFlags
+	}
+
+	// A direct I/O open never gets a backing file, as go-fuse would hand
+	// it to every later open of the inode
+	setDirectIO(f)
+	direct, fuseFlags := open()
+	if _, ok := direct.(fs.FilePassthroughFder); ok || fuseFlags != fuse.FOPEN_DIRECT_IO {
+		t.Errorf("direct I/O open = %T with flags %#x, want a handle without a backing file", direct, fuseFlags)
+	}
+
+	opts := *f.options()
+	opts.DirectIORules = nil
+	f.opts.Store(&opts)
+	cached, _ := open()
+	if _, ok := passthroughFd(cached); !ok {
+		t.Error("PassthroughFd of the cached open = false")
+	}
+
+	// While the inode has a backing file the kernel fails opens without
+	// it, so a direct I/O open uses passthrough too
+	setDirectIO(f)
+	joined, fuseFlags := open()
+	if _, ok := passthroughFd(joined); !ok || fuseFlags&fuse.FOPEN_DIRECT_IO != 0 {
+		t.Errorf("direct I/O open of a passthrough inode = %T with flags %#x, want passthrough", joined, fuseFlags)
+	}
+
+	// Passthrough ends with the last handle using it
+	for _, fh := range []fs.FileHandle{direct, cached, joined} {
+		fh.(fs.FileReleaser).Release(context.Background())
+	}
+	reopened, _ := open()
+	defer reopened.(fs.FileReleaser).Release(context.Background())
+	if _, ok := reopened.(fs.FilePassthroughFder); ok {
+		t.Error("direct I/O open after releasing the passthrough handles has a backing file")
+	}
+
+	stats := f.Stats()
+	if stats.PassthroughOpens != 2 || stats.PassthroughFallbacks != 2 {
+		t.Errorf("passthrough opens, fallbacks = %d, %d, want 2, 2", stats.PassthroughOpens, stats.PassthroughFallbacks)
+	}
+}
+
+func TestPassthrough_ConcurrentOpens(t *testing.T) {
+	f, node := newPassthroughTestFS(t)
+	name := filepath.Join((*f.root.backend.fsys.Load()).(*hostFS).dir, "file")
+	open := func(fuseFlags uint32) (fs.FileHandle, uint32) {
+		file, err := os.OpenFile(name, os.O_RDWR, 0)
+		if err != nil {
+			t.Error(err)
+			return nil, 0
+		}
+		handle := f.handleTracker.Add(file, os.O_RDWR, "/file")
+		return node.openHandle(file, handle, fuseFlags)
+	}
+
+	// Opens with and without direct I/O race with each other and with
+	// releases, and a direct I/O open may only skip passthrough while
+	// no handle of the inode uses it
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(fuseFlags uint32) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				fh, got := open(fuseFlags)
+				if fh == nil {
+					return
+				}
+				if _, ok := fh.(*passthroughFileHandle); ok && got&fuse.FOPEN_DIRECT_IO != 0 {
+					t.Errorf("passthrough open with flags %#x", got)
+				}
+				fh.(fs.FileReleaser).Release(context.Background())
+			}
+		}(uint32(i%2) * fuse.FOPEN_DIRECT_IO)
+	}
+	wg.Wait()
+
+	if n := node.passthroughHandles.Load(); n != 0 {
+		t.Errorf("passthrough handles after releasing all = %d, want 0", n)
+	}
+	if stats := f.Stats(); stats.PassthroughOpens+stats.PassthroughFallbacks != 800 {
+		t.Errorf("passthrough opens + fallbacks = %d, want 800", stats.PassthroughOpens+stats.PassthroughFallbacks)
+	}
+}
+
+func TestPassthrough_DrainSyncs(t *testing.T) {
+	f, node := newPassthroughTestFS(t)
+	writable, _ := openPassthrough(t, node)
+	readOnly, _, errno := node.Open(context.Background(), uint32(os.O_RDONLY))
+	if errno != 0 {
+		t.Fatalf("Open = %v", errno)
+	}
+	defer readOnly.(fs.FileReleaser).Release(context.Background())
+
+	// The kernel writes passthrough files without fusefs, so a writable
+	// one stays dirty even after a sync
+	w, _ := fileHandle(writable)
+	if err := f.handleTracker.Sync(w.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := f.UnmountContext(context.Background())
+	if err != nil {
+		t.Fatalf("UnmountContext: %v", err)
+	}
+	if len(report.Flushed) != 1 || report.Flushed[0].ID != w.handle {
+		t.Errorf("flushed = %+v, want only handle %d", report.Flushed, w.handle)
+	}
+}
@@ -0,0 +1,69 @@
+package fusefs
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Reconfigure changes the options of a mounted filesystem. fn is called
+// with a copy of the current options to modify; the following changes take
+// effect immediately:
+//   - AttrCacheTTL and DirCacheTTL, including for entries already cached
+//   - MaxCachedInodes and MaxCachedDirs; shrinking a cache evicts its
+//     least recently used entries at once
+//   - AttrTimeout and EntryTimeout, for replies sent to the kernel from now
+//     on. Entries the kernel has already cached keep their timeout; write
+//     to the control directory's flush file to drop them.
+//   - UID, GID, SlowOpThreshold and Logger
+//   - DirectIORules, for files opened from now on
+//
+// Other options are fixed when the filesystem is mounted and changes to
+// them are ignored. If the new options are invalid, an error is returned
+// and nothing is changed.
+//
+// Example, to diagnose stale reads:
+//
+//	err := fuseFS.Reconfigure(func(opts *fusefs.MountOptions) {
+//	    opts.AttrCacheTTL = 0
+//	    opts.AttrTimeout = 0
+//	})
+func (f *FuseFS) Reconfigure(fn func(opts *MountOptions)) error {
+	f.reconfigureMu.Lock()
+	defer f.reconfigureMu.Unlock()
+
+	cur := f.options()
+	requested := *cur
+	fn(&requested)
+
+	next := *cur
+	next.AttrCacheTTL = requested.AttrCacheTTL
+	next.DirCacheTTL = requested.DirCacheTTL
+	next.MaxCachedInodes = requested.MaxCachedInodes
+	next.MaxCachedDirs = requested.MaxCachedDirs
+	next.AttrTimeout = requested.AttrTimeout
+	next.EntryTimeout = requested.EntryTimeout
+	next.UID = requested.UID
+	next.GID = requested.GID
+	next.SlowOpThreshold = requested.SlowOpThreshold
+	next.Logger = requested.Logger
+	next.DirectIORules = requested.DirectIORules
+
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	f.configureCaches(&next)
+	f.opts.Store(&next)
+
+	if f.logEnabled(context.Background(), slog.LevelInfo) {
+		next.Logger.Info("filesystem reconfigured",
+			slog.String("mountpoint", next.Mountpoint),
+			slog.Duration("attr_cache_ttl", next.AttrCacheTTL),
+			slog.Duration("dir_cache_ttl", next.DirCacheTTL),
+			slog.Int("max_cached_inodes", next.MaxCachedInodes),
+			slog.Int("max_cached_dirs", next.MaxCachedDirs),
+			slog.Duration("attr_timeout", next.AttrTimeout),
+			slog.Duration("entry_timeout", next.EntryTimeout))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestFuseFS_Reconfigure(t *testing.T) {
+	opts := DefaultMountOptions("/tmp/fusefs-test")
+	f := newFuseFS(nil, opts)
+	im := f.backends[0].inodes
+
+	for i := range 10 {
+		im.Cache("/file"+strconv.Itoa(i), &fuse.Attr{Ino: uint64(i)})
+	}
+
+	err := f.Reconfigure(func(o *MountOptions) {
+		o.AttrCacheTTL = time.Minute
+		o.DirCacheTTL = 2 * time.Minute
+		o.MaxCachedInodes = 4
+		o.AttrTimeout = 0
+		o.EntryTimeout = 3 * time.Second
+		o.Mountpoint = "/elsewhere" // fixed at mount time
+	})
+	if err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+
+	if im.AttrTTL() != time.Minute || im.DirTTL() != 2*time.Minute {
+		t.Errorf("cache TTLs = %v/%v, want 1m/2m", im.AttrTTL(), im.DirTTL())
+	}
+	if stats := im.Stats().AttrCache; stats.Size != 4 || stats.MaxSize != 4 {
+		t.Errorf("attr cache = %+v, want 4 of at most 4 entries", stats)
+	}
+
+	cur := f.options()
+	if cur.AttrTimeout != 0 || cur.EntryTimeout != 3*time.Second {
+		t.Errorf("timeouts = %v/%v, want 0/3s", cur.AttrTimeout, cur.EntryTimeout)
+	}
+	if cur.Mountpoint != "/tmp/fusefs-test" {
+		t.Errorf("Mountpoint = %q, want it unchanged", cur.Mountpoint)
+	}
+	if opts.AttrCacheTTL != 5*time.Second {
+		t.Error("Reconfigure modified the caller's options")
+	}
+
+	// New replies use the new kernel timeouts
+	fs.NewNodeFS(f.rootEmbedder(), &fs.Options{})
+	var out fuse.EntryOut
+	f.root.lookupControl(context.Background(), &out)
+	if out.AttrTimeout() != 0 || out.EntryTimeout() != 3*time.Second {
+		t.Errorf("reply timeouts = %v/%v, want 0/3s", out.AttrTimeout(), out.EntryTimeout())
+	}
+}
+
+func TestFuseFS_ReconfigureInvalid(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+	before := f.options()
+
+	err := f.Reconfigure(func(o *MountOptions) {
+		o.AttrCacheTTL = time.Minute
+		o.DirCacheTTL = -time.Second
+	})
+	if !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("Reconfigure error = %v, want ErrInvalid", err)
+	}
+	if f.options() != before || f.backends[0].inodes.AttrTTL() != 5*time.Second {
+		t.Error("invalid Reconfigure changed the options")
+	}
+}
@@ -0,0 +1,196 @@
+package fusefs
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// RenameNoReplacer is an optional interface that absfs filesystems can
+// implement to support renameat2(RENAME_NOREPLACE) atomically.
+//
+// Without it, fusefs checks that the target does not exist before
+// renaming, which other users of the backend may race with.
+type RenameNoReplacer interface {
+	// RenameNoReplace renames oldpath to newpath like Rename, but fails
+	// with an error matching os.ErrExist if newpath exists
+	RenameNoReplace(oldpath, newpath string) error
+}
+
+// Exchanger is an optional interface that absfs filesystems can implement
+// to support renameat2(RENAME_EXCHANGE). Without it, exchanges fail with
+// EINVAL.
+type Exchanger interface {
+	// Exchange atomically swaps the files or directories at path1 and
+	// path2, which must both exist
+	Exchange(path1, path2 string) error
+}
+
+// renameat2(2) flags (from <linux/fs.h>)
+const (
+	renameNoReplace = 0x1
+	renameExchange  = 0x2
+)
+
+// dirLocks serializes the renames into each directory of a backend, so
+// that a RENAME_NOREPLACE emulated with a check does not race with other
+// renames of the mount; the kernel serializes creations in a directory
+// with renames into it. Directories share a fixed number of locks by the
+// hash of their path.
+type dirLocks [64]sync.Mutex
+
+// lock locks the directory at path and returns the function unlocking it
+func (l *dirLocks) lock(path string) func() {
+	mu := &l[pathHash(path)%uint32(len(l))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// pathHash returns the hash of path selecting its lock
+func pathHash(path string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return h.Sum32()
+}
+
+// Rename renames a file or directory
+func (n *fuseNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	op := newOp(ctx, OpRename, n.childPath(name))
+	if newParentNode, ok := newParent.(*fuseNode); ok {
+		op.Target = newParentNode.childPath(newName)
+	}
+	return n.fusefs.intercept(ctx, op, func(ctx context.Context) syscall.Errno {
+		return n.rename(ctx, name, newParent, newName, flags)
+	})
+}
+
+// rename implements Rename
+func (n *fuseNode) rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags&^(renameNoReplace|renameExchange) != 0 || flags == renameNoReplace|renameExchange {
+		return syscall.EINVAL
+	}
+
+	// Build paths
+	oldPath := n.childPath(name)
+
+	// Renames between backends, or into synthesized directories, are
+	// cross-device
+	newParentNode, ok := newParent.(*fuseNode)
+	if !ok || newParentNode.backend != n.backend {
+		return syscall.EXDEV
+	}
+	newPath := newParentNode.childPath(newName)
+
+	// The control directory and the rename journal are neither moved nor
+	// replaced
+	if n.isControlName(name) || newParentNode.isControlName(newName) ||
+		n.isJournalName(name) || newParentNode.isJournalName(newName) {
+		return syscall.EPERM
+	}
+
+	unlock := n.backend.dirLocks.lock(newParentNode.path())
+	defer unlock()
+
+	// Rename through absfs, copying directories the backend cannot
+	// rename if so configured
+	start := time.Now()
+	err := n.renamePath(oldPath, newPath, flags)
+	if isRenameUnsupported(err) && n.emulatesRename(oldPath, flags) {
+		err = n.backend.emulateDirRename(n.fusefs, oldPath, newPath, flags&renameNoReplace != 0)
+	}
+	timeBackend(ctx, start)
+	if err != nil {
+		if isRenameUnsupported(err) {
+			// Makes mv(1) and similar tools fall back to copying
+			return syscall.EXDEV
+		}
+		return n.fusefs.backendError(ctx, err)
+	}
+
+	// Nodes and cached data follow the renamed files; go-fuse moves the
+	// inodes in its tree once the rename returns
+	moved := n.GetChild(name)
+	exchanged := newParentNode.GetChild(newName)
+	if flags&renameExchange != 0 {
+		n.backend.inodes.Exchange(oldPath, newPath)
+		movePaths(exchanged, newPath, oldPath)
+	} else {
+		n.backend.inodes.Rename(oldPath, newPath)
+	}
+	movePaths(moved, oldPath, newPath)
+
+	return 0
+}
+
+// renamePath renames oldPath to newPath in the backend as selected by the
+// renameat2 flags
+func (n *fuseNode) renamePath(oldPath, newPath string, flags uint32) error {
+	fsys := n.backend.fs()
+
+	switch {
+	case flags&renameExchange != 0:
+		exchanger, ok := fsys.(Exchanger)
+		if !ok {
+			return syscall.EINVAL
+		}
+		return exchanger.Exchange(oldPath, newPath)
+
+	case flags&renameNoReplace != 0:
+		if renamer, ok := fsys.(RenameNoReplacer); ok {
+			return renamer.RenameNoReplace(oldPath, newPath)
+		}
+
+		// A dangling symbolic link exists too
+		stat := fsys.Stat
+		if lstatFS, ok := fsys.(interface {
+			Lstat(name string) (os.FileInfo, error)
+		}); ok {
+			stat = lstatFS.Lstat
+		}
+		if _, err := stat(newPath); err == nil {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrExist}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return fsys.Rename(oldPath, newPath)
+}
+
+// emulatesRename reports whether the rename of oldPath, which the backend
+// does not support, is emulated by copying, see
+// MountOptions.EmulateDirRename
+func (n *fuseNode) emulatesRename(oldPath string, flags uint32) bool {
+	if !n.fusefs.options().EmulateDirRename || flags&renameExchange != 0 {
+		return false
+	}
+	info, err := lstat(n.backend.fs(), oldPath)
+	return err == nil && info.IsDir()
+}
+
+// movePaths changes the paths of the node of inode and the loaded nodes
+// beneath it from oldPath to newPath
+func movePaths(inode *fs.Inode, oldPath, newPath string) {
+	if inode == nil {
+		return
+	}
+	n, ok := inode.Operations().(*fuseNode)
+	if !ok {
+		return
+	}
+
+	p := n.path()
+	if p == oldPath || strings.HasPrefix(p, oldPath+"/") {
+		p = newPath + p[len(oldPath):]
+		n.absPath.Store(&p)
+	}
+	for _, child := range inode.Children() {
+		movePaths(child, oldPath, newPath)
+	}
+}
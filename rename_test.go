@@ -0,0 +1,123 @@
+package fusefs
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// newRenameTestFS returns a FuseFS over fsys holding /dir/file and /other,
+// with the nodes of /dir and /dir/file looked up
+func newRenameTestFS(t *testing.T, fsys absfs.FileSystem) (f *FuseFS, dir, file *fuseNode) {
+	t.Helper()
+	fsys.Mkdir("/dir", 0755)
+	for _, name := range []string{"/dir/file", "/other"} {
+		created, err := fsys.Create(name)
+		if err != nil {
+			t.Fatalf("Create(%q): %v", name, err)
+		}
+		created.Write([]byte(name))
+		created.Close()
+	}
+
+	f = newFuseFS(fsys, DefaultMountOptions("/mnt"))
+	fs.NewNodeFS(f.rootEmbedder(), &fs.Options{})
+
+	lookup := func(parent *fuseNode, name string) *fuseNode {
+		inode, errno := parent.Lookup(context.Background(), name, &fuse.EntryOut{})
+		if errno != 0 {
+			t.Fatalf("Lookup(%q): %v", name, errno)
+		}
+		parent.AddChild(name, inode, true)
+		return inode.Operations().(*fuseNode)
+	}
+	dir = lookup(f.root, "dir")
+	return f, dir, lookup(dir, "file")
+}
+
+// plainFS hides the optional interfaces of a filesystem
+type plainFS struct {
+	absfs.FileSystem
+}
+
+func TestRename_MovesNodes(t *testing.T) {
+	f, dir, file := newRenameTestFS(t, newMemFS(t))
+	ino := f.root.backend.inodes.pathToInode["/dir/file"]
+
+	if errno := f.root.Rename(context.Background(), "dir", f.root, "moved", 0); errno != 0 {
+		t.Fatalf("Rename = %v", errno)
+	}
+	if dir.path() != "/moved" || file.path() != "/moved/file" {
+		t.Errorf("paths after rename = %q, %q, want /moved, /moved/file", dir.path(), file.path())
+	}
+	if moved := f.root.backend.inodes.pathToInode["/moved/file"]; moved != ino {
+		t.Errorf("inode of /moved/file = %d, want %d", moved, ino)
+	}
+
+	// The moved node still opens its file
+	if _, _, errno := file.Open(context.Background(), uint32(os.O_RDONLY)); errno != 0 {
+		t.Errorf("Open after rename = %v", errno)
+	}
+}
+
+func TestRename_NoReplace(t *testing.T) {
+	for _, fsys := range []absfs.FileSystem{newMemFS(t), &plainFS{newMemFS(t)}} {
+		f, dir, _ := newRenameTestFS(t, fsys)
+
+		if errno := f.root.Rename(context.Background(), "other", dir, "file", renameNoReplace); errno != syscall.EEXIST {
+			t.Errorf("%T: Rename(NOREPLACE) over a file = %v, want EEXIST", fsys, errno)
+		}
+		if data, _ := fsys.ReadFile("/dir/file"); string(data) != "/dir/file" {
+			t.Errorf("%T: /dir/file = %q after a failed rename", fsys, data)
+		}
+		if errno := f.root.Rename(context.Background(), "other", dir, "new", renameNoReplace); errno != 0 {
+			t.Errorf("%T: Rename(NOREPLACE) = %v", fsys, errno)
+		}
+	}
+}
+
+// exchangeFS is a filesystem that exchanges files, like a host
+// filesystem with renameat2(RENAME_EXCHANGE)
+type exchangeFS struct {
+	*memfs.FileSystem
+}
+
+func (fsys *exchangeFS) Exchange(path1, path2 string) error {
+	tmp := path1 + ".exchange"
+	if err := fsys.Rename(path1, tmp); err != nil {
+		return err
+	}
+	if err := fsys.Rename(path2, path1); err != nil {
+		return err
+	}
+	return fsys.Rename(tmp, path2)
+}
+
+func TestRename_Exchange(t *testing.T) {
+	f, dir, file := newRenameTestFS(t, &exchangeFS{newMemFS(t)})
+
+	if errno := f.root.Rename(context.Background(), "other", dir, "file", renameExchange); errno != 0 {
+		t.Fatalf("Rename(EXCHANGE) = %v", errno)
+	}
+	if file.path() != "/other" {
+		t.Errorf("exchanged node path = %q, want /other", file.path())
+	}
+	if data, _ := f.root.backend.fs().ReadFile("/other"); string(data) != "/dir/file" {
+		t.Errorf("/other = %q after exchange, want /dir/file", data)
+	}
+
+	// Without backend support exchanges are invalid
+	f, dir, _ = newRenameTestFS(t, &plainFS{newMemFS(t)})
+	if errno := f.root.Rename(context.Background(), "other", dir, "file", renameExchange); errno != syscall.EINVAL {
+		t.Errorf("Rename(EXCHANGE) without Exchanger = %v, want EINVAL", errno)
+	}
+	if errno := f.root.Rename(context.Background(), "other", dir, "file", 0x4); errno != syscall.EINVAL {
+		t.Errorf("Rename(WHITEOUT) = %v, want EINVAL", errno)
+	}
+}
@@ -0,0 +1,188 @@
+package fusefs
+
+import (
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// maxSymlinks is the number of symbolic links followed when resolving a
+// path before giving up with ELOOP, as on Linux
+const maxSymlinks = 40
+
+// cleanRoot returns the absolute, cleaned form of MountOptions.Root
+func cleanRoot(root string) string {
+	return path.Clean("/" + root)
+}
+
+// confined reports whether the backend is confined to a subdirectory of the
+// backend
+func (b *backend) confined() bool {
+	return b.rootPath != "/"
+}
+
+// confine clamps p, a cleaned absolute backend path, to the mount root.
+// Paths that escape the root through ".." resolve to the root itself, as
+// ".." does in the root directory of a chroot.
+func (b *backend) confine(p string) string {
+	if !b.confined() || p == b.rootPath || strings.HasPrefix(p, b.rootPath+"/") {
+		return p
+	}
+	return b.rootPath
+}
+
+// childPath returns the backend path of name in directory n, confined
+// beneath the mount root
+func (n *fuseNode) childPath(name string) string {
+	return n.backend.confine(path.Join(n.path(), name))
+}
+
+// resolve follows symbolic links in name, a path relative to dir, keeping
+// the result beneath the mount root. Absolute link targets are interpreted
+// relative to the root; targets that already name a path beneath the root
+// are used as they are.
+//
+// The backend follows symbolic links itself in Stat and Open, so without
+// this a link could reach files outside the root.
+func (b *backend) resolve(dir, name string) (string, error) {
+	linker, ok := b.fs().(interface {
+		Lstat(name string) (os.FileInfo, error)
+		Readlink(name string) (string, error)
+	})
+	if !ok {
+		return b.confine(path.Join(dir, name)), nil
+	}
+
+	current := dir
+	rest := strings.Split(name, "/")
+	links := 0
+	for len(rest) > 0 {
+		component := rest[0]
+		rest = rest[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			current = b.confine(path.Dir(current))
+			continue
+		}
+
+		next := path.Join(current, component)
+		info, err := linker.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", syscall.ELOOP
+		}
+		target, err := linker.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			current = b.rootPath
+			target = b.rootRelative(target)
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+
+	return current, nil
+}
+
+// resolveCreate returns the backend path at which to create name in dir.
+// The backend follows a symbolic link at name to create its target, even
+// a target outside the mount root, so such a link is resolved within the
+// root instead, up to its missing final component.
+func (b *backend) resolveCreate(dir, name string) (string, error) {
+	p := b.confine(path.Join(dir, name))
+	linker, ok := b.fs().(interface {
+		Lstat(name string) (os.FileInfo, error)
+		Readlink(name string) (string, error)
+	})
+	if !ok {
+		return p, nil
+	}
+
+	for links := 0; ; links++ {
+		info, err := linker.Lstat(p)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// A missing name is created, other errors are left to the
+			// backend
+			return p, nil
+		}
+		if links == maxSymlinks {
+			return "", syscall.ELOOP
+		}
+
+		target, err := linker.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		linkDir := path.Dir(p)
+		if path.IsAbs(target) {
+			linkDir = b.rootPath
+			target = b.rootRelative(target)
+		}
+		parent, err := b.resolve(linkDir, path.Dir(target))
+		if err != nil {
+			return "", err
+		}
+		p = b.confine(path.Join(parent, path.Base(target)))
+	}
+}
+
+// rootRelative returns an absolute symlink target relative to the mount
+// root: targets beneath the root have the root stripped, others are used
+// as they are
+func (b *backend) rootRelative(target string) string {
+	target = path.Clean(target)
+	if target == b.rootPath {
+		return "/"
+	}
+	if rel, ok := strings.CutPrefix(target, b.rootPath+"/"); ok {
+		return "/" + rel
+	}
+	return target
+}
+
+// relocated reports whether backend paths differ from host paths below the
+// mountpoint, so that absolute symlink targets need translating
+func (b *backend) relocated() bool {
+	return b.rootPath != "/" || b.mountPath != "/"
+}
+
+// linkTargetOut maps an absolute symlink target read from the backend to
+// the mounted view, so that it resolves inside the mount rather than on
+// the host
+func (b *backend) linkTargetOut(target string) string {
+	if !b.relocated() || !path.IsAbs(target) {
+		return target
+	}
+	return path.Join(b.hostPath, b.rootRelative(target))
+}
+
+// linkTargetIn maps an absolute symlink target given by a client to the
+// backend: targets inside the mountpoint are stored relative to the
+// backend root
+func (b *backend) linkTargetIn(target string) string {
+	if !b.relocated() || !path.IsAbs(target) {
+		return target
+	}
+
+	target = path.Clean(target)
+	if target == b.hostPath {
+		return b.rootPath
+	}
+	if rel, ok := strings.CutPrefix(target, b.hostPath+"/"); ok {
+		return path.Join(b.rootPath, rel)
+	}
+	return target
+}
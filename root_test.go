@@ -0,0 +1,231 @@
+package fusefs
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// linkFS is a backend of directories, files and symbolic links that
+// implements only the lookups used for path resolution
+type linkFS struct {
+	absfs.FileSystem
+	dirs  map[string]bool
+	files map[string]bool
+	links map[string]string
+}
+
+func (l *linkFS) Lstat(name string) (os.FileInfo, error) {
+	switch {
+	case l.dirs[name]:
+		return &mockFileInfo{name: path.Base(name), mode: os.ModeDir | 0755, isDir: true}, nil
+	case l.files[name]:
+		return &mockFileInfo{name: path.Base(name), mode: 0644}, nil
+	case l.links[name] != "":
+		return &mockFileInfo{name: path.Base(name), mode: os.ModeSymlink | 0777}, nil
+	}
+	return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+}
+
+func (l *linkFS) Stat(name string) (os.FileInfo, error) {
+	if l.links[name] != "" {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrInvalid}
+	}
+	return l.Lstat(name)
+}
+
+func (l *linkFS) Readlink(name string) (string, error) {
+	if target, ok := l.links[name]; ok {
+		return target, nil
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+}
+
+func newRootedFuseFS(root string) *FuseFS {
+	backend := &linkFS{
+		dirs: map[string]bool{
+			"/": true, "/etc": true, "/projects": true, "/projects/foo": true, "/projects/foo/sub": true,
+		},
+		files: map[string]bool{
+			"/etc/passwd": true, "/projects/foo/data": true, "/projects/foo/sub/file": true, "/projects/secret": true,
+		},
+		links: map[string]string{
+			"/projects/foo/abs":     "/etc/passwd",
+			"/projects/foo/inside":  "/projects/foo/data",
+			"/projects/foo/rel":     "sub/file",
+			"/projects/foo/up":      "../secret",
+			"/projects/foo/sub/dot": "../../../data",
+			"/projects/foo/loop":    "loop",
+			"/projects/foo/newin":   "sub/new",
+			"/projects/foo/newup":   "../new",
+			"/projects/foo/newabs":  "/etc/shadow",
+		},
+	}
+	opts := DefaultMountOptions("/mnt/foo")
+	opts.Root = root
+	return newFuseFS(backend, opts)
+}
+
+func TestConfine(t *testing.T) {
+	f := newRootedFuseFS("/projects/foo/")
+
+	if f.root.backend.rootPath != "/projects/foo" || f.root.path() != "/projects/foo" {
+		t.Fatalf("rootPath = %q, root node path = %q", f.root.backend.rootPath, f.root.path())
+	}
+
+	tests := []struct {
+		dir, name, want string
+	}{
+		{"/projects/foo", "a", "/projects/foo/a"},
+		{"/projects/foo", "..", "/projects/foo"},
+		{"/projects/foo/sub", "..", "/projects/foo"},
+		{"/projects/foo", "../foobar", "/projects/foo"},
+	}
+	for _, tt := range tests {
+		n := newFuseNode(f, f.root.backend, tt.dir)
+		if got := n.childPath(tt.name); got != tt.want {
+			t.Errorf("childPath(%q, %q) = %q, want %q", tt.dir, tt.name, got, tt.want)
+		}
+	}
+
+	if stats := f.Stats(); stats.Root != "/projects/foo" {
+		t.Errorf("Stats().Root = %q", stats.Root)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	f := newRootedFuseFS("/projects/foo")
+
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"data", "/projects/foo/data", nil},
+		{"inside", "/projects/foo/data", nil},
+		{"rel", "/projects/foo/sub/file", nil},
+		{"sub/dot", "/projects/foo/data", nil},
+		// Escapes are interpreted inside the root and do not exist there
+		{"abs", "", os.ErrNotExist},
+		{"up", "", os.ErrNotExist},
+		{"loop", "", syscall.ELOOP},
+	}
+	for _, tt := range tests {
+		got, err := f.root.backend.resolve("/projects/foo", tt.name)
+		if tt.err != nil {
+			if !os.IsNotExist(err) && err != tt.err {
+				t.Errorf("resolve(%q) = %q, %v, want error %v", tt.name, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolve(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveCreate(t *testing.T) {
+	f := newRootedFuseFS("/projects/foo")
+
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{"new", "/projects/foo/new", nil},
+		{"data", "/projects/foo/data", nil},
+		{"newin", "/projects/foo/sub/new", nil},
+		{"newup", "/projects/foo/new", nil},
+		// Dangling links out of the root are interpreted inside it
+		{"newabs", "", os.ErrNotExist},
+		{"abs", "", os.ErrNotExist},
+		{"loop", "", syscall.ELOOP},
+	}
+	for _, tt := range tests {
+		got, err := f.root.backend.resolveCreate("/projects/foo", tt.name)
+		if tt.err != nil {
+			if !os.IsNotExist(err) && err != tt.err {
+				t.Errorf("resolveCreate(%q) = %q, %v, want error %v", tt.name, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveCreate(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// hostLinkFS serves the files and symbolic links of a host directory, so
+// that the host follows links as an osfs backend would
+type hostLinkFS struct {
+	hostFS
+}
+
+func (h *hostLinkFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(filepath.Join(h.dir, name))
+}
+
+func (h *hostLinkFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(filepath.Join(h.dir, name))
+}
+
+func (h *hostLinkFS) Readlink(name string) (string, error) {
+	return os.Readlink(filepath.Join(h.dir, name))
+}
+
+func TestCreate_DanglingLinkOutOfRoot(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "root"), 0755)
+	os.Mkdir(filepath.Join(dir, "outside"), 0755)
+	escape := filepath.Join(dir, "outside", "new")
+	if err := os.Symlink(escape, filepath.Join(dir, "root", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultMountOptions("/mnt")
+	opts.Root = "/root"
+	f := newFuseFS(&hostLinkFS{hostFS{FileSystem: newMemFS(t), dir: dir}}, opts)
+	fs.NewNodeFS(f.rootEmbedder(), &fs.Options{})
+
+	_, fh, _, errno := f.root.Create(context.Background(), "link", uint32(os.O_RDWR), 0644, &fuse.EntryOut{})
+	if errno == 0 {
+		fh.(fs.FileReleaser).Release(context.Background())
+		t.Error("Create through a dangling link out of the root succeeded")
+	}
+	if _, err := os.Lstat(escape); !os.IsNotExist(err) {
+		t.Errorf("file created outside the root: %v", err)
+	}
+}
+
+func TestLinkTargets(t *testing.T) {
+	f := newRootedFuseFS("/projects/foo")
+
+	if got := f.root.backend.linkTargetOut("/projects/foo/data"); got != "/mnt/foo/data" {
+		t.Errorf("linkTargetOut(inside) = %q", got)
+	}
+	if got := f.root.backend.linkTargetOut("/etc/passwd"); got != "/mnt/foo/etc/passwd" {
+		t.Errorf("linkTargetOut(outside) = %q", got)
+	}
+	if got := f.root.backend.linkTargetOut("rel/target"); got != "rel/target" {
+		t.Errorf("linkTargetOut(relative) = %q", got)
+	}
+	if got := f.root.backend.linkTargetIn("/mnt/foo/sub/file"); got != "/projects/foo/sub/file" {
+		t.Errorf("linkTargetIn(mountpoint) = %q", got)
+	}
+
+	// Unconfined mounts pass targets through
+	g := newRootedFuseFS("")
+	if got := g.root.backend.linkTargetOut("/etc/passwd"); got != "/etc/passwd" {
+		t.Errorf("unconfined linkTargetOut = %q", got)
+	}
+	if got := g.root.childPath(".."); got != "/" {
+		t.Errorf("unconfined childPath(..) = %q", got)
+	}
+}
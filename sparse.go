@@ -0,0 +1,110 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/absfs/absfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+// SparseFile is an optional interface that absfs files can implement to
+// report their holes, so that tools such as cp --sparse, tar -S and
+// qemu-img can skip them with lseek(SEEK_DATA) and lseek(SEEK_HOLE).
+//
+// Files of the host filesystem (*os.File) report the holes of the host
+// file. Other files are treated as data throughout, with a single hole at
+// the end of the file.
+type SparseFile interface {
+	// SeekData returns the offset of the first data at or after offset.
+	// It returns an error matching syscall.ENXIO if only holes follow
+	// offset, or offset is at or beyond the end of the file.
+	SeekData(offset int64) (int64, error)
+
+	// SeekHole returns the offset of the first hole at or after offset,
+	// which is the size of the file if no hole follows offset. It returns
+	// an error matching syscall.ENXIO if offset is at or beyond the end of
+	// the file.
+	SeekHole(offset int64) (int64, error)
+}
+
+// Whence values of lseek in the FUSE protocol, which are those of Linux
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// Lseek finds data and holes in the file (lseek with SEEK_DATA or
+// SEEK_HOLE). The kernel serves the other whence values itself.
+func (fh *fuseFileHandle) Lseek(ctx context.Context, off uint64, whence uint32) (uint64, syscall.Errno) {
+	var result uint64
+	op := fh.newOp(ctx, OpLseek)
+	op.Offset = int64(off)
+	errno := fh.node.fusefs.intercept(ctx, op, func(ctx context.Context) (errno syscall.Errno) {
+		result, errno = fh.lseek(ctx, off, whence)
+		return errno
+	})
+	return result, errno
+}
+
+// lseek implements Lseek
+func (fh *fuseFileHandle) lseek(ctx context.Context, off uint64, whence uint32) (uint64, syscall.Errno) {
+	if whence != seekData && whence != seekHole {
+		return 0, syscall.EINVAL
+	}
+
+	file := fh.node.fusefs.handleTracker.Get(fh.handle)
+	if file == nil {
+		fh.node.fusefs.stats.recordError()
+		return 0, syscall.EBADF
+	}
+
+	start := time.Now()
+	result, err := seekSparse(file, int64(off), whence)
+	timeBackend(ctx, start)
+	if errors.Is(err, syscall.ENXIO) {
+		return 0, syscall.ENXIO
+	}
+	if err != nil {
+		return 0, fh.node.fusefs.backendError(ctx, err)
+	}
+
+	return uint64(result), 0
+}
+
+// seekSparse finds the next data or hole in file at or after off
+func seekSparse(file absfs.File, off int64, whence uint32) (int64, error) {
+	if sparse, ok := file.(SparseFile); ok {
+		if whence == seekData {
+			return sparse.SeekData(off)
+		}
+		return sparse.SeekHole(off)
+	}
+
+	if osFile, ok := file.(*os.File); ok {
+		// The whence values of the host may differ from the protocol's
+		hostWhence := unix.SEEK_DATA
+		if whence == seekHole {
+			hostWhence = unix.SEEK_HOLE
+		}
+		return osFile.Seek(off, hostWhence)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if off >= info.Size() {
+		return 0, syscall.ENXIO
+	}
+	if whence == seekData {
+		return off, nil
+	}
+	return info.Size(), nil
+}
+
+var _ fs.FileLseeker = (*fuseFileHandle)(nil)
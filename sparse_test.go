@@ -0,0 +1,115 @@
+package fusefs
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/absfs/absfs"
+	"github.com/absfs/memfs"
+)
+
+// holeFS is a memfs whose files have a hole from 4096 to 8192
+type holeFS struct {
+	*memfs.FileSystem
+}
+
+func (h *holeFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	file, err := h.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &holeFile{File: file}, nil
+}
+
+type holeFile struct {
+	absfs.File
+}
+
+func (h *holeFile) SeekData(offset int64) (int64, error) {
+	switch {
+	case offset >= 12288:
+		return 0, syscall.ENXIO
+	case offset >= 4096 && offset < 8192:
+		return 8192, nil
+	}
+	return offset, nil
+}
+
+func (h *holeFile) SeekHole(offset int64) (int64, error) {
+	switch {
+	case offset >= 12288:
+		return 0, syscall.ENXIO
+	case offset < 4096:
+		return 4096, nil
+	case offset < 8192:
+		return offset, nil
+	}
+	return 12288, nil
+}
+
+func TestLseek(t *testing.T) {
+	tests := []struct {
+		name   string
+		fsys   absfs.FileSystem
+		off    uint64
+		whence uint32
+		want   uint64
+		errno  syscall.Errno
+	}{
+		{"data", newMemFS(t), 100, seekData, 100, 0},
+		{"hole", newMemFS(t), 100, seekHole, 12288, 0},
+		{"data at EOF", newMemFS(t), 12288, seekData, 0, syscall.ENXIO},
+		{"hole at EOF", newMemFS(t), 12288, seekHole, 0, syscall.ENXIO},
+		{"SEEK_END", newMemFS(t), 0, 2, 0, syscall.EINVAL},
+		{"sparse data", &holeFS{newMemFS(t)}, 5000, seekData, 8192, 0},
+		{"sparse hole", &holeFS{newMemFS(t)}, 100, seekHole, 4096, 0},
+		{"sparse in hole", &holeFS{newMemFS(t)}, 5000, seekHole, 5000, 0},
+		{"sparse data at EOF", &holeFS{newMemFS(t)}, 20000, seekData, 0, syscall.ENXIO},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _ := newTestFileFS(t, tt.fsys, 12288)
+			fh := openTestHandle(t, f, "/src", os.O_RDONLY)
+
+			got, errno := fh.Lseek(context.Background(), tt.off, tt.whence)
+			if got != tt.want || errno != tt.errno {
+				t.Errorf("Lseek(%d, %d) = %d, %v, want %d, %v", tt.off, tt.whence, got, errno, tt.want, tt.errno)
+			}
+		})
+	}
+}
+
+func TestLseek_HostFile(t *testing.T) {
+	dir := t.TempDir()
+	file, err := os.Create(dir + "/sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt([]byte("end"), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFuseFS(newMemFS(t), DefaultMountOptions("/mnt"))
+	fh := &fuseFileHandle{
+		node:   newFuseNode(f, f.root.backend, "/sparse"),
+		handle: f.handleTracker.Add(file, os.O_RDWR, "/sparse"),
+	}
+
+	hole, errno := fh.Lseek(context.Background(), 0, seekHole)
+	if errno != 0 {
+		t.Fatalf("Lseek(SEEK_HOLE) = %v", errno)
+	}
+	data, errno := fh.Lseek(context.Background(), 0, seekData)
+	if errno != 0 {
+		t.Fatalf("Lseek(SEEK_DATA) = %v", errno)
+	}
+	if hole == 1<<20+3 && data == 0 {
+		t.Skip("the host filesystem does not report holes")
+	}
+	if hole != 0 || data == 0 || data > 1<<20 {
+		t.Errorf("hole at %d, data at %d, want a hole at 0 and data at most at %d", hole, data, 1<<20)
+	}
+}
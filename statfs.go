@@ -3,6 +3,7 @@ package fusefs
 import (
 	"context"
 	"syscall"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
@@ -37,18 +38,20 @@ type StatFSer interface {
 
 // Statfs returns filesystem statistics
 func (n *fuseNode) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
-	}
+	return n.fusefs.intercept(ctx, newOp(ctx, OpStatfs, n.path()), func(ctx context.Context) syscall.Errno {
+		return n.statfs(ctx, out)
+	})
+}
 
+// statfs implements Statfs
+func (n *fuseNode) statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
 	// Check if filesystem implements StatFSer
-	if statfser, ok := n.fusefs.absFS.(StatFSer); ok {
+	if statfser, ok := n.backend.fs().(StatFSer); ok {
+		start := time.Now()
 		total, free, avail, totalInodes, freeInodes, blockSize, nameMax, err := statfser.StatFS()
+		timeBackend(ctx, start)
 		if err != nil {
-			n.fusefs.stats.recordError()
-			return mapError(err)
+			return n.fusefs.backendError(ctx, err)
 		}
 
 		out.Blocks = total
@@ -1,7 +1,11 @@
 package fusefs
 
 import (
+	"context"
+	"math"
 	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // Stats contains runtime statistics about filesystem operations.
@@ -15,27 +19,317 @@ import (
 //	fmt.Printf("Operations: %d, Errors: %d\n", stats.Operations, stats.Errors)
 //	fmt.Printf("Read: %d bytes, Written: %d bytes\n", stats.BytesRead, stats.BytesWritten)
 //	fmt.Printf("Cache hit rate: %.2f%%\n", stats.InodeStats.AttrCache.HitRate*100)
+//	fmt.Printf("Lookup p99 bucket: %v\n", stats.Ops[fusefs.OpLookup].Latency.Quantile(0.99))
 type Stats struct {
 	Mountpoint   string
+	Root         string
 	Operations   uint64
 	BytesRead    uint64
 	BytesWritten uint64
 	Errors       uint64
 	OpenFiles    int
 	InodeStats   InodeManagerStats
+
+	// Ops contains per-operation statistics keyed by operation name
+	// (see the Op* constants). Operations that never ran are omitted.
+	Ops map[string]OpStats
+
+	// Errnos counts non-zero errno results returned to the kernel
+	Errnos map[syscall.Errno]uint64
+
+	// DirRenames reports directory renames emulated by copying, see
+	// MountOptions.EmulateDirRename
+	DirRenames DirRenameStats
+
+	// PassthroughOpens counts opens handed to the kernel for passthrough,
+	// and PassthroughFallbacks opens that use normal I/O although
+	// MountOptions.Passthrough is set
+	PassthroughOpens     uint64
+	PassthroughFallbacks uint64
+
+	// Time is when the snapshot was taken
+	Time time.Time
+
+	// Interval is the time covered by a Delta, zero for plain snapshots
+	Interval time.Duration
+}
+
+// OpStats contains statistics for a single operation type
+type OpStats struct {
+	Count  uint64 // Number of times the operation ran
+	Errors uint64 // Number of times it returned a non-zero errno
+	Bytes  uint64 // Bytes transferred (Read, Write)
+
+	// Latency is the total time spent serving the operation,
+	// including interceptors and caching
+	Latency Histogram
+
+	// BackendLatency is the time spent in calls to the absfs backend
+	BackendLatency Histogram
+}
+
+// latencyBounds are the upper bucket bounds of latency histograms
+var latencyBounds = [...]time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a snapshot of a latency distribution.
+//
+// Counts[i] holds the number of observations no greater than Bounds[i] and
+// greater than Bounds[i-1]. The final element of Counts holds observations
+// above the last bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Mean returns the average observed latency
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket containing quantile q
+// (0 < q <= 1). Observations above the last bound report the last bound.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 || len(h.Bounds) == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, count := range h.Counts {
+		seen += count
+		if seen >= rank {
+			if i < len(h.Bounds) {
+				return h.Bounds[i]
+			}
+			break
+		}
+	}
+
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// sub returns h minus prev
+func (h Histogram) sub(prev Histogram) Histogram {
+	out := Histogram{
+		Bounds: h.Bounds,
+		Counts: make([]uint64, len(h.Counts)),
+		Count:  h.Count - prev.Count,
+		Sum:    h.Sum - prev.Sum,
+	}
+	for i := range h.Counts {
+		out.Counts[i] = h.Counts[i]
+		if i < len(prev.Counts) {
+			out.Counts[i] -= prev.Counts[i]
+		}
+	}
+	return out
+}
+
+// Delta returns the statistics accumulated between prev and s, for
+// computing rates between two snapshots taken from the same filesystem.
+//
+// Counters are subtracted; gauges such as OpenFiles and cache sizes are
+// taken from s. Interval is set to the time between the snapshots.
+//
+// Example:
+//
+//	prev := fuseFS.Stats()
+//	time.Sleep(10 * time.Second)
+//	d := fuseFS.Stats().Delta(prev)
+//	fmt.Printf("%.1f ops/s\n", float64(d.Operations)/d.Interval.Seconds())
+func (s Stats) Delta(prev Stats) Stats {
+	d := s
+	d.Operations -= prev.Operations
+	d.BytesRead -= prev.BytesRead
+	d.BytesWritten -= prev.BytesWritten
+	d.Errors -= prev.Errors
+	d.InodeStats.AttrCache = s.InodeStats.AttrCache.sub(prev.InodeStats.AttrCache)
+	d.InodeStats.DirCache = s.InodeStats.DirCache.sub(prev.InodeStats.DirCache)
+	d.DirRenames.Completed -= prev.DirRenames.Completed
+	d.DirRenames.Failed -= prev.DirRenames.Failed
+	d.DirRenames.Resumed -= prev.DirRenames.Resumed
+	d.DirRenames.RolledBack -= prev.DirRenames.RolledBack
+	d.PassthroughOpens -= prev.PassthroughOpens
+	d.PassthroughFallbacks -= prev.PassthroughFallbacks
+	d.Interval = s.Time.Sub(prev.Time)
+
+	d.Ops = make(map[string]OpStats, len(s.Ops))
+	for name, cur := range s.Ops {
+		old := prev.Ops[name]
+		d.Ops[name] = OpStats{
+			Count:          cur.Count - old.Count,
+			Errors:         cur.Errors - old.Errors,
+			Bytes:          cur.Bytes - old.Bytes,
+			Latency:        cur.Latency.sub(old.Latency),
+			BackendLatency: cur.BackendLatency.sub(old.BackendLatency),
+		}
+	}
+
+	d.Errnos = make(map[syscall.Errno]uint64, len(s.Errnos))
+	for errno, count := range s.Errnos {
+		if diff := count - prev.Errnos[errno]; diff > 0 {
+			d.Errnos[errno] = diff
+		}
+	}
+
+	return d
+}
+
+// add returns the combined statistics of the filesystems s and other.
+// Mountpoint and Root are cleared; Time is the later of the two.
+func (s Stats) add(other Stats) Stats {
+	out := Stats{
+		Operations:   s.Operations + other.Operations,
+		BytesRead:    s.BytesRead + other.BytesRead,
+		BytesWritten: s.BytesWritten + other.BytesWritten,
+		Errors:       s.Errors + other.Errors,
+		OpenFiles:    s.OpenFiles + other.OpenFiles,
+		InodeStats: InodeManagerStats{
+			TotalInodes: s.InodeStats.TotalInodes + other.InodeStats.TotalInodes,
+			AttrCache:   s.InodeStats.AttrCache.add(other.InodeStats.AttrCache),
+			DirCache:    s.InodeStats.DirCache.add(other.InodeStats.DirCache),
+		},
+		Ops:                  make(map[string]OpStats, len(s.Ops)),
+		Errnos:               make(map[syscall.Errno]uint64, len(s.Errnos)),
+		DirRenames:           s.DirRenames.add(other.DirRenames),
+		PassthroughOpens:     s.PassthroughOpens + other.PassthroughOpens,
+		PassthroughFallbacks: s.PassthroughFallbacks + other.PassthroughFallbacks,
+		Time:                 s.Time,
+		Interval:             max(s.Interval, other.Interval),
+	}
+	if other.Time.After(out.Time) {
+		out.Time = other.Time
+	}
+
+	for _, ops := range []map[string]OpStats{s.Ops, other.Ops} {
+		for name, op := range ops {
+			sum := out.Ops[name]
+			out.Ops[name] = OpStats{
+				Count:          sum.Count + op.Count,
+				Errors:         sum.Errors + op.Errors,
+				Bytes:          sum.Bytes + op.Bytes,
+				Latency:        sum.Latency.add(op.Latency),
+				BackendLatency: sum.BackendLatency.add(op.BackendLatency),
+			}
+		}
+	}
+
+	for _, errnos := range []map[syscall.Errno]uint64{s.Errnos, other.Errnos} {
+		for errno, count := range errnos {
+			out.Errnos[errno] += count
+		}
+	}
+
+	return out
+}
+
+// add returns the combined distribution of h and other, which must
+// use the same bounds or be empty
+func (h Histogram) add(other Histogram) Histogram {
+	if h.Bounds == nil {
+		h.Bounds = other.Bounds
+	}
+	out := Histogram{
+		Bounds: h.Bounds,
+		Counts: make([]uint64, max(len(h.Counts), len(other.Counts))),
+		Count:  h.Count + other.Count,
+		Sum:    h.Sum + other.Sum,
+	}
+	for i := range out.Counts {
+		if i < len(h.Counts) {
+			out.Counts[i] += h.Counts[i]
+		}
+		if i < len(other.Counts) {
+			out.Counts[i] += other.Counts[i]
+		}
+	}
+	return out
 }
 
+// maxTrackedErrno bounds the errno values counted individually; larger
+// values are counted under maxTrackedErrno
+const maxTrackedErrno = 255
+
 // statsCollector tracks filesystem statistics
 type statsCollector struct {
 	operations   atomic.Uint64
 	bytesRead    atomic.Uint64
 	bytesWritten atomic.Uint64
 	errors       atomic.Uint64
+
+	// ops holds per-operation counters, indexed like opNames
+	ops []opCounters
+
+	// errnos counts errno results, indexed by errno value
+	errnos [maxTrackedErrno + 1]atomic.Uint64
+
+	// dirRenames tracks emulated directory renames
+	dirRenames dirRenameStats
+
+	// passthroughOpens and passthroughFallbacks count the opens with
+	// MountOptions.Passthrough set, by whether they went passthrough
+	passthroughOpens     atomic.Uint64
+	passthroughFallbacks atomic.Uint64
+}
+
+// opCounters tracks statistics for a single operation type
+type opCounters struct {
+	count   atomic.Uint64
+	errors  atomic.Uint64
+	bytes   atomic.Uint64
+	latency latencyHistogram
+	backend latencyHistogram
 }
 
+// latencyHistogram is a lock-free histogram using latencyBounds
+type latencyHistogram struct {
+	buckets [len(latencyBounds) + 1]atomic.Uint64
+	count   atomic.Uint64
+	sum     atomic.Int64
+}
+
+// opIndex maps operation names to their index in statsCollector.ops
+var opIndex = func() map[string]int {
+	index := make(map[string]int, len(opNames))
+	for i, name := range opNames {
+		index[name] = i
+	}
+	return index
+}()
+
 // newStatsCollector creates a new statistics collector
 func newStatsCollector() *statsCollector {
-	return &statsCollector{}
+	return &statsCollector{
+		ops: make([]opCounters, len(opNames)),
+	}
 }
 
 // recordOperation increments the operation counter
@@ -58,12 +352,107 @@ func (s *statsCollector) recordError() {
 	s.errors.Add(1)
 }
 
+// recordOp records the outcome of a completed operation
+func (s *statsCollector) recordOp(name string, latency, backend time.Duration, bytes int64, errno syscall.Errno) {
+	if errno != 0 {
+		s.errnos[min(int(errno), maxTrackedErrno)].Add(1)
+	}
+
+	i, ok := opIndex[name]
+	if !ok {
+		return
+	}
+
+	c := &s.ops[i]
+	c.count.Add(1)
+	if errno != 0 {
+		c.errors.Add(1)
+	}
+	if bytes > 0 {
+		c.bytes.Add(uint64(bytes))
+	}
+	c.latency.observe(latency)
+	if backend > 0 {
+		c.backend.observe(backend)
+	}
+}
+
+// observe adds a single observation to the histogram
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+// snapshot returns the current histogram values
+func (h *latencyHistogram) snapshot() Histogram {
+	out := Histogram{
+		Bounds: latencyBounds[:],
+		Counts: make([]uint64, len(h.buckets)),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.buckets {
+		out.Counts[i] = h.buckets[i].Load()
+	}
+	return out
+}
+
 // snapshot returns current statistics
 func (s *statsCollector) snapshot() Stats {
-	return Stats{
-		Operations:   s.operations.Load(),
-		BytesRead:    s.bytesRead.Load(),
-		BytesWritten: s.bytesWritten.Load(),
-		Errors:       s.errors.Load(),
+	stats := Stats{
+		Operations:           s.operations.Load(),
+		BytesRead:            s.bytesRead.Load(),
+		BytesWritten:         s.bytesWritten.Load(),
+		Errors:               s.errors.Load(),
+		Ops:                  make(map[string]OpStats),
+		Errnos:               make(map[syscall.Errno]uint64),
+		DirRenames:           s.dirRenames.snapshot(),
+		PassthroughOpens:     s.passthroughOpens.Load(),
+		PassthroughFallbacks: s.passthroughFallbacks.Load(),
+		Time:                 time.Now(),
+	}
+
+	for i := range s.ops {
+		c := &s.ops[i]
+		count := c.count.Load()
+		if count == 0 {
+			continue
+		}
+		stats.Ops[opNames[i]] = OpStats{
+			Count:          count,
+			Errors:         c.errors.Load(),
+			Bytes:          c.bytes.Load(),
+			Latency:        c.latency.snapshot(),
+			BackendLatency: c.backend.snapshot(),
+		}
+	}
+
+	for errno := range s.errnos {
+		if count := s.errnos[errno].Load(); count > 0 {
+			stats.Errnos[syscall.Errno(errno)] = count
+		}
+	}
+
+	return stats
+}
+
+// opContextKey is the context key for the operation being served
+type opContextKey struct{}
+
+// withOp returns a context carrying op
+func withOp(ctx context.Context, op *Op) context.Context {
+	return context.WithValue(ctx, opContextKey{}, op)
+}
+
+// timeBackend adds the time since start to the backend latency of the
+// operation carried by ctx
+func timeBackend(ctx context.Context, start time.Time) {
+	if op, ok := ctx.Value(opContextKey{}).(*Op); ok {
+		op.backend += time.Since(start)
 	}
 }
@@ -1,7 +1,10 @@
 package fusefs
 
 import (
+	"context"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestStatsCollector_RecordOperation(t *testing.T) {
@@ -126,3 +129,149 @@ func TestStatsCollector_Concurrent(t *testing.T) {
 		t.Errorf("Errors = %d, want 1000", stats.Errors)
 	}
 }
+
+func TestStatsCollector_RecordOp(t *testing.T) {
+	sc := newStatsCollector()
+
+	sc.recordOp(OpRead, 20*time.Microsecond, 15*time.Microsecond, 4096, 0)
+	sc.recordOp(OpRead, 2*time.Millisecond, time.Millisecond, 1024, 0)
+	sc.recordOp(OpLookup, 5*time.Microsecond, 0, 0, syscall.ENOENT)
+	sc.recordOp(OpLookup, 5*time.Microsecond, 0, 0, syscall.ENOENT)
+	sc.recordOp(OpWrite, time.Minute, 0, 0, syscall.EIO)
+
+	stats := sc.snapshot()
+
+	read := stats.Ops[OpRead]
+	if read.Count != 2 || read.Errors != 0 || read.Bytes != 5120 {
+		t.Errorf("Read = %+v, want Count=2 Errors=0 Bytes=5120", read)
+	}
+	if read.Latency.Count != 2 || read.Latency.Sum != 2020*time.Microsecond {
+		t.Errorf("Read latency count=%d sum=%v, want 2 and 2.02ms", read.Latency.Count, read.Latency.Sum)
+	}
+	if read.BackendLatency.Count != 2 {
+		t.Errorf("Read backend latency count = %d, want 2", read.BackendLatency.Count)
+	}
+
+	lookup := stats.Ops[OpLookup]
+	if lookup.Count != 2 || lookup.Errors != 2 {
+		t.Errorf("Lookup = %+v, want Count=2 Errors=2", lookup)
+	}
+	if lookup.BackendLatency.Count != 0 {
+		t.Errorf("Lookup backend latency count = %d, want 0", lookup.BackendLatency.Count)
+	}
+
+	if _, ok := stats.Ops[OpMkdir]; ok {
+		t.Error("Mkdir present in Ops although it never ran")
+	}
+
+	if stats.Errnos[syscall.ENOENT] != 2 || stats.Errnos[syscall.EIO] != 1 {
+		t.Errorf("Errnos = %v, want ENOENT=2 EIO=1", stats.Errnos)
+	}
+
+	// Observations above the last bound land in the overflow bucket
+	write := stats.Ops[OpWrite].Latency
+	if write.Counts[len(write.Counts)-1] != 1 {
+		t.Errorf("overflow bucket = %d, want 1", write.Counts[len(write.Counts)-1])
+	}
+}
+
+func TestHistogram_Buckets(t *testing.T) {
+	var h latencyHistogram
+	h.observe(10 * time.Microsecond) // boundary belongs to the lower bucket
+	h.observe(11 * time.Microsecond)
+	h.observe(3 * time.Millisecond)
+
+	snap := h.snapshot()
+	if len(snap.Counts) != len(snap.Bounds)+1 {
+		t.Fatalf("len(Counts) = %d, want %d", len(snap.Counts), len(snap.Bounds)+1)
+	}
+	if snap.Counts[0] != 1 || snap.Counts[1] != 1 {
+		t.Errorf("Counts[0:2] = %v, want [1 1]", snap.Counts[:2])
+	}
+	if snap.Mean() != (3021*time.Microsecond)/3 {
+		t.Errorf("Mean = %v, want %v", snap.Mean(), (3021*time.Microsecond)/3)
+	}
+	if q := snap.Quantile(0.5); q != 50*time.Microsecond {
+		t.Errorf("Quantile(0.5) = %v, want 50µs", q)
+	}
+	if q := snap.Quantile(1); q != 5*time.Millisecond {
+		t.Errorf("Quantile(1) = %v, want 5ms", q)
+	}
+
+	var empty Histogram
+	if empty.Mean() != 0 || empty.Quantile(0.9) != 0 {
+		t.Error("empty histogram should report zero")
+	}
+}
+
+func TestStats_Delta(t *testing.T) {
+	sc := newStatsCollector()
+	sc.recordOperation()
+	sc.recordRead(100)
+	sc.recordOp(OpRead, time.Millisecond, 0, 100, 0)
+	sc.recordOp(OpLookup, time.Millisecond, 0, 0, syscall.ENOENT)
+
+	prev := sc.snapshot()
+	prev.InodeStats.AttrCache = CacheStats{Hits: 10, Misses: 10, Size: 5}
+
+	sc.recordOperation()
+	sc.recordOperation()
+	sc.recordRead(50)
+	sc.recordOp(OpRead, time.Millisecond, 0, 50, 0)
+	sc.recordOp(OpWrite, time.Millisecond, 0, 10, 0)
+
+	cur := sc.snapshot()
+	cur.Time = prev.Time.Add(2 * time.Second)
+	cur.InodeStats.AttrCache = CacheStats{Hits: 40, Misses: 10, Size: 7}
+
+	d := cur.Delta(prev)
+	if d.Operations != 2 || d.BytesRead != 50 {
+		t.Errorf("Delta Operations=%d BytesRead=%d, want 2 and 50", d.Operations, d.BytesRead)
+	}
+	if d.Interval != 2*time.Second {
+		t.Errorf("Interval = %v, want 2s", d.Interval)
+	}
+	if d.Ops[OpRead].Count != 1 || d.Ops[OpRead].Bytes != 50 || d.Ops[OpRead].Latency.Count != 1 {
+		t.Errorf("Delta Read = %+v, want one 50 byte read", d.Ops[OpRead])
+	}
+	if d.Ops[OpWrite].Count != 1 {
+		t.Errorf("Delta Write count = %d, want 1", d.Ops[OpWrite].Count)
+	}
+	if d.Ops[OpLookup].Count != 0 {
+		t.Errorf("Delta Lookup count = %d, want 0", d.Ops[OpLookup].Count)
+	}
+	if _, ok := d.Errnos[syscall.ENOENT]; ok {
+		t.Error("unchanged errno present in Delta")
+	}
+	attr := d.InodeStats.AttrCache
+	if attr.Hits != 30 || attr.Misses != 0 || attr.Size != 7 || attr.HitRate != 1 {
+		t.Errorf("Delta AttrCache = %+v, want Hits=30 Misses=0 Size=7 HitRate=1", attr)
+	}
+}
+
+func TestFuseFS_OpStats(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+
+	op := &Op{Name: OpWrite, Handle: 1}
+	errno := f.intercept(context.Background(), op, func(ctx context.Context) syscall.Errno {
+		start := time.Now()
+		time.Sleep(time.Millisecond)
+		timeBackend(ctx, start)
+		op.bytes = 512
+		return 0
+	})
+	if errno != 0 {
+		t.Fatalf("intercept returned %v, want 0", errno)
+	}
+
+	write := f.Stats().Ops[OpWrite]
+	if write.Count != 1 || write.Bytes != 512 {
+		t.Errorf("Write = %+v, want Count=1 Bytes=512", write)
+	}
+	if write.BackendLatency.Count != 1 || write.BackendLatency.Sum < time.Millisecond {
+		t.Errorf("backend latency = %+v, want one observation of at least 1ms", write.BackendLatency)
+	}
+	if write.Latency.Sum < write.BackendLatency.Sum {
+		t.Errorf("total latency %v below backend latency %v", write.Latency.Sum, write.BackendLatency.Sum)
+	}
+}
@@ -0,0 +1,53 @@
+package fusefs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/absfs/absfs"
+)
+
+// SwapFS replaces the filesystem served by the mount without unmounting.
+//
+// The switch is atomic: operations that start after SwapFS returns are
+// served by newFS, while files opened before it keep using the old
+// filesystem until they are closed. The user-space and kernel caches are
+// invalidated, so directory listings and attributes are re-read from newFS.
+// Processes with their working directory inside the mount keep working as
+// long as the directory exists in newFS.
+//
+// The mount root (MountOptions.Root) must be a directory of newFS. SwapFS
+// is not supported for composite mounts created by MountMulti.
+func (f *FuseFS) SwapFS(newFS absfs.FileSystem) error {
+	if newFS == nil {
+		return fmt.Errorf("filesystem cannot be nil")
+	}
+	if f.root == nil {
+		return fmt.Errorf("SwapFS is not supported for composite mounts")
+	}
+	if f.checkUnmounting() {
+		return fmt.Errorf("filesystem is unmounting")
+	}
+
+	b := f.root.backend
+	if info, err := newFS.Stat(b.rootPath); err != nil {
+		return fmt.Errorf("failed to stat root %s: %w", b.rootPath, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("root %s is not a directory", b.rootPath)
+	}
+
+	b.fsys.Store(&newFS)
+
+	// Forget everything learned from the old filesystem, including inode
+	// numbers, then have the kernel look everything up again
+	b.inodes.Clear()
+	f.invalidateTree(b.mountPath)
+
+	if f.logEnabled(context.Background(), slog.LevelInfo) {
+		f.options().Logger.Info("filesystem swapped",
+			slog.String("mountpoint", f.options().Mountpoint),
+			slog.Int("open_files", f.handleTracker.Count()))
+	}
+	return nil
+}
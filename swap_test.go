@@ -0,0 +1,66 @@
+package fusefs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestSwapFS(t *testing.T) {
+	oldFS := &linkFS{dirs: map[string]bool{"/": true}}
+	f := newFuseFS(oldFS, DefaultMountOptions("/mnt/data"))
+	b := f.root.backend
+
+	file := newMockFile("/open.txt")
+	fh := f.handleTracker.Add(file, os.O_RDWR, "/open.txt")
+	b.inodes.Cache("/open.txt", &fuse.Attr{Ino: 2})
+	b.inodes.CacheDir("/", []fuse.DirEntry{{Name: "open.txt"}})
+
+	newFS := &linkFS{dirs: map[string]bool{"/": true}}
+	if err := f.SwapFS(newFS); err != nil {
+		t.Fatalf("SwapFS: %v", err)
+	}
+
+	if b.fs() != newFS {
+		t.Error("backend not switched to the new filesystem")
+	}
+	if b.inodes.GetCached("/open.txt") != nil || b.inodes.GetDirCache("/") != nil {
+		t.Error("caches not invalidated")
+	}
+
+	// Open handles stay on the old filesystem's file
+	if f.handleTracker.Get(fh) != file {
+		t.Error("open handle lost across swap")
+	}
+}
+
+func TestSwapFS_Errors(t *testing.T) {
+	oldFS := &linkFS{dirs: map[string]bool{"/": true, "/sub": true}}
+	opts := DefaultMountOptions("/mnt/data")
+	opts.Root = "/sub"
+	f := newFuseFS(oldFS, opts)
+
+	if err := f.SwapFS(nil); err == nil {
+		t.Error("SwapFS(nil) succeeded")
+	}
+
+	// The mount root must exist in the new filesystem
+	if err := f.SwapFS(&linkFS{dirs: map[string]bool{"/": true}}); err == nil {
+		t.Error("SwapFS without root directory succeeded")
+	}
+	if f.root.backend.fs() != oldFS {
+		t.Error("failed swap changed the backend")
+	}
+
+	composite := newTestComposite(t, "/a")
+	if err := composite.SwapFS(oldFS); err == nil {
+		t.Error("SwapFS on composite mount succeeded")
+	}
+
+	f.ops.close()
+	f.unmounting.Store(true)
+	if err := f.SwapFS(oldFS); err == nil {
+		t.Error("SwapFS while unmounting succeeded")
+	}
+}
@@ -0,0 +1,218 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultUnmountTimeout bounds how long Unmount waits for in-flight
+// operations and handle flushes before force-closing handles
+const defaultUnmountTimeout = 10 * time.Second
+
+// UnmountReport describes the outcome of a graceful unmount
+type UnmountReport struct {
+	// Drained reports whether all in-flight operations completed before
+	// the deadline
+	Drained bool
+
+	// InFlight is the number of operations still running when open
+	// handles were force-closed
+	InFlight int
+
+	// Flushed lists handles with unsynced writes that were flushed
+	Flushed []HandleInfo
+
+	// ForceClosed lists handles that were still open at unmount and were
+	// closed without a release from the kernel
+	ForceClosed []HandleInfo
+}
+
+// opTracker counts in-flight operations and stops admitting new ones once
+// closed, so that unmount can wait for running operations to finish
+type opTracker struct {
+	mu       sync.Mutex
+	closed   bool
+	inflight int
+	idle     chan struct{}
+}
+
+// begin admits an operation, returning false if the tracker is closed and
+// the operation may not start. Each admitted operation must call end.
+func (t *opTracker) begin(draining bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed && !draining {
+		return false
+	}
+	t.inflight++
+	return true
+}
+
+// end marks an admitted operation as finished
+func (t *opTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inflight--
+	if t.inflight == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// close stops admitting operations. It returns false if the tracker was
+// already closed.
+func (t *opTracker) close() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return false
+	}
+	t.closed = true
+	return true
+}
+
+// count returns the number of in-flight operations
+func (t *opTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.inflight
+}
+
+// wait blocks until no operations are in flight or ctx is done
+func (t *opTracker) wait(ctx context.Context) error {
+	t.mu.Lock()
+	if t.inflight == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainingOp reports whether an operation may still start while unmounting.
+// Only operations that close or flush handles are admitted, so that data
+// already written can reach the backend.
+func drainingOp(name string) bool {
+	switch name {
+	case OpFlush, OpFsync, OpRelease:
+		return true
+	}
+	return false
+}
+
+// UnmountContext gracefully unmounts the filesystem:
+//  1. New operations are rejected with ENOTCONN, except flushes and
+//     releases of open handles
+//  2. In-flight operations are waited for, then handles with unsynced
+//     writes and writable passthrough handles are flushed, until ctx is
+//     done
+//  3. Remaining open handles are force-closed, caches are cleared and the
+//     FUSE filesystem is unmounted
+//
+// The report lists the handles that were flushed and force-closed. The
+// returned error joins any flush errors with the error from unmounting.
+// If unmounting the FUSE filesystem fails, e.g. with EBUSY, calling
+// UnmountContext again retries it with an empty report. Once it has
+// succeeded, further calls return an empty report and no error.
+func (f *FuseFS) UnmountContext(ctx context.Context) (*UnmountReport, error) {
+	f.unmountMu.Lock()
+	defer f.unmountMu.Unlock()
+
+	report := &UnmountReport{}
+	if f.unmounted {
+		return report, nil
+	}
+
+	var errs []error
+	if f.ops.close() {
+		errs = f.drain(ctx, report)
+	}
+
+	// Unmount FUSE filesystem
+	if f.kernelUnmount != nil {
+		if err := f.kernelUnmount(); err != nil {
+			return report, errors.Join(append(errs, err)...)
+		}
+	}
+	f.unmounted = true
+
+	return report, errors.Join(errs...)
+}
+
+// isUnmounted reports whether UnmountContext has unmounted the FUSE
+// filesystem, after which its server exits
+func (f *FuseFS) isUnmounted() bool {
+	f.unmountMu.Lock()
+	defer f.unmountMu.Unlock()
+
+	return f.unmounted
+}
+
+// drain performs the first steps of UnmountContext, up to clearing the
+// caches, and returns the flush errors
+func (f *FuseFS) drain(ctx context.Context, report *UnmountReport) []error {
+	f.unmounting.Store(true)
+	if f.controlSocket != nil {
+		f.controlSocket.close()
+	}
+
+	// Wait for in-flight operations
+	report.Drained = f.ops.wait(ctx) == nil
+
+	// Flush handles with unsynced writes
+	var errs []error
+	for _, info := range f.handleTracker.List() {
+		if !info.Dirty {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err := f.handleTracker.Sync(info.ID); err != nil {
+			errs = append(errs, fmt.Errorf("flush %s: %w", info.Path, err))
+			continue
+		}
+		info.Dirty = false
+		report.Flushed = append(report.Flushed, info)
+	}
+
+	// Force-close whatever is still open
+	report.InFlight = f.ops.count()
+	report.ForceClosed = f.handleTracker.CloseAll()
+
+	// Clear caches
+	for _, b := range f.backends {
+		b.inodes.Clear()
+	}
+
+	return errs
+}
+
+// unmountInterceptor tracks in-flight operations and rejects new ones once
+// unmounting has started
+func (f *FuseFS) unmountInterceptor(ctx context.Context, op *Op, next OpFunc) syscall.Errno {
+	if !f.ops.begin(drainingOp(op.Name)) {
+		return syscall.ENOTCONN
+	}
+	defer f.ops.end()
+
+	return next(ctx, op)
+}
@@ -0,0 +1,165 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// syncFailFile is a mock file whose Sync fails
+type syncFailFile struct {
+	mockFile
+}
+
+func (f *syncFailFile) Sync() error {
+	return errors.New("sync failed")
+}
+
+func TestUnmountContext_DrainsInFlight(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+	file := newMockFile("/data.bin")
+	fh := f.handleTracker.Add(file, syscall.O_WRONLY, "/data.bin")
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	done := make(chan syscall.Errno)
+	go func() {
+		op := &Op{Name: OpWrite, Path: "/data.bin", Handle: fh}
+		done <- f.intercept(context.Background(), op, func(ctx context.Context) syscall.Errno {
+			close(started)
+			<-finish
+			file.Write([]byte("payload"))
+			f.handleTracker.MarkDirty(fh)
+			return 0
+		})
+	}()
+	<-started
+
+	reportc := make(chan *UnmountReport)
+	go func() {
+		report, err := f.UnmountContext(context.Background())
+		if err != nil {
+			t.Errorf("UnmountContext: %v", err)
+		}
+		reportc <- report
+	}()
+
+	// Unmount waits for the running write
+	select {
+	case <-reportc:
+		t.Fatal("UnmountContext returned while a write was in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if f.intercept(context.Background(), &Op{Name: OpRead, Handle: fh}, nil) != syscall.ENOTCONN {
+		t.Error("new operation admitted while draining")
+	}
+
+	close(finish)
+	if errno := <-done; errno != 0 {
+		t.Errorf("in-flight write returned %v", errno)
+	}
+	report := <-reportc
+
+	if !report.Drained || report.InFlight != 0 {
+		t.Errorf("Drained = %v, InFlight = %d, want drained", report.Drained, report.InFlight)
+	}
+	if len(report.Flushed) != 1 || report.Flushed[0].ID != fh {
+		t.Errorf("Flushed = %+v, want handle %d", report.Flushed, fh)
+	}
+	if len(report.ForceClosed) != 1 || report.ForceClosed[0].Dirty {
+		t.Errorf("ForceClosed = %+v, want one clean handle", report.ForceClosed)
+	}
+	if string(file.data) != "payload" || !file.closed {
+		t.Errorf("file data %q, closed %v", file.data, file.closed)
+	}
+}
+
+func TestUnmountContext_Deadline(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+	fh := f.handleTracker.Add(newMockFile("/slow"), syscall.O_RDONLY, "/slow")
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	go f.intercept(context.Background(), &Op{Name: OpRead, Handle: fh}, func(ctx context.Context) syscall.Errno {
+		close(started)
+		<-finish
+		return 0
+	})
+	<-started
+	defer close(finish)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	report, err := f.UnmountContext(ctx)
+	if err != nil {
+		t.Fatalf("UnmountContext: %v", err)
+	}
+	if report.Drained || report.InFlight != 1 {
+		t.Errorf("Drained = %v, InFlight = %d, want 1 undrained", report.Drained, report.InFlight)
+	}
+	if len(report.ForceClosed) != 1 || report.ForceClosed[0].Path != "/slow" {
+		t.Errorf("ForceClosed = %+v, want /slow", report.ForceClosed)
+	}
+}
+
+func TestUnmountContext_FlushError(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+	fh := f.handleTracker.Add(&syncFailFile{}, syscall.O_WRONLY, "/bad")
+	f.handleTracker.MarkDirty(fh)
+
+	report, err := f.UnmountContext(context.Background())
+	if err == nil {
+		t.Error("expected flush error")
+	}
+	if len(report.Flushed) != 0 {
+		t.Errorf("Flushed = %+v, want none", report.Flushed)
+	}
+	if len(report.ForceClosed) != 1 || !report.ForceClosed[0].Dirty {
+		t.Errorf("ForceClosed = %+v, want one dirty handle", report.ForceClosed)
+	}
+
+	// Subsequent calls are no-ops
+	report, err = f.UnmountContext(context.Background())
+	if err != nil || len(report.ForceClosed) != 0 {
+		t.Errorf("second UnmountContext = %+v, %v", report, err)
+	}
+}
+
+func TestUnmountContext_RetriesFailedUnmount(t *testing.T) {
+	f := newFuseFS(nil, DefaultMountOptions("/tmp/fusefs-test"))
+	f.handleTracker.Add(newMockFile("/open"), syscall.O_RDONLY, "/open")
+
+	calls := 0
+	f.kernelUnmount = func() error {
+		calls++
+		if calls == 1 {
+			return syscall.EBUSY
+		}
+		return nil
+	}
+
+	report, err := f.UnmountContext(context.Background())
+	if !errors.Is(err, syscall.EBUSY) {
+		t.Fatalf("first UnmountContext error = %v, want EBUSY", err)
+	}
+	if len(report.ForceClosed) != 1 {
+		t.Errorf("ForceClosed = %+v, want /open", report.ForceClosed)
+	}
+
+	// The next call retries the unmount without draining again
+	report, err = f.UnmountContext(context.Background())
+	if err != nil || calls != 2 {
+		t.Fatalf("second UnmountContext = %v after %d calls, want success after 2", err, calls)
+	}
+	if len(report.ForceClosed) != 0 {
+		t.Errorf("second report ForceClosed = %+v, want none", report.ForceClosed)
+	}
+
+	// Once unmounted, further calls do nothing
+	if _, err := f.UnmountContext(context.Background()); err != nil || calls != 2 {
+		t.Errorf("third UnmountContext = %v after %d calls, want no-op", err, calls)
+	}
+}
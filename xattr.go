@@ -3,6 +3,7 @@ package fusefs
 import (
 	"context"
 	"syscall"
+	"time"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 )
@@ -81,23 +82,28 @@ const (
 
 // Getxattr retrieves an extended attribute value
 func (n *fuseNode) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return 0, syscall.ENOTCONN
-	}
+	var size uint32
+	errno := n.fusefs.intercept(ctx, newOp(ctx, OpGetxattr, n.path()), func(ctx context.Context) (errno syscall.Errno) {
+		size, errno = n.getxattr(ctx, attr, dest)
+		return errno
+	})
+	return size, errno
+}
 
+// getxattr implements Getxattr
+func (n *fuseNode) getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
 	// Check if filesystem supports xattrs
-	xattrFS, ok := n.fusefs.absFS.(XAttrFS)
+	xattrFS, ok := n.backend.fs().(XAttrFS)
 	if !ok {
 		return 0, syscall.ENOTSUP
 	}
 
 	// Get attribute value
-	value, err := xattrFS.GetXAttr(n.path, attr)
+	start := time.Now()
+	value, err := xattrFS.GetXAttr(n.path(), attr)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return 0, mapError(err)
+		return 0, n.fusefs.backendError(ctx, err)
 	}
 
 	// If dest is nil, return size needed
@@ -112,23 +118,25 @@ func (n *fuseNode) Getxattr(ctx context.Context, attr string, dest []byte) (uint
 
 // Setxattr sets an extended attribute value
 func (n *fuseNode) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
-	}
+	return n.fusefs.intercept(ctx, newOp(ctx, OpSetxattr, n.path()), func(ctx context.Context) syscall.Errno {
+		return n.setxattr(ctx, attr, data, flags)
+	})
+}
 
+// setxattr implements Setxattr
+func (n *fuseNode) setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
 	// Check if filesystem supports xattrs
-	xattrFS, ok := n.fusefs.absFS.(XAttrFS)
+	xattrFS, ok := n.backend.fs().(XAttrFS)
 	if !ok {
 		return syscall.ENOTSUP
 	}
 
 	// Set attribute
-	err := xattrFS.SetXAttr(n.path, attr, data, int(flags))
+	start := time.Now()
+	err := xattrFS.SetXAttr(n.path(), attr, data, int(flags))
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return mapError(err)
+		return n.fusefs.backendError(ctx, err)
 	}
 
 	return 0
@@ -136,23 +144,28 @@ func (n *fuseNode) Setxattr(ctx context.Context, attr string, data []byte, flags
 
 // Listxattr lists all extended attribute names
 func (n *fuseNode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return 0, syscall.ENOTCONN
-	}
+	var size uint32
+	errno := n.fusefs.intercept(ctx, newOp(ctx, OpListxattr, n.path()), func(ctx context.Context) (errno syscall.Errno) {
+		size, errno = n.listxattr(ctx, dest)
+		return errno
+	})
+	return size, errno
+}
 
+// listxattr implements Listxattr
+func (n *fuseNode) listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
 	// Check if filesystem supports xattrs
-	xattrFS, ok := n.fusefs.absFS.(XAttrFS)
+	xattrFS, ok := n.backend.fs().(XAttrFS)
 	if !ok {
 		return 0, syscall.ENOTSUP
 	}
 
 	// List attributes
-	attrs, err := xattrFS.ListXAttr(n.path)
+	start := time.Now()
+	attrs, err := xattrFS.ListXAttr(n.path())
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return 0, mapError(err)
+		return 0, n.fusefs.backendError(ctx, err)
 	}
 
 	// Build null-terminated list
@@ -180,23 +193,25 @@ func (n *fuseNode) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.
 
 // Removexattr removes an extended attribute
 func (n *fuseNode) Removexattr(ctx context.Context, attr string) syscall.Errno {
-	n.fusefs.stats.recordOperation()
-
-	if n.fusefs.checkUnmounting() {
-		return syscall.ENOTCONN
-	}
+	return n.fusefs.intercept(ctx, newOp(ctx, OpRemovexattr, n.path()), func(ctx context.Context) syscall.Errno {
+		return n.removexattr(ctx, attr)
+	})
+}
 
+// removexattr implements Removexattr
+func (n *fuseNode) removexattr(ctx context.Context, attr string) syscall.Errno {
 	// Check if filesystem supports xattrs
-	xattrFS, ok := n.fusefs.absFS.(XAttrFS)
+	xattrFS, ok := n.backend.fs().(XAttrFS)
 	if !ok {
 		return syscall.ENOTSUP
 	}
 
 	// Remove attribute
-	err := xattrFS.RemoveXAttr(n.path, attr)
+	start := time.Now()
+	err := xattrFS.RemoveXAttr(n.path(), attr)
+	timeBackend(ctx, start)
 	if err != nil {
-		n.fusefs.stats.recordError()
-		return mapError(err)
+		return n.fusefs.backendError(ctx, err)
 	}
 
 	return 0